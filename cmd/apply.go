@@ -0,0 +1,127 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	cri "github.com/microsoft/hcnproxyctrl/cri"
+	proxy "github.com/microsoft/hcnproxyctrl/proxy"
+	"github.com/spf13/cobra"
+)
+
+// Flags for the "apply-to-containers" command
+var (
+	applyContainersFile string
+)
+
+var cmdApplyToContainers = &cobra.Command{
+	Use:   "apply-to-containers --file <container IDs file | ->",
+	Short: "Add a proxy policy to the endpoints of a list of containers",
+	Long: `Add a proxy policy to the endpoints of a list of containers.
+
+The containers of --file, one ID per line, are resolved to their endpoints
+from a single listing of the containers of the runtime, as with lookup, and the
+policy is added to each of their endpoints. The result is reported per
+container, and a summary is printed at the end.`,
+	Example: `  hcnproxyctrl.exe apply-to-containers --file containers.txt --port 15001 --usersid system`,
+	Args:    cobra.NoArgs,
+
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(applyContainersFile) == 0 {
+			errorOut(errors.New("--file must be specified"))
+		}
+		policy, err := policyFromFlags()
+		if err != nil {
+			errorOut(err)
+		}
+		containerIDs, err := readContainerIDs(applyContainersFile)
+		if err != nil {
+			errorOut(err)
+		}
+		if len(containerIDs) == 0 {
+			errorOut(errors.New("no container IDs to apply the policy to"))
+		}
+
+		if err := configureCRI(cmd); err != nil {
+			errorOut(err)
+		}
+		summary, err := applyToContainers(containerIDs, policy)
+		if err != nil {
+			errorOut(err)
+		}
+		summary.finish()
+	},
+}
+
+// applyToContainers adds the policy to the endpoints of each of the
+// containers, reporting the result per container. An error is returned if the
+// containers could not be listed.
+func applyToContainers(containerIDs []string, policy proxy.Policy) (batchSummary, error) {
+	endpoints, err := getEndpointsForContainers(containerIDs, runtimeEndpoint)
+	if _, ok := err.(*proxy.UnresolvedContainersError); err != nil && !ok {
+		return batchSummary{}, err
+	}
+
+	var summary batchSummary
+	for _, containerID := range containerIDs {
+		endpointIDs, ok := endpoints[containerID]
+		if !ok {
+			summary.fail(containerID, proxy.ErrEndpointNotAttached)
+			continue
+		}
+		if err := addToEndpoints(endpointIDs, policy); err != nil {
+			summary.fail(containerID, err)
+			continue
+		}
+		fmt.Printf("%s: added the policy to %s\n", containerID, strings.Join(endpointIDs, ","))
+		summary.succeeded++
+	}
+	return summary, nil
+}
+
+// readContainerIDs reads the container IDs of a file, or of stdin if path is
+// "-", one per line.
+func readContainerIDs(path string) ([]string, error) {
+	var r io.Reader = stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+	return readEndpointIDs(r)
+}
+
+// addToEndpoints adds the policy to each of the endpoints, stopping at the
+// first failure.
+func addToEndpoints(endpointIDs []string, policy proxy.Policy) error {
+	for _, endpointID := range endpointIDs {
+		err := callHNS(func() error {
+			return addPolicy(endpointID, policy)
+		})
+		if err != nil {
+			return fmt.Errorf("%s: %v", endpointID, err)
+		}
+		if err := audit("add", endpointID, fmt.Sprintf("%+v", policy)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(cmdApplyToContainers)
+
+	addPolicyFlags(cmdApplyToContainers)
+	cmdApplyToContainers.Flags().StringVar(&applyContainersFile, "file", "", `file holding the IDs of the containers, one per line, or "-" to read them from stdin`)
+	cmdApplyToContainers.Flags().StringVar(&runtimeEndpoint, "runtimeendpoint", "", "CRI RuntimeEndpoint to query container information from (if neither this, CONTAINER_RUNTIME_ENDPOINT nor the crictl config sets one, the well-known containerd and Docker endpoints are probed)")
+	cmdApplyToContainers.Flags().StringVar(&crictlConfig, "crictl-config", cri.DefaultCrictlConfigPath(), "crictl config file from which to read the runtime endpoint and timeout, when neither --runtimeendpoint nor CONTAINER_RUNTIME_ENDPOINT is set")
+}
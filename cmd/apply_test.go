@@ -0,0 +1,151 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package cmd
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	proxy "github.com/microsoft/hcnproxyctrl/proxy"
+)
+
+// captureOutput returns what fn prints to stdout and stderr.
+func captureOutput(t *testing.T, fn func()) (stdout, stderr string) {
+	t.Helper()
+	capture := func(f **os.File) func() string {
+		r, w, err := os.Pipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		previous := *f
+		*f = w
+		output := make(chan string)
+		go func() {
+			data, _ := ioutil.ReadAll(r)
+			output <- string(data)
+		}()
+		return func() string {
+			*f = previous
+			w.Close()
+			return <-output
+		}
+	}
+	restoreStdout, restoreStderr := capture(&os.Stdout), capture(&os.Stderr)
+	defer func() {
+		stdout, stderr = restoreStdout(), restoreStderr()
+	}()
+	fn()
+	return
+}
+
+// fakeContainers stubs the containers snapshot with the endpoints of each
+// container, and records the policies added to each endpoint, failing for
+// the endpoints of failing, for the duration of the test.
+func fakeContainers(t *testing.T, endpoints map[string][]string, failing map[string]bool) map[string][]proxy.Policy {
+	added := make(map[string][]proxy.Policy)
+	previousGet, previousAdd := getEndpointsForContainers, addPolicy
+	getEndpointsForContainers = func(containerIDs []string, runtimeEndpoint string) (map[string][]string, error) {
+		found := make(map[string][]string)
+		var unresolved []string
+		for _, containerID := range containerIDs {
+			if endpointIDs, ok := endpoints[containerID]; ok {
+				found[containerID] = endpointIDs
+			} else {
+				unresolved = append(unresolved, containerID)
+			}
+		}
+		if len(unresolved) > 0 {
+			return found, &proxy.UnresolvedContainersError{ContainerIDs: unresolved}
+		}
+		return found, nil
+	}
+	addPolicy = func(endpointID string, policy proxy.Policy) error {
+		if failing[endpointID] {
+			return errors.New("HNS failure")
+		}
+		added[endpointID] = append(added[endpointID], policy)
+		return nil
+	}
+	t.Cleanup(func() { getEndpointsForContainers, addPolicy = previousGet, previousAdd })
+	return added
+}
+
+func TestApplyToContainers(t *testing.T) {
+	added := fakeContainers(t,
+		map[string][]string{"web": {"ep1"}, "dual": {"ep2", "ep3"}, "broken": {"ep4", "ep5"}},
+		map[string]bool{"ep5": true},
+	)
+	policy := proxy.Policy{ProxyPort: "15001", UserSID: "S-1-5-18"}
+
+	var summary batchSummary
+	var err error
+	stdout, stderr := captureOutput(t, func() {
+		summary, err = applyToContainers([]string{"web", "unknown", "dual", "broken"}, policy)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantStdout := "web: added the policy to ep1\n" +
+		"dual: added the policy to ep2,ep3\n"
+	if stdout != wantStdout {
+		t.Errorf("stdout %q, want %q", stdout, wantStdout)
+	}
+	wantStderr := "unknown: " + proxy.ErrEndpointNotAttached.Error() + "\n" +
+		"broken: ep5: HNS failure\n"
+	if stderr != wantStderr {
+		t.Errorf("stderr %q, want %q", stderr, wantStderr)
+	}
+	if want := "Succeeded: 2, Failed: 2, Skipped: 0"; summary.String() != want {
+		t.Errorf("summary %q, want %q", summary, want)
+	}
+	if code := summary.exitCode(false); code != 1 {
+		t.Errorf("exit code %d, want 1", code)
+	}
+
+	var endpointIDs []string
+	for endpointID, policies := range added {
+		endpointIDs = append(endpointIDs, endpointID)
+		if len(policies) != 1 || policies[0] != policy {
+			t.Errorf("policies added to %s: %+v, want the policy once", endpointID, policies)
+		}
+	}
+	sort.Strings(endpointIDs)
+	// The endpoints of broken are added to up to the failing one.
+	if want := []string{"ep1", "ep2", "ep3", "ep4"}; !reflect.DeepEqual(endpointIDs, want) {
+		t.Errorf("policy added to %v, want %v", endpointIDs, want)
+	}
+}
+
+func TestApplyToContainersSuccess(t *testing.T) {
+	fakeContainers(t, map[string][]string{"web": {"ep1"}}, nil)
+
+	var summary batchSummary
+	stdout, stderr := captureOutput(t, func() {
+		summary, _ = applyToContainers([]string{"web"}, proxy.Policy{ProxyPort: "15001"})
+	})
+	if !strings.Contains(stdout, "web: added the policy to ep1") || len(stderr) > 0 {
+		t.Errorf("output %q, %q, want the success of web", stdout, stderr)
+	}
+	if code := summary.exitCode(false); code != 0 {
+		t.Errorf("exit code %d, want 0", code)
+	}
+}
+
+func TestApplyToContainersListingFailure(t *testing.T) {
+	previous := getEndpointsForContainers
+	getEndpointsForContainers = func([]string, string) (map[string][]string, error) {
+		return nil, errors.New("failed to connect")
+	}
+	t.Cleanup(func() { getEndpointsForContainers = previous })
+
+	if _, err := applyToContainers([]string{"web"}, proxy.Policy{ProxyPort: "15001"}); err == nil {
+		t.Error("applyToContainers succeeded without the containers")
+	}
+}
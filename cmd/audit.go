@@ -0,0 +1,65 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"time"
+)
+
+// auditLogPath is the file to which audit entries are appended. Auditing is
+// disabled if it is empty.
+var auditLogPath string
+
+// auditEntry records a change made to the proxy policies of an endpoint.
+// Entries are appended to the audit log as JSON lines.
+type auditEntry struct {
+	Timestamp time.Time `json:"timestamp"`
+	Operation string    `json:"operation"`
+	Endpoint  string    `json:"endpoint"`
+	Details   string    `json:"details"`
+	User      string    `json:"user"`
+}
+
+// audit appends an entry for the given operation to the audit log, if one is
+// configured. The entry is synced to disk before returning, and any failure
+// is reported so that changes are never silently left unaudited.
+func audit(operation, endpointID, details string) error {
+	if len(auditLogPath) == 0 {
+		return nil
+	}
+
+	entry := auditEntry{
+		Timestamp: time.Now().UTC(),
+		Operation: operation,
+		Endpoint:  endpointID,
+		Details:   details,
+	}
+	if u, err := user.Current(); err == nil {
+		entry.User = u.Username
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	f, err := os.OpenFile(auditLogPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("could not write the audit log: %v", err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(line); err != nil {
+		return fmt.Errorf("could not write the audit log: %v", err)
+	}
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("could not write the audit log: %v", err)
+	}
+	return f.Close()
+}
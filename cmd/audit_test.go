@@ -0,0 +1,136 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	proxy "github.com/microsoft/hcnproxyctrl/proxy"
+)
+
+// setAuditLog enables the audit log for the duration of the test, and returns
+// its path.
+func setAuditLog(t *testing.T) string {
+	previous := auditLogPath
+	auditLogPath = filepath.Join(t.TempDir(), "audit.log")
+	t.Cleanup(func() { auditLogPath = previous })
+	return auditLogPath
+}
+
+// readAuditLog returns the entries of the audit log.
+func readAuditLog(t *testing.T, path string) []auditEntry {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var entries []auditEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var entry auditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("invalid audit entry %q: %v", scanner.Text(), err)
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return entries
+}
+
+func TestAudit(t *testing.T) {
+	path := setAuditLog(t)
+
+	before := time.Now().UTC().Add(-time.Second)
+	if err := audit("add", "ep1", "policy 1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := audit("clear", "ep2", "removed 3 policies"); err != nil {
+		t.Fatal(err)
+	}
+
+	entries := readAuditLog(t, path)
+	want := []auditEntry{
+		{Operation: "add", Endpoint: "ep1", Details: "policy 1"},
+		{Operation: "clear", Endpoint: "ep2", Details: "removed 3 policies"},
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("audit log has %d entries, want %d", len(entries), len(want))
+	}
+	for i, entry := range entries {
+		if entry.Operation != want[i].Operation || entry.Endpoint != want[i].Endpoint || entry.Details != want[i].Details {
+			t.Errorf("entry %d is %+v, want %+v", i, entry, want[i])
+		}
+		if entry.Timestamp.Before(before) || entry.Timestamp.After(time.Now().UTC()) {
+			t.Errorf("entry %d has timestamp %v", i, entry.Timestamp)
+		}
+	}
+}
+
+func TestAuditDisabled(t *testing.T) {
+	previous := auditLogPath
+	auditLogPath = ""
+	defer func() { auditLogPath = previous }()
+
+	if err := audit("add", "ep", "policy"); err != nil {
+		t.Errorf("audit without an audit log: %v", err)
+	}
+}
+
+func TestAuditUnwritable(t *testing.T) {
+	setAuditLog(t)
+	auditLogPath = filepath.Join(auditLogPath, "missing", "audit.log")
+
+	if err := audit("add", "ep", "policy"); err == nil {
+		t.Error("audit succeeded without being able to write the audit log")
+	}
+}
+
+func TestMeasureRecordsEachChange(t *testing.T) {
+	policies := []proxy.Policy{{RemotePorts: "1"}, {RemotePorts: "2"}, {RemotePorts: "3"}}
+
+	var recorded []string
+	latencies, err := measure(policies, func(policy proxy.Policy) (func() error, error) {
+		return func() error {
+			recorded = append(recorded, policy.RemotePorts)
+			return nil
+		}, nil
+	})
+	if err != nil || len(latencies) != 3 {
+		t.Fatalf("measure = %v, %v, want 3 latencies", latencies, err)
+	}
+	if len(recorded) != 3 || recorded[0] != "1" || recorded[2] != "3" {
+		t.Errorf("recorded changes %v, want one per policy in order", recorded)
+	}
+
+	recordErr := errors.New("could not write the audit log")
+	_, err = measure(policies, func(policy proxy.Policy) (func() error, error) {
+		return func() error { return recordErr }, nil
+	})
+	if err != recordErr {
+		t.Errorf("measure error %v, want the error recording the change", err)
+	}
+
+	recorded = nil
+	_, err = measure(policies, func(policy proxy.Policy) (func() error, error) {
+		if policy.RemotePorts == "2" {
+			return nil, errors.New("add failed")
+		}
+		return func() error {
+			recorded = append(recorded, policy.RemotePorts)
+			return nil
+		}, nil
+	})
+	if err == nil || len(recorded) != 1 {
+		t.Errorf("measure = %v with changes %v recorded, want an error after recording the first change", err, recorded)
+	}
+}
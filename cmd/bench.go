@@ -0,0 +1,149 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package cmd
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	proxy "github.com/microsoft/hcnproxyctrl/proxy"
+	"github.com/spf13/cobra"
+)
+
+// benchRemoteAddress is the remote address filter of the policies applied by
+// the "bench" command. It belongs to TEST-NET-1 (RFC 5737), so the policies
+// never intercept actual traffic.
+const benchRemoteAddress = "192.0.2.1"
+
+// Flags for the "bench" command
+var (
+	benchEndpointID string
+	benchCount      int
+)
+
+var cmdBench = &cobra.Command{
+	Use:    "bench",
+	Short:  "Measure the latency of adding and removing proxy policies",
+	Args:   cobra.NoArgs,
+	Hidden: true,
+
+	Run: func(cmd *cobra.Command, args []string) {
+		if benchCount <= 0 {
+			errorOut(fmt.Errorf("invalid count: %d", benchCount))
+		}
+
+		addLatencies, removeLatencies, elapsed, err := runBench(benchEndpointID, benchCount)
+		if err != nil {
+			errorOut(err)
+		}
+
+		printLatencies("add", addLatencies)
+		printLatencies("remove", removeLatencies)
+		fmt.Printf("throughput: %.1f operations/s\n", float64(2*benchCount)/elapsed.Seconds())
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(cmdBench)
+
+	cmdBench.Flags().StringVar(&benchEndpointID, "endpoint", "", "ID of the HNS endpoint to apply the policies to")
+	cmdBench.MarkFlagRequired("endpoint")
+	cmdBench.Flags().IntVar(&benchCount, "count", 100, "number of policies to add and remove")
+}
+
+// runBench adds count throwaway policies to the endpoint one by one, then
+// removes them one by one, and returns the latency of each operation as well
+// as the total duration. The policies are cleared even if an operation fails.
+// Each change is audited, outside of the measured latencies.
+func runBench(endpointID string, count int) (addLatencies, removeLatencies []time.Duration, elapsed time.Duration, err error) {
+	benchFilter := proxy.PolicyFilter{RemoteAddresses: benchRemoteAddress}
+	defer func() {
+		numRemoved, clearErr := proxy.ClearPoliciesMatching(endpointID, benchFilter)
+		if clearErr == nil && numRemoved > 0 {
+			if auditErr := audit("clear", endpointID, fmt.Sprintf("removed %d policies matching %+v", numRemoved, benchFilter)); auditErr != nil && err == nil {
+				err = auditErr
+			}
+		}
+	}()
+
+	policies := make([]proxy.Policy, count)
+	for i := range policies {
+		policies[i] = proxy.Policy{
+			ProxyPort:       "15001",
+			RemoteAddresses: benchRemoteAddress,
+			RemotePorts:     strconv.Itoa(i + 1),
+		}
+	}
+
+	start := time.Now()
+	addLatencies, err = measure(policies, func(policy proxy.Policy) (func() error, error) {
+		if err := proxy.AddPolicy(endpointID, policy); err != nil {
+			return nil, err
+		}
+		return func() error {
+			return audit("add", endpointID, fmt.Sprintf("%+v", policy))
+		}, nil
+	})
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	removeLatencies, err = measure(policies, func(policy proxy.Policy) (func() error, error) {
+		filter := benchFilter
+		filter.RemotePorts = policy.RemotePorts
+		numRemoved, err := proxy.ClearPoliciesMatching(endpointID, filter)
+		if err != nil {
+			return nil, err
+		}
+		return func() error {
+			return audit("clear", endpointID, fmt.Sprintf("removed %d policies matching %+v", numRemoved, filter))
+		}, nil
+	})
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	return addLatencies, removeLatencies, time.Since(start), nil
+}
+
+// measure calls op on each policy and returns the duration of each call. op
+// returns a function recording the change it made, which is called outside of
+// the measured duration.
+func measure(policies []proxy.Policy, op func(proxy.Policy) (record func() error, err error)) ([]time.Duration, error) {
+	latencies := make([]time.Duration, 0, len(policies))
+	for _, policy := range policies {
+		start := time.Now()
+		record, err := op(policy)
+		if err != nil {
+			return nil, err
+		}
+		latencies = append(latencies, time.Since(start))
+		if err := record(); err != nil {
+			return nil, err
+		}
+	}
+	return latencies, nil
+}
+
+func printLatencies(op string, latencies []time.Duration) {
+	fmt.Printf("%s: p50=%v p90=%v p99=%v\n", op,
+		percentile(latencies, 50), percentile(latencies, 90), percentile(latencies, 99))
+}
+
+// percentile returns the p-th percentile of the latencies using the
+// nearest-rank method, or zero if there are none.
+func percentile(latencies []time.Duration, p int) time.Duration {
+	if len(latencies) == 0 {
+		return 0
+	}
+	sorted := append([]time.Duration(nil), latencies...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
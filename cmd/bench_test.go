@@ -0,0 +1,86 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package cmd
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	proxy "github.com/microsoft/hcnproxyctrl/proxy"
+)
+
+func TestPercentile(t *testing.T) {
+	// 1ms to 100ms, out of order.
+	var latencies []time.Duration
+	for i := 100; i >= 1; i-- {
+		latencies = append(latencies, time.Duration(i)*time.Millisecond)
+	}
+
+	tests := []struct {
+		name      string
+		latencies []time.Duration
+		p         int
+		want      time.Duration
+	}{
+		{name: "no latency", p: 50, want: 0},
+		{name: "single latency", latencies: []time.Duration{time.Second}, p: 99, want: time.Second},
+		{name: "p50", latencies: latencies, p: 50, want: 50 * time.Millisecond},
+		{name: "p90", latencies: latencies, p: 90, want: 90 * time.Millisecond},
+		{name: "p99", latencies: latencies, p: 99, want: 99 * time.Millisecond},
+		{name: "p0", latencies: latencies, p: 0, want: time.Millisecond},
+		{name: "nearest rank", latencies: []time.Duration{3, 1, 2}, p: 50, want: 2},
+		{name: "nearest rank rounds up", latencies: []time.Duration{4, 1, 3, 2}, p: 90, want: 4},
+	}
+	for _, test := range tests {
+		if got := percentile(test.latencies, test.p); got != test.want {
+			t.Errorf("%s: percentile(%d) = %v, want %v", test.name, test.p, got, test.want)
+		}
+	}
+
+	if latencies[0] != 100*time.Millisecond {
+		t.Error("percentile sorted the latencies in place")
+	}
+}
+
+func TestMeasure(t *testing.T) {
+	policies := []proxy.Policy{{RemotePorts: "1"}, {RemotePorts: "2"}, {RemotePorts: "3"}}
+
+	var recorded []string
+	latencies, err := measure(policies, func(policy proxy.Policy) (func() error, error) {
+		return func() error {
+			recorded = append(recorded, policy.RemotePorts)
+			return nil
+		}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(latencies) != len(policies) {
+		t.Errorf("measure returned %d latencies, want one per policy", len(latencies))
+	}
+	if len(recorded) != len(policies) {
+		t.Errorf("measure recorded %v, want every change", recorded)
+	}
+
+	failure := errors.New("HNS failure")
+	calls := 0
+	latencies, err = measure(policies, func(policy proxy.Policy) (func() error, error) {
+		calls++
+		if policy.RemotePorts == "2" {
+			return nil, failure
+		}
+		return func() error { return nil }, nil
+	})
+	if err != failure || latencies != nil || calls != 2 {
+		t.Errorf("measure = %v, %v after %d calls, want to stop at the failure", latencies, err, calls)
+	}
+
+	_, err = measure(policies, func(policy proxy.Policy) (func() error, error) {
+		return func() error { return failure }, nil
+	})
+	if err != failure {
+		t.Errorf("measure error %v, want the error recording the change", err)
+	}
+}
@@ -3,28 +3,93 @@
 
 // Package cmd has the code for the following commands
 //
-//      add         Add a proxy policy to an endpoint
-//      clear       Remove all proxy policies from an endpoint
-//      help        Help about any command
-//      list        List the proxy policies on an endpoint
-//      lookup      Report the ID of the HNS endpoint to which the specified container is attached
-//      version     Output the version of hcnproxyctrl
+//      add                 Add a proxy policy to an endpoint
+//      apply-to-containers Add a proxy policy to the endpoints of a list of containers
+//      clear               Remove all proxy policies from an endpoint
+//      count               Print the number of proxy policies on an endpoint
+//      coverage            Report the TCP traffic that no proxy policy of an endpoint intercepts
+//      diff-endpoints      Compare the proxy policies of two endpoints
+//      find-orphans        Report the proxy policies whose proxy port has no listener
+//      help                Help about any command
+//      lint                Check the policies of a file against the conventions of a rules file
+//      list                List the proxy policies on an endpoint
+//      list-containers     List the containers known to the container runtime with their endpoints
+//      lookup              Report the ID of the HNS endpoint to which the specified container is attached
+//      normalize           Rewrite the proxy policies of an endpoint in canonical form
+//      reconcile           Make the proxy policies of an endpoint match the ones of a file
+//      remove              Remove the proxy policies with the specified keys from an endpoint
+//      render              Print the HNS policy JSON that add would apply, without applying it
+//      serve               Serve a REST API to manage the proxy policies
+//      test-matrix         Report which proxy policy of an endpoint would intercept each connection of a file
+//      verify-intercept    Check that a connection is redirected to the proxy port by the policies of an endpoint
+//      verify-receipt      Verify that the policy recorded in a receipt is applied
+//      version             Output the version of hcnproxyctrl
+//      watch               Apply the proxy policies of a file to every new endpoint
 //
 package cmd
 
 import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
 	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
 
 	"github.com/davecgh/go-spew/spew"
+	cri "github.com/microsoft/hcnproxyctrl/cri"
 	proxy "github.com/microsoft/hcnproxyctrl/proxy"
 	"github.com/spf13/cobra"
+	"sigs.k8s.io/yaml"
 )
 
 var rootCmd = &cobra.Command{
 	Use: "hcnproxyctrl.exe",
+	Long: `hcnproxyctrl.exe programs layer-4 proxy policies on Windows through the
+Host Networking Service (HNS).
+
+Commands exit with one of the following codes:
+
+  0  success
+  1  failure, or the checked condition does not hold
+  2  invalid command line or policy
+  3  endpoint or policy not found
+  4  container runtime unreachable`,
+
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		if errorFormat != "text" && errorFormat != "json" {
+			errorFormat = "text"
+			errorOut(fmt.Errorf("unsupported error format %q (expected text or json)", cmd.Flag("error-format").Value))
+		}
+		if jsonIndent < 0 {
+			errorOut(fmt.Errorf("invalid JSON indentation: %d", jsonIndent))
+		}
+		if err := installHooks(); err != nil {
+			errorOut(err)
+		}
+	},
+
+	PersistentPostRun: func(cmd *cobra.Command, args []string) {
+		waitWebhooks()
+	},
 }
 
+// Flags for all commands
+var (
+	hnsTimeout  time.Duration
+	errorFormat string
+)
+
 var (
 	// VERSION is set during build
 	VERSION string
@@ -38,123 +103,1289 @@ var versionCmd = &cobra.Command{
 	},
 }
 
-// Flags for the "add" command
+// Flags for the "add" and "render" commands
 var (
-	proxyPort   string
-	userSID     string
-	localAddr   string
-	remoteAddr  string
-	localPorts  string
-	remotePorts string
-	priority    uint16
-	protocol    string
+	proxyPort     string
+	proxyPortFrom string
+	userSID       string
+	proxySID      string
+	proxyAccount  string
+	localAddr     string
+	remoteAddr    string
+	localPorts    string
+	remotePorts   string
+	excludedPorts []string
+	addEnsure     bool
+	addInterval   time.Duration
+	priorityBand  string
+	excludeLocal  bool
+	checkSupport  bool
+	afterACL      int
+	addStrict     bool
+	addConfirm    bool
+	addYes        bool
+	addAll        bool
+	addFile       string
+	addValues     []string
+	addDryRun     bool
+	priority      uint16
+	protocol      string
 )
 
 var cmdAdd = &cobra.Command{
-	Use:   "add <HNS endpoint ID>",
+	Use:   "add <HNS endpoint ID or name | ->",
 	Short: "Add a proxy policy to an endpoint",
-	Args:  cobra.ExactArgs(1),
+	Example: `  # Redirect all TCP traffic to the proxy on port 15001, except the proxy's own
+  # traffic, to avoid a loop (the proxy runs as Local System)
+  hcnproxyctrl.exe add 93f86a7f-e361-4362-b8a4-81bbb6a622dd --port 15001 --usersid system
+
+  # Only redirect traffic to the ports 80 to 443 of the 10.0.0.0/16 network
+  hcnproxyctrl.exe add 93f86a7f-e361-4362-b8a4-81bbb6a622dd --port 15001 --usersid system --remoteaddr 10.0.0.0/16 --remoteports 80-443
+
+  # Add the same policy to several endpoints, listed one per line
+  hcnproxyctrl.exe add - --port 15001 --usersid system < endpoints.txt
+
+  # Add the same policy to every endpoint of the host supporting proxy policies
+  hcnproxyctrl.exe add --all-endpoints --port 15001 --usersid system
+
+  # Print the HNS policy settings that would be applied, without applying them
+  hcnproxyctrl.exe add 93f86a7f-e361-4362-b8a4-81bbb6a622dd --port 15001 --usersid system --dry-run`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if addAll {
+			return cobra.NoArgs(cmd, args)
+		}
+		return cobra.ExactArgs(1)(cmd, args)
+	},
 
 	Run: func(cmd *cobra.Command, args []string) {
-		endpointID := args[0]
+		if addAll && (len(addFile) > 0 || addEnsure) {
+			errorOut(errors.New("--all-endpoints cannot be combined with --file or --ensure"))
+		}
+		if len(addValues) > 0 && len(addFile) == 0 {
+			errorOut(errors.New("--values requires --file"))
+		}
+		if err := checkReceiptTarget(args); err != nil {
+			errorOut(err)
+		}
+		if len(priorityBand) > 0 && len(excludedPorts) == 0 && len(addFile) == 0 {
+			errorOut(errors.New("--priority-band requires --exclude-remoteports or --file"))
+		}
+		if addDryRun && (addAll || addEnsure || cmd.Flags().Changed("after-acl")) {
+			errorOut(errors.New("--dry-run cannot be combined with --all-endpoints, --ensure or --after-acl, which need to query HNS"))
+		}
+		if len(addFile) > 0 {
+			if addDryRun {
+				policies, err := readPolicyTemplate(addFile, addValues)
+				if err != nil {
+					errorOut(err)
+				}
+				if policies, err = distributePriorities(policies); err != nil {
+					errorOut(err)
+				}
+				printDryRun(policies)
+				return
+			}
+			addFromTemplate(args[0])
+			return
+		}
+
+		policy, err := policyFromFlags()
+		if err != nil {
+			errorOut(err)
+		}
+
+		if excludeLocal {
+			policy.RemoteAddresses, err = proxy.ExcludeAddresses(policy.RemoteAddresses, proxy.LocalTrafficCIDRs)
+			if err != nil {
+				errorOut(err)
+			}
+		}
+		withAfterACL := cmd.Flags().Changed("after-acl")
+		if withAfterACL && (cmd.Flags().Changed("priority") || len(priorityBand) > 0) {
+			errorOut(errors.New("--after-acl cannot be combined with --priority or --priority-band"))
+		}
+
+		if addDryRun {
+			policies := []proxy.Policy{policy}
+			if len(excludedPorts) > 0 {
+				if policies, _, err = excludingPorts(policy); err != nil {
+					errorOut(err)
+				}
+			}
+			printDryRun(policies)
+			return
+		}
+
+		if addEnsure {
+			if args[0] == "-" || len(excludedPorts) > 0 || withAfterACL {
+				errorOut(errors.New("--ensure only supports a single endpoint and policy"))
+			}
+			if addInterval <= 0 {
+				errorOut(fmt.Errorf("invalid interval: %v", addInterval))
+			}
+			ensurePolicy(resolveEndpoint(args[0]), policy)
+			return
+		}
+
+		var endpointIDs []string
+		if addAll {
+			err := callHNS(func() (err error) {
+				endpointIDs, err = proxy.ListEndpointIDs()
+				return err
+			})
+			if err != nil {
+				errorOut(err)
+			}
+			if !confirmPolicy(stdin, os.Stdout, fmt.Sprintf("all the %d endpoints", len(endpointIDs)), policy) {
+				fmt.Println("Aborted")
+				return
+			}
+		} else if addConfirm && !addYes {
+			if args[0] == "-" {
+				errorOut(errors.New("--confirm cannot read the answer from stdin along with the endpoints, use --yes"))
+			}
+			if !confirmPolicy(stdin, os.Stdout, args[0], policy) {
+				fmt.Println("Aborted")
+				return
+			}
+		}
+
+		addToEndpoint := func(endpointID string) error {
+			if checkSupport {
+				if err := checkProxySupport(endpointID); err != nil {
+					return err
+				}
+			}
+
+			if err := checkAddressFamilies(endpointID, policy); err != nil {
+				return err
+			}
+
+			policy := policy
+			if withAfterACL {
+				err := callHNS(func() (err error) {
+					policy.Priority, err = proxy.PriorityAfterACL(endpointID, afterACL)
+					return err
+				})
+				if err != nil {
+					return err
+				}
+			}
+
+			if len(excludedPorts) > 0 {
+				return addExcludingPorts(endpointID, policy)
+			}
+
+			err := callHNSContext(context.Background(), func(ctx context.Context) error {
+				return proxy.AddPolicyContext(ctx, endpointID, policy)
+			})
+			if err != nil {
+				return err
+			}
+			if err := audit("add", endpointID, fmt.Sprintf("%+v", policy)); err != nil {
+				return err
+			}
+			if err := writeReceipt(endpointID, policy); err != nil {
+				return err
+			}
+
+			if addAll {
+				fmt.Println(endpointID + ": successfully added the policy")
+			} else {
+				fmt.Println("Successfully added the policy")
+			}
+			return nil
+		}
+
+		if addAll {
+			forEndpointsSupportingProxy(endpointIDs, addToEndpoint)
+		} else {
+			forEachEndpoint(args[0], addToEndpoint)
+		}
+	},
+}
+
+// addFromTemplate adds the policies of the --file template, merged with the
+// --values files, to the endpoints.
+func addFromTemplate(arg string) {
+	policies, err := readPolicyTemplate(addFile, addValues)
+	if err != nil {
+		errorOut(err)
+	}
+	if policies, err = distributePriorities(policies); err != nil {
+		errorOut(err)
+	}
 
-		if userSID == "system" {
-			userSID = proxy.LocalSystemSID
+	forEachEndpoint(arg, func(endpointID string) error {
+		err := callHNS(func() error {
+			return proxy.AddPolicies(endpointID, policies)
+		})
+		if err != nil {
+			return err
+		}
+		if err := audit("add", endpointID, fmt.Sprintf("%d policies from %s", len(policies), addFile)); err != nil {
+			return err
 		}
 
-		policy := proxy.Policy{
-			ProxyPort:       proxyPort,
-			UserSID:         userSID,
-			LocalAddresses:  localAddr,
-			RemoteAddresses: remoteAddr,
-			LocalPorts:      localPorts,
-			RemotePorts:     remotePorts,
-			Priority:        priority,
+		fmt.Println("Successfully added", len(policies), "policies")
+		return nil
+	})
+}
+
+// printDryRun prints the HNS policy settings that add would apply for the
+// policies, as a single object for a single policy, or else as an array. The
+// policies are validated as they would be when adding them.
+func printDryRun(policies []proxy.Policy) {
+	settings := make([]json.RawMessage, len(policies))
+	for i, policy := range policies {
+		endpointPolicy, err := proxy.RenderPolicy(policy)
+		if err != nil {
+			errorOut(err)
+		}
+		settings[i] = endpointPolicy.Settings
+	}
+
+	var rendered interface{} = settings
+	if len(settings) == 1 {
+		rendered = settings[0]
+	}
+	out, err := marshalJSON(rendered)
+	if err != nil {
+		errorOut(err)
+	}
+	fmt.Println(string(out))
+}
+
+// confirmPolicy prints the effective policy that would be added to the
+// endpoint to out and asks the user to confirm on in, normally stdin, unless
+// --yes is set. Anything but "y" or "yes", including no answer, declines.
+func confirmPolicy(in io.Reader, out io.Writer, endpoint string, policy proxy.Policy) bool {
+	if addYes {
+		return true
+	}
+	effective, err := proxy.EffectivePolicy(policy)
+	if err != nil {
+		errorOut(err)
+	}
+	effective = proxy.NormalizePolicy(effective)
+
+	fmt.Fprintf(out, "%+v\n%s\n", effective, effective.Explain())
+	fmt.Fprintf(out, "Add this policy to %s? [y/N] ", endpoint)
+	answer, _ := bufio.NewReader(in).ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	}
+	return false
+}
+
+// checkAddressFamilies warns if the policy has addresses of an IP family the
+// endpoint has no address of, or fails with --strict.
+func checkAddressFamilies(endpointID string, policy proxy.Policy) error {
+	err := callHNS(func() error {
+		return proxy.CheckEndpointAddressFamilies(endpointID, policy)
+	})
+	if err != nil && !addStrict {
+		fmt.Fprintln(os.Stderr, "warning:", err)
+		return nil
+	}
+	return err
+}
+
+// checkProxySupport returns proxy.ErrProxyNotSupported if the endpoint does not
+// support proxy policies.
+func checkProxySupport(endpointID string) error {
+	var supported bool
+	err := callHNS(func() (err error) {
+		supported, err = proxy.SupportsProxyPolicy(endpointID)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if !supported {
+		return proxy.ErrProxyNotSupported
+	}
+	return nil
+}
+
+// ensurePolicy adds the policy to the endpoint, and adds it back whenever it
+// goes missing until the process is interrupted.
+func ensurePolicy(endpointID string, policy proxy.Policy) {
+	ctx, cancel := context.WithCancel(context.Background())
+	interrupted := make(chan os.Signal, 1)
+	signal.Notify(interrupted, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-interrupted
+		cancel()
+	}()
+
+	logf := func(format string, args ...interface{}) {
+		fmt.Printf("%s %s: %s\n", time.Now().Format(time.RFC3339), endpointID, fmt.Sprintf(format, args...))
+	}
+	added := func() error {
+		return audit("add", endpointID, fmt.Sprintf("%+v", policy))
+	}
+	if err := proxy.EnsurePolicy(ctx, endpointID, policy, addInterval, logf, added); err != nil {
+		errorOut(err)
+	}
+}
+
+// addExcludingPorts adds a copy of the policy for each of the remote port
+// ranges left when removing the --exclude-remoteports from its remote ports.
+func addExcludingPorts(endpointID string, policy proxy.Policy) error {
+	policies, ranges, err := excludingPorts(policy)
+	if err != nil {
+		return err
+	}
+
+	err = callHNS(func() error {
+		return proxy.AddPolicies(endpointID, policies)
+	})
+	if err != nil {
+		return err
+	}
+	if err := audit("add", endpointID, fmt.Sprintf("%+v for remote ports %s", policy, strings.Join(ranges, ","))); err != nil {
+		return err
+	}
+
+	fmt.Println("Successfully added", len(policies), "policies for remote ports", strings.Join(ranges, ","))
+	return nil
+}
+
+// excludingPorts returns the copies of the policy that addExcludingPorts adds,
+// along with their remote port ranges.
+func excludingPorts(policy proxy.Policy) ([]proxy.Policy, []string, error) {
+	ranges, err := proxy.ExcludePorts(policy.RemotePorts, excludedPorts)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(ranges) == 0 {
+		return nil, nil, errors.New("all the remote ports are excluded")
+	}
+
+	policies := make([]proxy.Policy, len(ranges))
+	for i, portRange := range ranges {
+		policies[i] = policy
+		policies[i].RemotePorts = portRange
+	}
+	if policies, err = distributePriorities(policies); err != nil {
+		return nil, nil, err
+	}
+	return policies, ranges, nil
+}
+
+// distributePriorities spreads the priorities of the policies evenly across
+// --priority-band, in the order of the policies, if it is set.
+func distributePriorities(policies []proxy.Policy) ([]proxy.Policy, error) {
+	if len(priorityBand) == 0 {
+		return policies, nil
+	}
+	band, err := proxy.ParsePriorityBand(priorityBand)
+	if err != nil {
+		return nil, err
+	}
+	return proxy.DistributePriorities(policies, band)
+}
+
+// Flags for the "render" command
+var (
+	renderFull bool
+)
+
+var cmdRender = &cobra.Command{
+	Use:   "render",
+	Short: "Print the HNS policy JSON that add would apply, without applying it",
+	Args:  cobra.NoArgs,
+
+	Run: func(cmd *cobra.Command, args []string) {
+		policy, err := policyFromFlags()
+		if err != nil {
+			errorOut(err)
 		}
 
-		err := proxy.AddPolicy(endpointID, policy)
+		endpointPolicy, err := proxy.RenderPolicy(policy)
 		if err != nil {
 			errorOut(err)
 		}
 
-		fmt.Println("Successfully added the policy")
+		var rendered interface{} = endpointPolicy.Settings
+		if renderFull {
+			rendered = endpointPolicy
+		}
+		out, err := marshalJSON(rendered)
+		if err != nil {
+			errorOut(err)
+		}
+		fmt.Println(string(out))
 	},
 }
 
+// Flags for the "clear" command
+var (
+	clearFilter proxy.PolicyFilter
+)
+
 var cmdClear = &cobra.Command{
-	Use:   "clear <HNS endpoint ID>",
+	Use:   "clear <HNS endpoint ID or name | ->",
 	Short: "Remove all proxy policies from an endpoint",
+	Example: `  # Remove all the proxy policies of an endpoint
+  hcnproxyctrl.exe clear 93f86a7f-e361-4362-b8a4-81bbb6a622dd
+
+  # Only remove the policies redirecting to the proxy on port 15001
+  hcnproxyctrl.exe clear 93f86a7f-e361-4362-b8a4-81bbb6a622dd --only-port 15001
+
+  # Remove the proxy policies of several endpoints, listed one per line
+  hcnproxyctrl.exe clear - < endpoints.txt`,
+	Args: cobra.ExactArgs(1),
+
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(clearFilter.Protocol) > 0 {
+			if _, err := proxy.ParseProtocol(clearFilter.Protocol); err != nil {
+				errorOut(err)
+			}
+		}
+
+		forEachEndpoint(args[0], func(endpointID string) error {
+			var report proxy.ClearReport
+			err := callHNS(func() (err error) {
+				report, err = proxy.ClearPoliciesWithReport(endpointID, clearFilter)
+				return err
+			})
+			if err != nil {
+				return err
+			}
+			if err := audit("clear", endpointID, fmt.Sprintf("removed %d policies matching %+v", report.Removed, clearFilter)); err != nil {
+				return err
+			}
+			if report.Duplicates > 0 {
+				fmt.Printf("Removed %d policies (%d unique, %d duplicates)\n", report.Removed, report.Unique, report.Duplicates)
+				return nil
+			}
+			fmt.Println("Removed", report.Removed, "policies")
+			return nil
+		})
+	},
+}
+
+// Flags for the "remove" command
+var (
+	removeKeys []string
+)
+
+var cmdRemove = &cobra.Command{
+	Use:   "remove <HNS endpoint ID or name | ->",
+	Short: "Remove the proxy policies with the specified keys from an endpoint",
 	Args:  cobra.ExactArgs(1),
 
 	Run: func(cmd *cobra.Command, args []string) {
-		endpointID := args[0]
-		numRemoved, err := proxy.ClearPolicies(endpointID)
-		if err != nil {
-			errorOut(err)
+		if len(removeKeys) == 0 {
+			errorOut(errors.New("at least one --key must be specified"))
 		}
-		fmt.Println("Removed", numRemoved, "policies")
+
+		forEachEndpoint(args[0], func(endpointID string) error {
+			var numRemoved int
+			err := callHNS(func() (err error) {
+				numRemoved, err = proxy.RemovePoliciesByKeys(endpointID, removeKeys)
+				return err
+			})
+			if _, unmatched := err.(*proxy.UnmatchedKeysError); err != nil && !unmatched {
+				return err
+			}
+			if numRemoved > 0 {
+				if err := audit("remove", endpointID, fmt.Sprintf("removed %d policies with keys %s", numRemoved, strings.Join(removeKeys, ","))); err != nil {
+					return err
+				}
+			}
+			fmt.Println("Removed", numRemoved, "policies")
+			return err
+		})
 	},
 }
 
+// Flags for the "list" command
+var (
+	listOutput     string
+	listColumns    string
+	listCheckProxy bool
+	listLoopRisk   bool
+)
+
 var cmdList = &cobra.Command{
-	Use:   "list <HNS endpoint ID>",
+	Use:   "list <HNS endpoint ID or name>... | -",
 	Short: "List the proxy policies on an endpoint",
-	Args:  cobra.ExactArgs(1),
+	Example: `  # List the proxy policies of an endpoint as a table
+  hcnproxyctrl.exe list 93f86a7f-e361-4362-b8a4-81bbb6a622dd -o table
+
+  # Only show some of the fields
+  hcnproxyctrl.exe list 93f86a7f-e361-4362-b8a4-81bbb6a622dd --columns proxyport,remoteports,key
+
+  # Summarize the policies of several endpoints, listed one per line
+  hcnproxyctrl.exe list - -o summary < endpoints.txt
+
+  # Flag the policies whose proxy is not running
+  hcnproxyctrl.exe list 93f86a7f-e361-4362-b8a4-81bbb6a622dd --check-proxy -o table
+
+  # Audit the policies of two endpoints, as a map from endpoint ID to policies
+  hcnproxyctrl.exe list 93f86a7f-e361-4362-b8a4-81bbb6a622dd 0b2ed3c4-5e8f-4d2a-9a1c-6f7e8d9c0a1b -o json`,
+	Args: cobra.MinimumNArgs(1),
+
+	Run: func(cmd *cobra.Command, args []string) {
+		switch listOutput {
+		case "", "text", "json", "yaml", "csv", "env", "summary", "table":
+		default:
+			errorOut(fmt.Errorf("unknown output format %q", listOutput))
+		}
+
+		if len(listColumns) > 0 && listOutput == "" {
+			listOutput = "table"
+		}
+		if len(listColumns) > 0 && listOutput != "table" {
+			errorOut(errors.New("--columns is only supported with the table output format"))
+		}
+		columns, err := parseColumns(listColumns)
+		if err != nil {
+			errorOut(err)
+		}
+
+		if listCheckProxy && (listOutput == "env" || listOutput == "summary") {
+			errorOut(fmt.Errorf("--check-proxy is not supported with the %s output format", listOutput))
+		}
+		multiple := len(args) > 1 || args[0] == "-"
+		if listOutput == "env" && multiple {
+			errorOut(errors.New("the env output format only supports a single endpoint"))
+		}
+		// The policies of multiple endpoints are printed together at the end
+		// with the json and yaml output formats.
+		grouped := multiple && (listOutput == "json" || listOutput == "yaml")
+		groups := make(map[string][]listedPolicy)
+
+		writeHeader := true
+		list := func(endpointID string) error {
+			if multiple && listOutput != "summary" && listOutput != "csv" && !grouped {
+				fmt.Println(endpointID + ":")
+			}
+
+			// The status of the proxy of each policy is only known with
+			// --check-proxy.
+			var listed []proxy.PolicyStatus
+			if listCheckProxy {
+				err := callHNS(func() (err error) {
+					listed, err = proxy.CheckPolicies(endpointID, proxy.ProbeTCPPort)
+					return err
+				})
+				if err != nil {
+					return err
+				}
+			} else {
+				var policies []proxy.Policy
+				err := callHNSContext(context.Background(), func(ctx context.Context) (err error) {
+					policies, err = proxy.ListPoliciesContext(ctx, endpointID)
+					return err
+				})
+				if err != nil {
+					return err
+				}
+				for _, policy := range policies {
+					listed = append(listed, proxy.PolicyStatus{Policy: policy})
+				}
+			}
+			if listLoopRisk {
+				var risky []proxy.PolicyStatus
+				for _, status := range listed {
+					if status.Policy.LoopRisk() {
+						risky = append(risky, status)
+					}
+				}
+				listed = risky
+			}
+			if listCheckProxy && (listOutput == "" || listOutput == "text") {
+				spew.Dump(listed)
+				return nil
+			}
+
+			// statuses is nil without --check-proxy, so that no status is output.
+			var (
+				policies []proxy.Policy
+				statuses []proxy.ProxyStatus
+			)
+			if listCheckProxy {
+				statuses = []proxy.ProxyStatus{}
+			}
+			for _, status := range listed {
+				policies = append(policies, status.Policy)
+				if listCheckProxy {
+					statuses = append(statuses, status.Status)
+				}
+			}
+
+			switch listOutput {
+			case "csv":
+				// Rows of multiple endpoints are told apart by an endpoint column
+				csvEndpointID := ""
+				if multiple {
+					csvEndpointID = endpointID
+				}
+				if err := writeCSV(os.Stdout, csvEndpointID, policies, statuses, writeHeader); err != nil {
+					return err
+				}
+				writeHeader = false
+			case "env":
+				fmt.Print(formatEnv(policies))
+			case "summary":
+				fmt.Println(formatSummary(endpointID, policies))
+			case "table":
+				fmt.Print(formatTable(policies, statuses, columns))
+			case "json", "yaml":
+				if grouped {
+					groups[endpointID] = listedPolicies(policies, statuses)
+					return nil
+				}
+				return printPolicies(listedPolicies(policies, statuses))
+			default:
+				spew.Dump(policies)
+			}
+			return nil
+		}
+
+		if !multiple {
+			forEachEndpoint(args[0], list)
+			return
+		}
+		endpointIDs, err := endpointArgs(args)
+		if err != nil {
+			errorOut(err)
+		}
+		summary := forEndpoints(endpointIDs, list)
+		if grouped {
+			if err := printPolicies(groups); err != nil {
+				errorOut(err)
+			}
+			summary.out = os.Stderr
+		}
+		summary.finish()
+	},
+}
+
+// printPolicies prints policies, or a map of endpoint IDs to policies, in the
+// json or yaml output format of the list command.
+func printPolicies(v interface{}) error {
+	out, err := marshalJSON(v)
+	if listOutput == "yaml" {
+		out, err = yaml.Marshal(v)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Println(strings.TrimSuffix(string(out), "\n"))
+	return nil
+}
+
+var cmdCount = &cobra.Command{
+	Use:   "count <HNS endpoint ID or name>",
+	Short: "Print the number of proxy policies on an endpoint",
+	Example: `  # Alert if the proxy policies of an endpoint went missing
+  if ((hcnproxyctrl.exe count 93f86a7f-e361-4362-b8a4-81bbb6a622dd) -eq 0) { Write-Error "no proxy policy" }`,
+	Args: cobra.ExactArgs(1),
 
 	Run: func(cmd *cobra.Command, args []string) {
-		endpointID := args[0]
-		policies, err := proxy.ListPolicies(endpointID)
+		endpointID := resolveEndpoint(args[0])
+		var count int
+		err := callHNS(func() (err error) {
+			count, err = proxy.CountPolicies(endpointID)
+			return err
+		})
 		if err != nil {
 			errorOut(err)
 		}
-		spew.Dump(policies)
+		fmt.Println(count)
 	},
 }
 
 // Flags for the "lookup" command
 var (
-	runtimeEndpoint string
+	runtimeEndpoint   string
+	podIP             string
+	lookupRuntimeInfo bool
+	lookupPID         int
+	lookupByName      bool
+	crictlConfig      string
+	lookupOutput      string
+	lookupAll         bool
+	lookupParallelism int
+	criTimeout        time.Duration
+	criRetries        int
+	criRetryInterval  time.Duration
 )
 
 var cmdLookup = &cobra.Command{
-	Use:   "lookup <docker container ID>",
+	Use:   "lookup <container ID | container name with --by-name>",
 	Short: "Report the ID of the HNS endpoint to which the specified container is attached",
-	Args:  cobra.ExactArgs(1),
+	Example: `  # Find the endpoint of a container, then add a policy to it
+  hcnproxyctrl.exe add $(hcnproxyctrl.exe lookup 0f3bd2d3c7d4) --port 15001 --usersid system
+
+  # Find the endpoints of the pod with IP 10.244.1.12 through containerd
+  hcnproxyctrl.exe lookup --pod-ip 10.244.1.12 --runtimeendpoint npipe:////./pipe/containerd-containerd
+
+  # Show the endpoints of every container
+  hcnproxyctrl.exe lookup --all
+
+  # Find the endpoints of the container named istio-proxy
+  hcnproxyctrl.exe lookup --by-name istio-proxy
+
+  # Find the endpoints of the container running the process 4242 (best effort)
+  hcnproxyctrl.exe lookup --pid 4242`,
+	Args: cobra.MaximumNArgs(1),
 
 	Run: func(cmd *cobra.Command, args []string) {
+		switch lookupOutput {
+		case "", "json":
+		default:
+			errorOut(fmt.Errorf("unknown output format %q", lookupOutput))
+		}
+		withPID := cmd.Flags().Changed("pid")
+		if lookupAll && (len(args) > 0 || lookupRuntimeInfo || len(podIP) > 0 || withPID) {
+			errorOut(errors.New("--all cannot be combined with a container ID, --pod-ip, --pid or --runtime-info"))
+		}
+		if lookupByName && (lookupAll || lookupRuntimeInfo || len(podIP) > 0 || withPID) {
+			errorOut(errors.New("--by-name cannot be combined with --all, --pod-ip, --pid or --runtime-info"))
+		}
+		if lookupOutput == "json" && (lookupRuntimeInfo || len(podIP) > 0 || withPID || lookupByName) {
+			errorOut(errors.New("the json output format is only supported when looking up a container"))
+		}
+
+		if err := configureCRI(cmd); err != nil {
+			errorOut(err)
+		}
+
+		if lookupRuntimeInfo {
+			name, version, err := proxy.GetRuntimeVersion(runtimeEndpoint)
+			if err != nil {
+				errorOut(err)
+			}
+			fmt.Println(name, version)
+			return
+		}
+
+		if lookupAll {
+			lookupAllContainers()
+			return
+		}
+
+		if len(podIP) > 0 {
+			if len(args) > 0 {
+				errorOut(errors.New("a container ID cannot be specified along with --pod-ip"))
+			}
+			endpointIDs, err := proxy.GetEndpointsFromPodIP(podIP, runtimeEndpoint)
+			if err != nil {
+				errorOut(err)
+			}
+			printEndpointIDs(endpointIDs)
+			return
+		}
+		if withPID {
+			if len(args) > 0 {
+				errorOut(errors.New("a container ID cannot be specified along with --pid"))
+			}
+			endpointIDs, err := proxy.GetEndpointsFromPID(lookupPID, runtimeEndpoint)
+			if err != nil {
+				errorOut(err)
+			}
+			printEndpointIDs(endpointIDs)
+			return
+		}
+		if len(args) == 0 {
+			errorOut(errors.New("a container ID or name, --pod-ip, --pid or --all must be specified"))
+		}
+
+		if lookupByName {
+			endpointIDs, err := proxy.GetEndpointFromContainerName(args[0], runtimeEndpoint)
+			if err != nil {
+				errorOut(err)
+			}
+			printEndpointIDs(endpointIDs)
+			return
+		}
+
 		containerID := args[0]
-		hnsEndpointID, err := proxy.GetEndpointFromContainer(containerID, runtimeEndpoint)
+		var result *proxy.LookupResult
+		err := callHNSContext(context.Background(), func(ctx context.Context) (err error) {
+			result, err = proxy.GetContainerEndpointInfoContext(ctx, containerID, runtimeEndpoint)
+			return err
+		})
+		if err != nil {
+			errorOut(err)
+		}
+		if lookupOutput == "json" {
+			out, err := marshalJSON(result)
+			if err != nil {
+				errorOut(err)
+			}
+			fmt.Println(string(out))
+			return
+		}
+		printEndpointIDs(result.EndpointIDs)
+	},
+}
+
+// printEndpointIDs prints endpoint IDs, one per line.
+func printEndpointIDs(endpointIDs []string) {
+	for _, endpointID := range endpointIDs {
+		fmt.Println(endpointID)
+	}
+}
+
+// lookupAllContainers prints the endpoints of every container.
+func lookupAllContainers() {
+	results, err := proxy.LookupAllContainers(runtimeEndpoint, lookupParallelism)
+	if err != nil {
+		errorOut(err)
+	}
+
+	if lookupOutput == "json" {
+		out, err := marshalJSON(results)
+		if err != nil {
+			errorOut(err)
+		}
+		fmt.Println(string(out))
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "CONTAINER\tENDPOINTS")
+	for _, result := range results {
+		if len(result.EndpointIDs) == 0 {
+			fmt.Fprintf(w, "%s\t(no endpoint)\n", result.ContainerID)
+		} else {
+			fmt.Fprintf(w, "%s\t%s\n", result.ContainerID, strings.Join(result.EndpointIDs, ","))
+		}
+	}
+	w.Flush()
+}
+
+// configureCRI sets the parameters used to connect to CRI. The runtime
+// endpoint is the first set of the --runtimeendpoint flag, the
+// CONTAINER_RUNTIME_ENDPOINT environment variable and the crictl config file,
+// or else the first well-known endpoint on which a runtime responds; the
+// timeout is the one of the --timeout flag, or else of the crictl config file
+// if set. The crictl config file is only required to exist if explicitly
+// specified.
+func configureCRI(cmd *cobra.Command) error {
+	explicitConfig := cmd.Flags().Changed("crictl-config")
+	params := cri.DefaultContainerdCriParameters()
+	configured := len(runtimeEndpoint) > 0
+
+	config, err := cri.LoadCrictlConfig(crictlConfig)
+	switch {
+	case err == nil:
+		config.Apply(&params)
+		configured = configured || len(config.RuntimeEndpoint) > 0
+	case !os.IsNotExist(err) || explicitConfig:
+		return err
+	}
+
+	if cmd.Flags().Changed("timeout") {
+		if criTimeout <= 0 {
+			return fmt.Errorf("invalid CRI timeout: %v", criTimeout)
+		}
+		params.Timeout = criTimeout
+	}
+
+	if endpoint := os.Getenv("CONTAINER_RUNTIME_ENDPOINT"); len(endpoint) > 0 {
+		params.RuntimeEndpoint = endpoint
+		configured = true
+	}
+
+	if !configured {
+		endpoint, err := cri.DetectRuntimeEndpoint(params.Timeout)
+		if err != nil {
+			return err
+		}
+		params.RuntimeEndpoint = endpoint
+	}
+
+	proxy.SetDefaultCRIParameters(params)
+	proxy.SetCRIRetry(proxy.RetryPolicy{Attempts: criRetries + 1, Interval: criRetryInterval})
+	return nil
+}
+
+var cmdListContainers = &cobra.Command{
+	Use:   "list-containers",
+	Short: "List the containers known to the container runtime with their endpoints",
+	Long: `List the containers known to the container runtime with their endpoints.
+
+Unlike lookup --all, the endpoints of every container are looked up even if
+some of the lookups fail, the failures being reported in place of the
+endpoints. This helps finding out why lookup cannot resolve a container.`,
+	Example: `  hcnproxyctrl.exe list-containers --runtimeendpoint npipe:////./pipe/containerd-containerd --timeout 5s`,
+	Args:    cobra.NoArgs,
+
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := configureCRI(cmd); err != nil {
+			errorOut(err)
+		}
+		containers, err := proxy.ListContainers(runtimeEndpoint)
 		if err != nil {
 			errorOut(err)
 		}
-		fmt.Println(hnsEndpointID)
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "CONTAINER\tPOD SANDBOX\tNAMESPACE\tENDPOINTS")
+		for _, container := range containers {
+			var endpoints string
+			endpointIDs, err := proxy.GetEndpointsFromNamespace(container.NamespaceId)
+			switch {
+			case err != nil:
+				endpoints = fmt.Sprintf("(error: %v)", err)
+			case len(endpointIDs) == 0:
+				endpoints = "(no endpoint)"
+			default:
+				endpoints = strings.Join(endpointIDs, ",")
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", container.ContainerId, container.PodSandboxId, container.NamespaceId, endpoints)
+		}
+		w.Flush()
+	},
+}
+
+// Flags for the "normalize" command
+var (
+	normalizeDryRun bool
+)
+
+var cmdNormalize = &cobra.Command{
+	Use:   "normalize <HNS endpoint ID or name | ->",
+	Short: "Rewrite the proxy policies of an endpoint in canonical form",
+	Args:  cobra.ExactArgs(1),
+
+	Run: func(cmd *cobra.Command, args []string) {
+		forEachEndpoint(args[0], func(endpointID string) error {
+			var numChanged int
+			err := callHNS(func() (err error) {
+				numChanged, err = proxy.NormalizePolicies(endpointID, normalizeDryRun)
+				return err
+			})
+			if err != nil {
+				return err
+			}
+
+			if normalizeDryRun {
+				fmt.Println("Would rewrite", numChanged, "policies")
+				return nil
+			}
+			if err := audit("normalize", endpointID, fmt.Sprintf("rewrote %d policies", numChanged)); err != nil {
+				return err
+			}
+			fmt.Println("Rewrote", numChanged, "policies")
+			return nil
+		})
+	},
+}
+
+var cmdFindOrphans = &cobra.Command{
+	Use:   "find-orphans",
+	Short: "Report the proxy policies whose proxy port has no listener",
+	Args:  cobra.NoArgs,
+
+	Run: func(cmd *cobra.Command, args []string) {
+		var orphans []proxy.OrphanedPolicy
+		err := callHNS(func() (err error) {
+			orphans, err = proxy.FindOrphanedPolicies(proxy.ProbeTCPPort)
+			return err
+		})
+		if err != nil {
+			errorOut(err)
+		}
+		for _, orphan := range orphans {
+			fmt.Printf("%s: nothing is listening on proxy port %s\n", orphan.EndpointID, orphan.Policy.ProxyPort)
+		}
+		fmt.Println("Found", len(orphans), "orphaned policies")
 	},
 }
 
 func init() {
+	rootCmd.PersistentFlags().DurationVar(&hnsTimeout, "hns-timeout", 0, "give up on HNS operations taking longer than this duration (eg. 10s), 0 to wait indefinitely")
+	rootCmd.PersistentFlags().StringVar(&preHookCommand, "pre-hook", "", "command to run before every policy change, which is aborted if the command fails (runs with the same privileges)")
+	rootCmd.PersistentFlags().StringVar(&postHookCommand, "post-hook", "", "command to run after every policy change (runs with the same privileges)")
+	rootCmd.PersistentFlags().StringVar(&eventLogSource, "event-log-source", "", "record every change to the proxy policies in the Windows Event Log under this source name, registering it if needed")
+	rootCmd.PersistentFlags().BoolVar(&failOnSkip, "fail-on-skip", false, "exit with an error status when commands operating on many endpoints skip some of them")
+	rootCmd.PersistentFlags().IntVar(&jsonIndent, "json-indent", 2, "number of spaces to indent JSON output with, 0 for compact output")
+	rootCmd.PersistentFlags().BoolVar(&debugHNS, "debug-hns", false, "print every request made to HNS to modify policies, with its outcome, on stderr (eg. for bug reports)")
+	rootCmd.PersistentFlags().StringVar(&errorFormat, "error-format", "text", "format of the errors printed on stderr: text or json")
+	rootCmd.PersistentFlags().StringVar(&webhookURL, "webhook-url", "", "post a JSON event to this URL after every successful change to the proxy policies (best effort, retried on 5xx statuses)")
+	rootCmd.PersistentFlags().DurationVar(&webhookTimeout, "webhook-timeout", 5*time.Second, "give up on webhook requests taking longer than this duration")
+	rootCmd.PersistentFlags().StringVar(&auditLogPath, "audit-log", "", "append a JSON record of every change to the proxy policies to this file")
+
 	rootCmd.AddCommand(versionCmd)
 	rootCmd.AddCommand(cmdAdd)
 	rootCmd.AddCommand(cmdClear)
 	rootCmd.AddCommand(cmdList)
+	rootCmd.AddCommand(cmdCount)
 	rootCmd.AddCommand(cmdLookup)
+	rootCmd.AddCommand(cmdListContainers)
+	rootCmd.AddCommand(cmdRender)
+	rootCmd.AddCommand(cmdFindOrphans)
+	rootCmd.AddCommand(cmdNormalize)
+	rootCmd.AddCommand(cmdRemove)
 
 	// Flags for the "add" command
-	cmdAdd.Flags().StringVar(&proxyPort, "port", "", "port the proxy is listening on")
-	cmdAdd.MarkFlagRequired("port")
-	cmdAdd.Flags().StringVar(&userSID, "usersid", "", `ignore traffic originating from the specified user SID (pass "system" to use the Local System SID)`)
-	cmdAdd.Flags().StringVar(&localAddr, "localaddr", "", "only proxy traffic originating from the specified address")
-	cmdAdd.Flags().StringVar(&remoteAddr, "remoteaddr", "", "only proxy traffic destinated to the specified address")
-	cmdAdd.Flags().StringVar(&localPorts, "localports", "", "only proxy traffic originating from the specified port or port range")
-	cmdAdd.Flags().StringVar(&remotePorts, "remoteports", "", "only proxy traffic destinated to the specified port or port range")
-	cmdAdd.Flags().Uint16Var(&priority, "priority", 0, "the priority of this policy")
+	addPolicyFlags(cmdAdd)
+
+	cmdAdd.Flags().StringVar(&addFile, "file", "", "add the policies of this YAML or JSON template instead of the one described by the flags (see --values)")
+	cmdAdd.Flags().StringArrayVar(&addValues, "values", nil, "YAML or JSON file deep-merged over the --file template before adding its policies, Helm style (can be repeated, later files win)")
+	cmdAdd.Flags().BoolVar(&addDryRun, "dry-run", false, "validate the policy and print the HNS policy settings that would be applied, without applying them")
+	cmdAdd.Flags().BoolVar(&addConfirm, "confirm", false, "show the effective policy and ask for confirmation before adding it")
+	cmdAdd.Flags().BoolVar(&addYes, "yes", false, "do not ask for confirmation with --confirm or --all-endpoints")
+	cmdAdd.Flags().BoolVar(&addAll, "all-endpoints", false, "add the policy to every endpoint of the host, skipping those not supporting proxy policies, after confirmation")
+	cmdAdd.Flags().BoolVar(&addStrict, "strict", false, "fail instead of warning when the policy has addresses of an IP family the endpoint has no address of")
+	cmdAdd.Flags().BoolVar(&checkSupport, "check-support", false, "check that the endpoint supports proxy policies before adding the policy")
+	cmdAdd.Flags().BoolVar(&addEnsure, "ensure", false, "keep running, adding the policy back whenever it goes missing")
+	cmdAdd.Flags().DurationVar(&addInterval, "interval", 30*time.Second, "how often to check that the policy is still applied with --ensure")
+	cmdAdd.Flags().StringSliceVar(&excludedPorts, "exclude-remoteports", nil, "do not proxy traffic destinated to these ports or port ranges, by adding a policy for each of the remaining ranges of --remoteports (all ports if unset)")
+	cmdAdd.Flags().BoolVar(&excludeLocal, "exclude-local", false, "do not proxy traffic destinated to loopback (127.0.0.0/8) and link-local (169.254.0.0/16) addresses, by restricting the IPv4 ranges of --remoteaddr, which must be set (eg. to 0.0.0.0/0, only proxying IPv4 traffic), to the complementary ranges")
+	cmdAdd.Flags().IntVar(&afterACL, "after-acl", 0, "set the priority of the policy so that it is evaluated right after the ACL policy of the endpoint with this index (0 for the first one)")
+	cmdAdd.Flags().StringVar(&priorityBand, "priority-band", "", "spread the priorities of the policies added with --exclude-remoteports or --file evenly across this range (eg. 1000-2000)")
+
+	// Flags for the "render" command
+	addPolicyFlags(cmdRender)
+	cmdRender.Flags().BoolVar(&renderFull, "full", false, "print the full HNS endpoint policy instead of only its settings")
+
+	// Flags for the "clear" command
+	cmdClear.Flags().StringVar(&clearFilter.ProxyPort, "only-port", "", "only remove the policies with the specified proxy port")
+	cmdClear.Flags().StringVar(&clearFilter.Protocol, "only-protocol", "", "only remove the policies with the specified protocol, by name or number (eg. tcp or 6)")
+	cmdClear.Flags().StringVar(&clearFilter.LocalAddresses, "only-localaddr", "", "only remove the policies with the specified local address filter")
+	cmdClear.Flags().StringVar(&clearFilter.RemoteAddresses, "only-remoteaddr", "", "only remove the policies with the specified remote address filter")
+	cmdClear.Flags().StringVar(&clearFilter.LocalPorts, "only-localports", "", "only remove the policies with the specified local port filter")
+	cmdClear.Flags().StringVar(&clearFilter.RemotePorts, "only-remoteports", "", "only remove the policies with the specified remote port filter")
+
+	// Flags for the "remove" command
+	cmdRemove.Flags().StringArrayVar(&removeKeys, "key", nil, "key of a policy to remove, as shown by list -o table, json or yaml (can be repeated)")
+
+	// Flags for the "list" command
+	cmdList.Flags().StringVarP(&listOutput, "output", "o", "", `output format, "json", "yaml", "table", "csv", "env" for shell variable assignments, "summary" for a single line or the default "text"`)
+	cmdList.Flags().StringVar(&listColumns, "columns", "", "comma-separated policy fields to show in the table output (eg. proxyport,remoteports,priority)")
+	cmdList.Flags().BoolVar(&listLoopRisk, "loop-risk-only", false, "only show the policies that would redirect the proxy's own traffic back to it, ie. that have no user SID exclusion and intercept the proxy port")
+	cmdList.Flags().BoolVar(&listCheckProxy, "check-proxy", false, "report whether each policy's proxy port has a listener (healthy), not (stale), or cannot be probed (unknown), in a status column or field")
+
+	// Flags for the "normalize" command
+	cmdNormalize.Flags().BoolVar(&normalizeDryRun, "dry-run", false, "only report how many policies would be rewritten")
 
 	// Flags for the "lookup" command
-	cmdLookup.Flags().StringVar(&runtimeEndpoint, "runtimeendpoint", "", "CRI RuntimeEndpoint to query container information from")
+	cmdLookup.Flags().StringVar(&runtimeEndpoint, "runtimeendpoint", "", "CRI RuntimeEndpoint to query container information from, eg. "+cri.DefaultContainerdRuntimeEndpoint+" for containerd or tcp://127.0.0.1:2376 for older Docker setups (if neither this, CONTAINER_RUNTIME_ENDPOINT nor the crictl config sets one, the well-known containerd and Docker endpoints are probed, containerd first)")
+	cmdLookup.Flags().BoolVar(&lookupRuntimeInfo, "runtime-info", false, "report the name and version of the container runtime instead")
+	cmdLookup.Flags().StringVar(&crictlConfig, "crictl-config", cri.DefaultCrictlConfigPath(), "crictl config file from which to read the runtime endpoint and timeout, when neither --runtimeendpoint nor CONTAINER_RUNTIME_ENDPOINT is set")
+	cmdLookup.Flags().StringVarP(&lookupOutput, "output", "o", "", `output format, "json" for the container's namespace, endpoints and runtime, or the default endpoint IDs, one per line`)
+	cmdLookup.Flags().DurationVar(&criTimeout, "timeout", cri.DefaultContainerdCriParameters().Timeout, "give up connecting to the container runtime after this duration (eg. 5s or 500ms), overriding the crictl config")
+	cmdLookup.Flags().IntVar(&criRetries, "cri-retries", 0, "how many times to retry connecting to the container runtime if it is not accepting connections yet")
+	cmdLookup.Flags().DurationVar(&criRetryInterval, "cri-retry-interval", 500*time.Millisecond, "how long to wait before the first retry with --cri-retries, doubled before each of the next ones")
+	cmdLookup.Flags().BoolVar(&lookupAll, "all", false, "report the endpoints of every container instead")
+	cmdLookup.Flags().IntVar(&lookupParallelism, "parallelism", 8, "how many namespaces to look up the endpoints of at once with --all")
+	cmdLookup.Flags().BoolVar(&lookupByName, "by-name", false, "look the container up by its name (eg. the name of the container in its pod spec) instead of its ID")
+	cmdLookup.Flags().StringVar(&podIP, "pod-ip", "", "report the IDs of the HNS endpoints of the pod with the specified IP instead")
+	cmdLookup.Flags().IntVar(&lookupPID, "pid", 0, "report the IDs of the HNS endpoints of the container running the process with the specified ID instead (best effort: requires the runtime to report the init process of containers, and does not work with Hyper-V isolation)")
+
+	cmdListContainers.Flags().StringVar(&runtimeEndpoint, "runtimeendpoint", "", "CRI RuntimeEndpoint to list the containers of (if neither this, CONTAINER_RUNTIME_ENDPOINT nor the crictl config sets one, the well-known containerd and Docker endpoints are probed)")
+	cmdListContainers.Flags().StringVar(&crictlConfig, "crictl-config", cri.DefaultCrictlConfigPath(), "crictl config file from which to read the runtime endpoint and timeout, when neither --runtimeendpoint nor CONTAINER_RUNTIME_ENDPOINT is set")
+	cmdListContainers.Flags().DurationVar(&criTimeout, "timeout", cri.DefaultContainerdCriParameters().Timeout, "give up connecting to the container runtime after this duration (eg. 5s or 500ms), overriding the crictl config")
+}
+
+// addPolicyFlags registers the flags describing a proxy policy on cmd.
+func addPolicyFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&proxyPort, "port", "", "port the proxy is listening on")
+	cmd.Flags().StringVar(&proxyPortFrom, "proxy-port-from", "", "URL from which to fetch the port the proxy is listening on, instead of --port")
+	cmd.Flags().StringVar(&userSID, "usersid", "", "ignore traffic originating from the specified user SID (or "+sidShorthandList()+" for the built-in accounts)")
+	cmd.Flags().StringVar(&proxySID, "proxy-sid", "", "ignore traffic originating from the specified proxy SID")
+	cmd.Flags().StringVar(&proxyAccount, "proxy-account", "", `ignore traffic originating from the specified account, resolved to its SID (eg. "NT SERVICE\envoy")`)
+	cmd.Flags().StringVar(&localAddr, "localaddr", "", "only proxy traffic originating from the specified addresses, a comma-separated list of IPs and CIDRs")
+	cmd.Flags().StringVar(&remoteAddr, "remoteaddr", "", "only proxy traffic destinated to the specified addresses, a comma-separated list of IPs and CIDRs")
+	cmd.Flags().StringVar(&localPorts, "localports", "", "only proxy traffic originating from the specified port or port range")
+	cmd.Flags().StringVar(&remotePorts, "remoteports", "", "only proxy traffic destinated to the specified port or port range")
+	cmd.Flags().Uint16Var(&priority, "priority", 0, "the priority of this policy")
+	cmd.Flags().StringVar(&protocol, "protocol", "", `only proxy traffic using the specified protocol: "tcp" (the default) or "udp", or their IANA number (6 or 17)`)
+}
+
+// sidShorthandList returns the SID shorthands accepted by --usersid, quoted
+// and in alphabetical order, eg. `"localservice", "system"`.
+func sidShorthandList() string {
+	var shorthands []string
+	for shorthand := range proxy.WellKnownSIDs() {
+		shorthands = append(shorthands, strconv.Quote(shorthand))
+	}
+	sort.Strings(shorthands)
+	return strings.Join(shorthands, ", ")
+}
+
+// policyFromFlags builds the policy described by the flags of the "add" and
+// "render" commands.
+func policyFromFlags() (proxy.Policy, error) {
+	port, err := proxyPortFromFlags()
+	if err != nil {
+		return proxy.Policy{}, err
+	}
+
+	sid, err := proxyUserSID()
+	if err != nil {
+		return proxy.Policy{}, err
+	}
+
+	protocolNumber := protocol
+	if len(protocol) > 0 {
+		if protocolNumber, err = proxy.ParseProtocol(protocol); err != nil {
+			return proxy.Policy{}, err
+		}
+	}
+
+	return proxy.Policy{
+		ProxyPort:       port,
+		UserSID:         sid,
+		LocalAddresses:  localAddr,
+		RemoteAddresses: remoteAddr,
+		LocalPorts:      localPorts,
+		RemotePorts:     remotePorts,
+		Priority:        priority,
+		Protocol:        protocolNumber,
+	}, nil
+}
+
+// proxyPortFromFlags returns the proxy port specified by exactly one of the
+// --port and --proxy-port-from flags.
+func proxyPortFromFlags() (string, error) {
+	switch {
+	case len(proxyPort) > 0 && len(proxyPortFrom) > 0:
+		return "", errors.New("only one of --port and --proxy-port-from can be specified")
+	case len(proxyPortFrom) > 0:
+		return fetchProxyPort(proxyPortFrom)
+	case len(proxyPort) == 0:
+		return "", errors.New("one of --port and --proxy-port-from must be specified")
+	}
+	return proxyPort, nil
+}
+
+// proxyPortFetchTimeout bounds the request fetching --proxy-port-from.
+var proxyPortFetchTimeout = 5 * time.Second
+
+// maxProxyPortSize bounds the size of the body fetched from --proxy-port-from.
+const maxProxyPortSize = 64
+
+// fetchProxyPort fetches the proxy port published as plain text by a service
+// discovery endpoint.
+func fetchProxyPort(url string) (string, error) {
+	client := http.Client{Timeout: proxyPortFetchTimeout}
+	resp, err := client.Get(url)
+	if err != nil {
+		return "", fmt.Errorf("could not fetch the proxy port: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("could not fetch the proxy port: %s returned %s", url, resp.Status)
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxProxyPortSize+1))
+	if err != nil {
+		return "", fmt.Errorf("could not fetch the proxy port: %v", err)
+	}
+	if len(body) > maxProxyPortSize {
+		return "", fmt.Errorf("%s returned more than %d bytes for the proxy port", url, maxProxyPortSize)
+	}
+
+	port := strings.TrimSpace(string(body))
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return "", fmt.Errorf("%s returned an invalid proxy port %q", url, port)
+	}
+	return port, nil
+}
+
+// proxyUserSID returns the SID to exempt from interception, as specified by
+// at most one of the --usersid, --proxy-sid and --proxy-account flags.
+func proxyUserSID() (string, error) {
+	numSet := 0
+	for _, flag := range []string{userSID, proxySID, proxyAccount} {
+		if len(flag) > 0 {
+			numSet++
+		}
+	}
+	if numSet > 1 {
+		return "", errors.New("only one of --usersid, --proxy-sid and --proxy-account can be specified")
+	}
+
+	switch {
+	case len(proxyAccount) > 0:
+		return proxy.LookupAccountSID(proxyAccount)
+	case len(proxySID) > 0:
+		sid := strings.ToUpper(proxySID)
+		return sid, proxy.ValidateSID(sid)
+	}
+	return userSID, nil
+}
+
+// callHNS calls fn, which is expected to perform HNS operations, and returns
+// its error, like callHNSContext does for operations that take no context.
+func callHNS(fn func() error) error {
+	return callHNSContext(context.Background(), func(context.Context) error {
+		return fn()
+	})
+}
+
+// callHNSContext calls fn, which is expected to perform HNS operations, with a
+// context derived from ctx that is also done once --hns-timeout elapses, if
+// set: the stricter of the deadline of ctx, eg. serve --request-timeout, and
+// the root timeout wins. fn is meant to pass the context on to the Context
+// variants of the proxy functions. A timeout error is returned as soon as the
+// context is done; since HNS calls cannot be interrupted, fn may still
+// complete in the background.
+func callHNSContext(ctx context.Context, fn func(ctx context.Context) error) error {
+	hnsCtx := ctx
+	if hnsTimeout > 0 {
+		var cancel context.CancelFunc
+		hnsCtx, cancel = context.WithTimeout(ctx, hnsTimeout)
+		defer cancel()
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- fn(hnsCtx)
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-hnsCtx.Done():
+		err = hnsCtx.Err()
+	}
+	if err == nil || err != hnsCtx.Err() {
+		return err
+	}
+	if ctx.Err() == nil {
+		return fmt.Errorf("HNS operation timed out after %v", hnsTimeout)
+	}
+	return fmt.Errorf("HNS operation interrupted: %w", ctx.Err())
 }
 
 func errorOut(err error) {
-	fmt.Fprintln(os.Stderr, err)
-	os.Exit(1)
+	waitWebhooks()
+	var problems []error
+	if validationErr, ok := err.(*proxy.ValidationError); ok {
+		problems = validationErr.Problems
+	}
+	if errorFormat == "json" {
+		output := struct {
+			Error    string   `json:"error"`
+			Problems []string `json:"problems,omitempty"`
+		}{Error: err.Error()}
+		for _, problem := range problems {
+			output.Problems = append(output.Problems, problem.Error())
+		}
+		encoded, _ := json.Marshal(output)
+		fmt.Fprintln(os.Stderr, string(encoded))
+	} else if len(problems) > 1 {
+		fmt.Fprintln(os.Stderr, "invalid policy:")
+		for _, problem := range problems {
+			fmt.Fprintln(os.Stderr, "  - "+problem.Error())
+		}
+	} else {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	os.Exit(exitCode(err))
 }
 
 // Execute sets the version string, then calls through to Cobral Execute
@@ -163,6 +1394,6 @@ func Execute(version string) {
 
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Println(err)
-		os.Exit(-1)
+		os.Exit(exitInvalidInput)
 	}
 }
@@ -0,0 +1,356 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	proxy "github.com/microsoft/hcnproxyctrl/proxy"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// setHNSTimeout sets --hns-timeout for the duration of the test.
+func setHNSTimeout(t *testing.T, timeout time.Duration) {
+	previous := hnsTimeout
+	hnsTimeout = timeout
+	t.Cleanup(func() { hnsTimeout = previous })
+}
+
+// blockingHNSCall stands for an HNS operation that hangs until its context
+// is done, or for a long time if the context is not meant to interrupt it.
+func blockingHNSCall(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(time.Minute):
+		return nil
+	}
+}
+
+func TestCallHNSRootTimeout(t *testing.T) {
+	setHNSTimeout(t, 10*time.Millisecond)
+
+	start := time.Now()
+	err := callHNS(func() error {
+		return blockingHNSCall(context.Background())
+	})
+	if err == nil || err.Error() != "HNS operation timed out after 10ms" {
+		t.Errorf("callHNS error %v, want a timeout after 10ms", err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("callHNS returned after %v", elapsed)
+	}
+
+	err = callHNSContext(context.Background(), blockingHNSCall)
+	if err == nil || err.Error() != "HNS operation timed out after 10ms" {
+		t.Errorf("callHNSContext error %v, want a timeout after 10ms", err)
+	}
+}
+
+func TestCallHNSStricterDeadlineWins(t *testing.T) {
+	tests := []struct {
+		name       string
+		hnsTimeout time.Duration
+		ctxTimeout time.Duration
+		err        string
+	}{
+		{"root timeout only", 10 * time.Millisecond, 0, "HNS operation timed out after 10ms"},
+		{"root timeout stricter", 10 * time.Millisecond, time.Hour, "HNS operation timed out after 10ms"},
+		{"command timeout stricter", time.Hour, 10 * time.Millisecond, "HNS operation interrupted: context deadline exceeded"},
+		{"command timeout only", 0, 10 * time.Millisecond, "HNS operation interrupted: context deadline exceeded"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setHNSTimeout(t, test.hnsTimeout)
+			ctx := context.Background()
+			if test.ctxTimeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, test.ctxTimeout)
+				defer cancel()
+			}
+
+			// The HNS operation keeps running in the background once
+			// interrupted, so its deadline is passed over a channel.
+			deadlines := make(chan time.Time, 1)
+			err := callHNSContext(ctx, func(ctx context.Context) error {
+				deadline, _ := ctx.Deadline()
+				deadlines <- deadline
+				return blockingHNSCall(ctx)
+			})
+			if err == nil || err.Error() != test.err {
+				t.Errorf("callHNSContext error %v, want %q", err, test.err)
+			}
+			if remaining := time.Until(<-deadlines); remaining > time.Minute {
+				t.Errorf("the HNS operation was given a deadline in %v, want the stricter one", remaining)
+			}
+		})
+	}
+}
+
+func TestCallHNSRequestTimeout(t *testing.T) {
+	setHNSTimeout(t, time.Hour)
+
+	// serve bounds the HNS operations of a request by --request-timeout
+	// through the context of the request.
+	errs := make(chan error, 1)
+	handler := http.TimeoutHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		errs <- callHNSContext(r.Context(), blockingHNSCall)
+	}), 10*time.Millisecond, "timed out")
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Errorf("status %d, want %d", recorder.Code, http.StatusServiceUnavailable)
+	}
+	select {
+	case err := <-errs:
+		if err == nil || err.Error() != "HNS operation interrupted: context deadline exceeded" {
+			t.Errorf("callHNSContext error %v, want the request timeout", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Error("the HNS operation was not interrupted by the request timeout")
+	}
+}
+
+func TestCallHNSWithoutTimeout(t *testing.T) {
+	setHNSTimeout(t, 0)
+
+	err := callHNSContext(context.Background(), func(ctx context.Context) error {
+		if _, ok := ctx.Deadline(); ok {
+			t.Error("the HNS operation was given a deadline without any timeout set")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Errorf("callHNSContext error %v", err)
+	}
+
+	err = callHNS(func() error { return context.Canceled })
+	if err == nil || !strings.Contains(err.Error(), "canceled") {
+		t.Errorf("callHNS error %v, want the error of the operation", err)
+	}
+}
+
+func TestFetchProxyPort(t *testing.T) {
+	previous := proxyPortFetchTimeout
+	proxyPortFetchTimeout = 100 * time.Millisecond
+	t.Cleanup(func() { proxyPortFetchTimeout = previous })
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		port    string
+		err     string
+	}{
+		{
+			name:    "valid port",
+			handler: func(w http.ResponseWriter, r *http.Request) { fmt.Fprintln(w, " 15001") },
+			port:    "15001",
+		},
+		{
+			name:    "non-numeric body",
+			handler: func(w http.ResponseWriter, r *http.Request) { fmt.Fprint(w, "envoy") },
+			err:     `returned an invalid proxy port "envoy"`,
+		},
+		{
+			name:    "port out of range",
+			handler: func(w http.ResponseWriter, r *http.Request) { fmt.Fprint(w, "65536") },
+			err:     `returned an invalid proxy port "65536"`,
+		},
+		{
+			name:    "body over 64 bytes",
+			handler: func(w http.ResponseWriter, r *http.Request) { fmt.Fprint(w, "15001"+strings.Repeat(" ", 64)) },
+			err:     "returned more than 64 bytes for the proxy port",
+		},
+		{
+			name:    "non-200 status",
+			handler: func(w http.ResponseWriter, r *http.Request) { http.Error(w, "15001", http.StatusNotFound) },
+			err:     "returned 404 Not Found",
+		},
+		{
+			name: "timeout",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				select {
+				case <-r.Context().Done():
+				case <-time.After(10 * time.Second):
+				}
+				fmt.Fprint(w, "15001")
+			},
+			err: "could not fetch the proxy port",
+		},
+	}
+	for _, test := range tests {
+		server := httptest.NewServer(test.handler)
+		port, err := fetchProxyPort(server.URL)
+		server.Close()
+		if len(test.err) > 0 {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: fetchProxyPort = %q, %v, want error containing %q", test.name, port, err, test.err)
+			}
+			continue
+		}
+		if err != nil || port != test.port {
+			t.Errorf("%s: fetchProxyPort = %q, %v, want %q", test.name, port, err, test.port)
+		}
+	}
+}
+
+// exampleInvocation matches an invocation of the tool in an Example, up to the
+// end of the line or of the enclosing shell construct.
+var exampleInvocation = regexp.MustCompile(`hcnproxyctrl\.exe ([^()<]*)`)
+
+// exampleSubstitution matches a command substitution in an Example.
+var exampleSubstitution = regexp.MustCompile(`\$\((hcnproxyctrl\.exe [^()]*)\)`)
+
+// exampleArgs returns the arguments of each invocation of the tool in an
+// Example. Command substitutions are invocations of their own, replaced by an
+// endpoint ID in the invocation they are part of.
+func exampleArgs(example string) [][]string {
+	var invocations [][]string
+	for _, line := range strings.Split(example, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		for _, substitution := range exampleSubstitution.FindAllStringSubmatch(line, -1) {
+			invocations = append(invocations, exampleArgs(substitution[1])...)
+		}
+		line = exampleSubstitution.ReplaceAllString(line, "93f86a7f-e361-4362-b8a4-81bbb6a622dd")
+		for _, match := range exampleInvocation.FindAllStringSubmatch(line, -1) {
+			invocations = append(invocations, strings.Fields(match[1]))
+		}
+	}
+	return invocations
+}
+
+// allCommands returns the subcommands of the command, recursively.
+func allCommands(cmd *cobra.Command) []*cobra.Command {
+	var commands []*cobra.Command
+	for _, subcommand := range cmd.Commands() {
+		commands = append(commands, subcommand)
+		commands = append(commands, allCommands(subcommand)...)
+	}
+	return commands
+}
+
+// restoreFlags resets the flags of the command changed by parsing a command
+// line to the values they had before, since they are package variables.
+func restoreFlags(t *testing.T, cmd *cobra.Command) func() {
+	values := make(map[string][]string)
+	cmd.Flags().VisitAll(func(flag *pflag.Flag) {
+		if slice, ok := flag.Value.(pflag.SliceValue); ok {
+			values[flag.Name] = slice.GetSlice()
+		} else {
+			values[flag.Name] = []string{flag.Value.String()}
+		}
+	})
+	return func() {
+		cmd.Flags().Visit(func(flag *pflag.Flag) {
+			value, ok := values[flag.Name]
+			if !ok {
+				// Added while parsing, eg. --help
+				value = []string{flag.DefValue}
+			}
+			var err error
+			if slice, ok := flag.Value.(pflag.SliceValue); ok {
+				err = slice.Replace(value)
+			} else {
+				err = flag.Value.Set(value[0])
+			}
+			if err != nil {
+				t.Errorf("restoring --%s: %v", flag.Name, err)
+			}
+			flag.Changed = false
+		})
+	}
+}
+
+func TestExamples(t *testing.T) {
+	for _, cmd := range allCommands(rootCmd) {
+		if len(cmd.Example) == 0 {
+			continue
+		}
+
+		var help bytes.Buffer
+		path := strings.Fields(cmd.CommandPath())[1:]
+		restore := restoreFlags(t, cmd)
+		rootCmd.SetOut(&help)
+		rootCmd.SetArgs(append(path, "--help"))
+		err := rootCmd.Execute()
+		rootCmd.SetOut(nil)
+		rootCmd.SetArgs(nil)
+		restore()
+		if err != nil {
+			t.Errorf("%s --help: %v", cmd.CommandPath(), err)
+		}
+		if !strings.Contains(help.String(), cmd.Example) {
+			t.Errorf("%s --help does not show the example:\n%s", cmd.CommandPath(), help.String())
+		}
+
+		invocations := exampleArgs(cmd.Example)
+		if len(invocations) == 0 {
+			t.Errorf("%s: no invocation found in the example", cmd.CommandPath())
+		}
+		for _, args := range invocations {
+			found, flags, err := rootCmd.Find(args)
+			if err != nil || found == rootCmd {
+				t.Errorf("%s: example %q does not designate a command: %v", cmd.CommandPath(), strings.Join(args, " "), err)
+				continue
+			}
+			restore := restoreFlags(t, found)
+			if err := found.ParseFlags(flags); err != nil {
+				t.Errorf("%s: example %q: %v", cmd.CommandPath(), strings.Join(args, " "), err)
+			}
+			restore()
+		}
+	}
+}
+
+func TestConfirmPolicy(t *testing.T) {
+	policy := proxy.Policy{ProxyPort: "15001", UserSID: "system"}
+
+	tests := []struct {
+		name   string
+		input  string
+		yes    bool
+		want   bool
+		prompt bool
+	}{
+		{name: "y", input: "y\n", want: true, prompt: true},
+		{name: "yes in uppercase", input: " YES \r\n", want: true, prompt: true},
+		{name: "y without a newline", input: "y", want: true, prompt: true},
+		{name: "n", input: "n\n", want: false, prompt: true},
+		{name: "anything else", input: "sure\n", want: false, prompt: true},
+		{name: "EOF", input: "", want: false, prompt: true},
+		{name: "--yes", input: "", yes: true, want: true},
+	}
+	for _, test := range tests {
+		previous := addYes
+		addYes = test.yes
+		var out bytes.Buffer
+		got := confirmPolicy(strings.NewReader(test.input), &out, "ep", policy)
+		addYes = previous
+
+		if got != test.want {
+			t.Errorf("%s: confirmPolicy = %v, want %v", test.name, got, test.want)
+		}
+		prompted := strings.Contains(out.String(), "Add this policy to ep? [y/N] ")
+		if prompted != test.prompt {
+			t.Errorf("%s: confirmPolicy printed %q, want a prompt: %v", test.name, out.String(), test.prompt)
+		}
+		if prompted && !strings.Contains(out.String(), "S-1-5-18") {
+			t.Errorf("%s: confirmPolicy printed %q, want the effective policy", test.name, out.String())
+		}
+	}
+}
@@ -0,0 +1,72 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	proxy "github.com/microsoft/hcnproxyctrl/proxy"
+	"github.com/spf13/cobra"
+)
+
+// Flags for the "coverage" command
+var (
+	coverageRange proxy.TupleRange
+)
+
+var cmdCoverage = &cobra.Command{
+	Use:   "coverage <HNS endpoint ID or name>",
+	Short: "Report the TCP traffic that no proxy policy of an endpoint intercepts",
+	Args:  cobra.ExactArgs(1),
+
+	Run: func(cmd *cobra.Command, args []string) {
+		endpointID := resolveEndpoint(args[0])
+
+		var policies []proxy.Policy
+		err := callHNS(func() (err error) {
+			policies, err = proxy.ListPolicies(endpointID)
+			return err
+		})
+		if err != nil {
+			errorOut(err)
+		}
+
+		tupleRange := coverageRange
+		tupleRange.Protocol = "6"
+		interceptions, err := proxy.Simulate(policies, tupleRange)
+		if err != nil {
+			errorOut(err)
+		}
+
+		var gaps []proxy.Interception
+		for _, interception := range interceptions {
+			if !interception.Intercepted {
+				gaps = append(gaps, interception)
+			}
+		}
+		if len(gaps) == 0 {
+			fmt.Println("All the traffic is intercepted")
+			return
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "LOCALADDRESSES\tLOCALPORTS\tREMOTEADDRESSES\tREMOTEPORTS")
+		for _, gap := range gaps {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", gap.LocalAddresses, gap.LocalPorts, gap.RemoteAddresses, gap.RemotePorts)
+		}
+		w.Flush()
+		fmt.Println("Found", len(gaps), "gaps")
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(cmdCoverage)
+
+	cmdCoverage.Flags().StringVar(&coverageRange.LocalAddresses, "localaddr", "", "IPv4 address or CIDR of the local end of the traffic meant to be intercepted (all if unset)")
+	cmdCoverage.Flags().StringVar(&coverageRange.LocalPorts, "localports", "", "port or port range of the local end of the traffic meant to be intercepted (all if unset)")
+	cmdCoverage.Flags().StringVar(&coverageRange.RemoteAddresses, "remoteaddr", "", "IPv4 address or CIDR of the remote end of the traffic meant to be intercepted (all if unset)")
+	cmdCoverage.Flags().StringVar(&coverageRange.RemotePorts, "remoteports", "", "port or port range of the remote end of the traffic meant to be intercepted (all if unset)")
+}
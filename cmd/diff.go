@@ -0,0 +1,71 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package cmd
+
+import (
+	"fmt"
+
+	proxy "github.com/microsoft/hcnproxyctrl/proxy"
+	"github.com/spf13/cobra"
+)
+
+// Flags for the "diff-endpoints" command
+var (
+	diffOutput string
+)
+
+var cmdDiffEndpoints = &cobra.Command{
+	Use:   "diff-endpoints <HNS endpoint ID or name> <HNS endpoint ID or name>",
+	Short: "Compare the proxy policies of two endpoints",
+	Args:  cobra.ExactArgs(2),
+
+	Run: func(cmd *cobra.Command, args []string) {
+		switch diffOutput {
+		case "", "json":
+		default:
+			errorOut(fmt.Errorf("unknown output format %q", diffOutput))
+		}
+
+		var policies [2][]proxy.Policy
+		for i, arg := range args {
+			endpointID := resolveEndpoint(arg)
+			err := callHNS(func() (err error) {
+				policies[i], err = proxy.ListPolicies(endpointID)
+				return err
+			})
+			if err != nil {
+				errorOut(err)
+			}
+		}
+
+		diff := proxy.DiffPolicies(policies[0], policies[1])
+		if diffOutput == "json" {
+			out, err := marshalJSON(diff)
+			if err != nil {
+				errorOut(err)
+			}
+			fmt.Println(string(out))
+			return
+		}
+
+		for _, policy := range diff.Removed {
+			fmt.Printf("- %s %+v\n", policy.Key(), policy)
+		}
+		for _, policy := range diff.Added {
+			fmt.Printf("+ %s %+v\n", policy.Key(), policy)
+		}
+		for _, change := range diff.Changed {
+			fmt.Printf("~ %s %+v\n  %s %+v\n", change.From.Key(), change.From, change.To.Key(), change.To)
+		}
+		if diff.Empty() {
+			fmt.Println("The endpoints have equivalent proxy policies")
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(cmdDiffEndpoints)
+
+	cmdDiffEndpoints.Flags().StringVarP(&diffOutput, "output", "o", "", `output format, "json" or the default "-" for removed, "+" for added and "~" for changed policies`)
+}
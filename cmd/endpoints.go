@@ -0,0 +1,132 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"strings"
+
+	proxy "github.com/microsoft/hcnproxyctrl/proxy"
+)
+
+// stdin is where endpoint IDs are read from when "-" is passed instead of an
+// endpoint ID.
+var stdin io.Reader = os.Stdin
+
+// readEndpointIDs reads endpoint IDs, one per line, skipping blank lines and
+// lines starting with "#".
+func readEndpointIDs(r io.Reader) ([]string, error) {
+	var endpointIDs []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		endpointIDs = append(endpointIDs, line)
+	}
+	return endpointIDs, scanner.Err()
+}
+
+// resolveEndpoint returns the ID of the endpoint designated by an argument,
+// which can be an endpoint ID or name.
+func resolveEndpoint(arg string) string {
+	var endpointID string
+	err := callHNS(func() (err error) {
+		endpointID, err = proxy.ResolveEndpointID(arg)
+		return err
+	})
+	if err != nil {
+		errorOut(err)
+	}
+	return endpointID
+}
+
+// forEachEndpoint calls fn with the endpoint designated by the argument, or,
+// if the argument is "-", with each of the endpoints read from stdin. In the
+// latter case, errors are reported per endpoint without stopping, and a
+// summary is printed at the end. In both cases, the process exits with an
+// error status if fn failed. Endpoints can be designated by ID or name.
+func forEachEndpoint(arg string, fn func(endpointID string) error) {
+	if arg != "-" {
+		if err := fn(resolveEndpoint(arg)); err != nil {
+			errorOut(err)
+		}
+		return
+	}
+
+	endpointIDs, err := readEndpointIDs(stdin)
+	if err != nil {
+		errorOut(err)
+	}
+
+	summary := forEndpoints(endpointIDs, fn)
+	summary.finish()
+}
+
+// endpointArgs returns the endpoints designated by the arguments, either
+// several endpoint IDs or names, or a single "-" to read them from stdin.
+func endpointArgs(args []string) ([]string, error) {
+	if len(args) == 1 && args[0] == "-" {
+		return readEndpointIDs(stdin)
+	}
+	for _, arg := range args {
+		if arg == "-" {
+			return nil, errors.New(`"-" cannot be combined with other endpoints`)
+		}
+	}
+	return args, nil
+}
+
+// forEndpoints calls fn with each of the endpoints, designated by ID or name.
+// Errors are reported per endpoint without stopping, and the returned summary
+// counts the outcomes.
+func forEndpoints(endpointIDs []string, fn func(endpointID string) error) batchSummary {
+	var summary batchSummary
+	for _, endpointIDOrName := range endpointIDs {
+		var endpointID string
+		err := callHNS(func() (err error) {
+			endpointID, err = proxy.ResolveEndpointID(endpointIDOrName)
+			return err
+		})
+		if err == nil {
+			err = fn(endpointID)
+		}
+		if err != nil {
+			summary.fail(endpointIDOrName, err)
+		} else {
+			summary.succeeded++
+		}
+	}
+	return summary
+}
+
+// forEndpointsSupportingProxy calls fn with each of the endpoints, skipping
+// those that do not support proxy policies. Errors are reported per endpoint
+// without stopping, and a summary is printed at the end. The process exits
+// with an error status if fn failed for any endpoint, or with --fail-on-skip,
+// if any endpoint was skipped.
+func forEndpointsSupportingProxy(endpointIDs []string, fn func(endpointID string) error) {
+	var summary batchSummary
+	for _, endpointID := range endpointIDs {
+		err := checkProxySupport(endpointID)
+		if err == proxy.ErrProxyNotSupported {
+			summary.skip(endpointID, "the endpoint does not support proxy policies")
+			continue
+		}
+		if err == nil {
+			err = fn(endpointID)
+		}
+		if err != nil {
+			summary.fail(endpointID, err)
+		} else {
+			summary.succeeded++
+		}
+	}
+
+	summary.finish()
+}
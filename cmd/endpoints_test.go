@@ -0,0 +1,54 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package cmd
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// setStdin replaces stdin by the given input for the duration of the test.
+func setStdin(t *testing.T, input string) {
+	previous := stdin
+	stdin = strings.NewReader(input)
+	t.Cleanup(func() { stdin = previous })
+}
+
+func TestEndpointArgs(t *testing.T) {
+	const input = "ep1\n\n  ep2  \n# a comment\r\nep3"
+
+	tests := []struct {
+		name      string
+		args      []string
+		endpoints []string
+		err       string
+	}{
+		{name: "stdin", args: []string{"-"}, endpoints: []string{"ep1", "ep2", "ep3"}},
+		{name: "arguments", args: []string{"ep1", "web"}, endpoints: []string{"ep1", "web"}},
+		{name: "stdin along with other endpoints", args: []string{"ep1", "-"}, err: `"-" cannot be combined with other endpoints`},
+	}
+	for _, test := range tests {
+		setStdin(t, input)
+		endpoints, err := endpointArgs(test.args)
+		if len(test.err) > 0 {
+			if err == nil || err.Error() != test.err {
+				t.Errorf("%s: endpointArgs error %v, want %q", test.name, err, test.err)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(endpoints, test.endpoints) {
+			t.Errorf("%s: endpointArgs = %q, %v, want %q", test.name, endpoints, err, test.endpoints)
+		}
+	}
+}
+
+func TestReadEndpointIDsEmpty(t *testing.T) {
+	for _, input := range []string{"", "\n\n", "# none\n"} {
+		endpointIDs, err := readEndpointIDs(strings.NewReader(input))
+		if err != nil || len(endpointIDs) != 0 {
+			t.Errorf("readEndpointIDs(%q) = %q, %v, want no endpoint", input, endpointIDs, err)
+		}
+	}
+}
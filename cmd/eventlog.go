@@ -0,0 +1,65 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	proxy "github.com/microsoft/hcnproxyctrl/proxy"
+)
+
+// eventLogSource is the source name under which changes to proxy policies are
+// recorded in the Windows Event Log. Event logging is disabled if it is empty.
+var eventLogSource string
+
+// eventLogger writes to an event log. It is implemented by the Windows Event
+// Log, see openEventLog.
+type eventLogger interface {
+	Info(eventID uint32, message string) error
+	Error(eventID uint32, message string) error
+}
+
+// eventIDs are the IDs of the events recorded for each operation. Failed
+// operations are recorded with the same IDs and the error severity.
+var eventIDs = map[string]uint32{
+	"add":       1,
+	"clear":     2,
+	"remove":    3,
+	"update":    4,
+	"reconcile": 5,
+}
+
+// unknownEventID is the ID of the events recorded for operations missing
+// from eventIDs.
+const unknownEventID = 100
+
+// formatEvent returns the ID and message of the event recording a change, and
+// whether it failed.
+func formatEvent(event proxy.HookEvent, result error) (eventID uint32, message string, failed bool) {
+	eventID, ok := eventIDs[event.Operation]
+	if !ok {
+		eventID = unknownEventID
+	}
+
+	var b strings.Builder
+	if result != nil {
+		fmt.Fprintf(&b, "Failed to %s %d proxy policies on endpoint %s: %v\n", event.Operation, len(event.Policies), event.EndpointID, result)
+	} else {
+		fmt.Fprintf(&b, "Applied %s of %d proxy policies on endpoint %s\n", event.Operation, len(event.Policies), event.EndpointID)
+	}
+	for _, policy := range event.Policies {
+		fmt.Fprintf(&b, "%+v\n", policy)
+	}
+	return eventID, strings.TrimSuffix(b.String(), "\n"), result != nil
+}
+
+// logEvent records a change, with the error severity if it failed.
+func logEvent(logger eventLogger, event proxy.HookEvent, result error) error {
+	eventID, message, failed := formatEvent(event, result)
+	if failed {
+		return logger.Error(eventID, message)
+	}
+	return logger.Info(eventID, message)
+}
@@ -0,0 +1,12 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+//go:build !windows
+// +build !windows
+
+package cmd
+
+// openEventLog returns no logger, as there is no Windows Event Log.
+func openEventLog(source string) (eventLogger, error) {
+	return nil, nil
+}
@@ -0,0 +1,24 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package cmd
+
+import (
+	"golang.org/x/sys/windows/registry"
+	"golang.org/x/sys/windows/svc/eventlog"
+)
+
+// openEventLog opens the Windows Event Log for the given source, registering
+// the source first if needed.
+func openEventLog(source string) (eventLogger, error) {
+	key, err := registry.OpenKey(registry.LOCAL_MACHINE, `SYSTEM\CurrentControlSet\Services\EventLog\Application\`+source, registry.QUERY_VALUE)
+	if err == nil {
+		key.Close()
+	} else {
+		if err := eventlog.InstallAsEventCreate(source, eventlog.Error|eventlog.Info); err != nil {
+			return nil, err
+		}
+	}
+
+	return eventlog.Open(source)
+}
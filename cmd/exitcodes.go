@@ -0,0 +1,50 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package cmd
+
+import (
+	"errors"
+
+	"github.com/Microsoft/hcsshim/hcn"
+	cri "github.com/microsoft/hcnproxyctrl/cri"
+	proxy "github.com/microsoft/hcnproxyctrl/proxy"
+)
+
+// Exit codes of the commands, telling the class of failure apart for
+// automation. Commands checking a condition (eg. verify-intercept or
+// reconcile --check) exit with exitFailure when it does not hold.
+const (
+	// exitFailure is the exit code of any other failure.
+	exitFailure = 1
+	// exitInvalidInput is the exit code of invalid command lines and policies.
+	exitInvalidInput = 2
+	// exitNotFound is the exit code when the endpoint, policy or container
+	// to operate on does not exist, or the container has no endpoint.
+	exitNotFound = 3
+	// exitRuntimeUnavailable is the exit code when the container runtime
+	// cannot be connected to.
+	exitRuntimeUnavailable = 4
+)
+
+// exitCode returns the exit code to report the error with.
+func exitCode(err error) int {
+	if _, ok := err.(*proxy.ValidationError); ok {
+		return exitInvalidInput
+	}
+	if _, ok := err.(*proxy.EndpointNotFoundError); ok {
+		return exitNotFound
+	}
+	if hcn.IsNotFoundError(err) {
+		return exitNotFound
+	}
+	for _, notFound := range []error{proxy.ErrPolicyNotFound, proxy.ErrContainerNotFound, proxy.ErrEndpointNotAttached} {
+		if errors.Is(err, notFound) {
+			return exitNotFound
+		}
+	}
+	if _, ok := err.(*cri.ConnectError); ok {
+		return exitRuntimeUnavailable
+	}
+	return exitFailure
+}
@@ -0,0 +1,18 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package cmd
+
+import proxy "github.com/microsoft/hcnproxyctrl/proxy"
+
+// The proxy functions calling HNS and CRI on behalf of apply-to-containers
+// and of the routes of serve. They are variables so that HNS and CRI can be
+// replaced where they are not available, eg. by fakes in tests.
+var (
+	getEndpointsForContainers       = proxy.GetEndpointsForContainers
+	addPolicy                       = proxy.AddPolicy
+	addPolicyContext                = proxy.AddPolicyContext
+	listPoliciesContext             = proxy.ListPoliciesContext
+	clearPoliciesContext            = proxy.ClearPoliciesContext
+	getContainerEndpointInfoContext = proxy.GetContainerEndpointInfoContext
+)
@@ -0,0 +1,105 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+
+	proxy "github.com/microsoft/hcnproxyctrl/proxy"
+)
+
+// Commands run before and after every change made to proxy policies. They run
+// with the privileges of hcnproxyctrl, which are typically administrative, so
+// only commands that are themselves protected from tampering should be used.
+var (
+	preHookCommand  string
+	postHookCommand string
+)
+
+// debugHNS prints every request made to HNS to modify policies, with its
+// outcome, for inclusion in bug reports.
+var debugHNS bool
+
+// installHooks sets up the library hooks to run the --pre-hook and --post-hook
+// commands, if any, to record changes in the event log and notify the
+// webhook if enabled, and to print the HNS requests with --debug-hns.
+func installHooks() error {
+	var logger eventLogger
+	if len(eventLogSource) > 0 {
+		var err error
+		if logger, err = openEventLog(eventLogSource); err != nil {
+			return fmt.Errorf("could not open the event log: %v", err)
+		}
+	}
+
+	var hooks proxy.Hooks
+	if len(preHookCommand) > 0 {
+		hooks.PreApply = func(event proxy.HookEvent) error {
+			return runHook(preHookCommand, event, nil)
+		}
+	}
+	if len(postHookCommand) > 0 || logger != nil || len(webhookURL) > 0 {
+		hooks.PostApply = func(event proxy.HookEvent, result error) {
+			if len(webhookURL) > 0 && result == nil {
+				notifyWebhook(event)
+			}
+			if logger != nil {
+				if err := logEvent(logger, event, result); err != nil {
+					fmt.Fprintln(os.Stderr, "could not write the event log:", err)
+				}
+			}
+			if len(postHookCommand) > 0 {
+				if err := runHook(postHookCommand, event, result); err != nil {
+					fmt.Fprintln(os.Stderr, "post-apply hook failed:", err)
+				}
+			}
+		}
+	}
+	if debugHNS {
+		hooks.PostRequest = printRequestResult
+	}
+	proxy.SetHooks(hooks)
+	return nil
+}
+
+// printRequestResult prints the outcome of an HNS request on stderr, for
+// --debug-hns.
+func printRequestResult(result proxy.RequestResult) {
+	outcome := "succeeded"
+	if result.Err != nil {
+		outcome = "failed: " + result.Err.Error()
+	}
+	fmt.Fprintf(os.Stderr, "HNS %s request on endpoint %s %s after %v\n  request: %s\n",
+		result.RequestType, result.EndpointID, outcome, result.Duration, result.Request)
+}
+
+// runHook runs a hook command, passing it the event as JSON on stdin and, in
+// environment variables, the operation, the endpoint ID and, after the
+// change, its result.
+func runHook(command string, event proxy.HookEvent, result error) error {
+	input, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command(command)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(),
+		"HCNPROXY_OPERATION="+event.Operation,
+		"HCNPROXY_ENDPOINT="+event.EndpointID,
+	)
+	if result != nil {
+		cmd.Env = append(cmd.Env, "HCNPROXY_RESULT="+result.Error())
+	} else {
+		cmd.Env = append(cmd.Env, "HCNPROXY_RESULT=success")
+	}
+
+	return cmd.Run()
+}
@@ -0,0 +1,89 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	proxy "github.com/microsoft/hcnproxyctrl/proxy"
+	"github.com/spf13/cobra"
+	"sigs.k8s.io/yaml"
+)
+
+// Flags for the "lint" command
+var (
+	lintFile  string
+	lintRules string
+)
+
+var cmdLint = &cobra.Command{
+	Use:   "lint --file <policy file> [--rules <rules file>]",
+	Short: "Check the policies of a file against the conventions of a rules file",
+	Example: `  # With rules.yaml holding:
+  #   RequiredFields:
+  #   - Field: UserSID
+  #   DisallowedWildcards:
+  #   - Field: RemoteAddresses
+  #     Severity: warning
+  #   PriorityBand:
+  #     Band: 1000-2000
+  hcnproxyctrl.exe lint --file policies.yaml --rules rules.yaml`,
+	Args: cobra.NoArgs,
+
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(lintFile) == 0 {
+			errorOut(errors.New("--file must be specified"))
+		}
+		policies, err := readPolicyFile(lintFile)
+		if err != nil {
+			errorOut(err)
+		}
+		var rules proxy.LintRules
+		if len(lintRules) > 0 {
+			if rules, err = readLintRules(lintRules); err != nil {
+				errorOut(err)
+			}
+		}
+
+		violations, err := proxy.Lint(policies, rules)
+		if err != nil {
+			errorOut(err)
+		}
+
+		numErrors := 0
+		for _, violation := range violations {
+			fmt.Printf("policy %d: %s: %s (%s)\n", violation.Index+1, violation.Severity, violation.Message, violation.Rule)
+			if violation.Severity == proxy.SeverityError {
+				numErrors++
+			}
+		}
+		fmt.Printf("%d errors, %d warnings\n", numErrors, len(violations)-numErrors)
+		if numErrors > 0 {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(cmdLint)
+
+	cmdLint.Flags().StringVar(&lintFile, "file", "", "YAML or JSON file holding the list of policies to check")
+	cmdLint.Flags().StringVar(&lintRules, "rules", "", "YAML or JSON file holding the rules to check the policies against (only their validity is checked if omitted)")
+}
+
+// readLintRules reads the lint rules of a YAML or JSON file.
+func readLintRules(path string) (proxy.LintRules, error) {
+	var rules proxy.LintRules
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return rules, err
+	}
+	if err := yaml.Unmarshal(data, &rules); err != nil {
+		return rules, fmt.Errorf("invalid rules file %s: %v", path, err)
+	}
+	return rules, nil
+}
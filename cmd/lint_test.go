@@ -0,0 +1,68 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package cmd
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	proxy "github.com/microsoft/hcnproxyctrl/proxy"
+)
+
+func TestReadLintRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		rules   proxy.LintRules
+		err     string
+	}{
+		{
+			name: "required fields",
+			content: `RequiredFields:
+- Field: UserSID
+- Field: Priority
+  Severity: warning
+`,
+			rules: proxy.LintRules{RequiredFields: []proxy.FieldRule{{Field: "UserSID"}, {Field: "Priority", Severity: proxy.SeverityWarning}}},
+		},
+		{
+			name: "disallowed wildcards",
+			content: `DisallowedWildcards:
+- Field: RemoteAddresses
+  Severity: warning
+`,
+			rules: proxy.LintRules{DisallowedWildcards: []proxy.FieldRule{{Field: "RemoteAddresses", Severity: proxy.SeverityWarning}}},
+		},
+		{
+			name: "priority band",
+			content: `PriorityBand:
+  Band: 1000-2000
+`,
+			rules: proxy.LintRules{PriorityBand: &proxy.PriorityBandRule{Band: "1000-2000"}},
+		},
+		{
+			name:    "JSON",
+			content: `{"RequiredFields": [{"Field": "UserSID", "Severity": "error"}]}`,
+			rules:   proxy.LintRules{RequiredFields: []proxy.FieldRule{{Field: "UserSID", Severity: proxy.SeverityError}}},
+		},
+		{
+			name:    "invalid",
+			content: "RequiredFields: UserSID\n",
+			err:     "invalid rules file",
+		},
+	}
+	for _, test := range tests {
+		rules, err := readLintRules(writeFile(t, "rules.yaml", test.content))
+		if len(test.err) > 0 {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: readLintRules error %v, want %q", test.name, err, test.err)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(rules, test.rules) {
+			t.Errorf("%s: readLintRules = %+v, %v, want %+v", test.name, rules, err, test.rules)
+		}
+	}
+}
@@ -0,0 +1,265 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package cmd
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	proxy "github.com/microsoft/hcnproxyctrl/proxy"
+)
+
+// jsonIndent is the number of spaces JSON output is indented with, 0 for
+// compact output on a single line.
+var jsonIndent int
+
+// marshalJSON encodes v for JSON output, indented according to --json-indent.
+func marshalJSON(v interface{}) ([]byte, error) {
+	if jsonIndent == 0 {
+		return json.Marshal(v)
+	}
+	return json.MarshalIndent(v, "", strings.Repeat(" ", jsonIndent))
+}
+
+// policyColumn is a column of the table output, showing a field of the policies.
+type policyColumn struct {
+	name  string
+	value func(proxy.Policy) string
+}
+
+// policyColumns are the columns of the table output, in their default order.
+var policyColumns = []policyColumn{
+	{"PROXYPORT", func(p proxy.Policy) string { return p.ProxyPort }},
+	{"USERSID", func(p proxy.Policy) string { return p.UserSID }},
+	{"LOCALADDRESSES", func(p proxy.Policy) string { return p.LocalAddresses }},
+	{"REMOTEADDRESSES", func(p proxy.Policy) string { return p.RemoteAddresses }},
+	{"LOCALPORTS", func(p proxy.Policy) string { return p.LocalPorts }},
+	{"REMOTEPORTS", func(p proxy.Policy) string { return p.RemotePorts }},
+	{"PRIORITY", func(p proxy.Policy) string { return strconv.Itoa(int(p.Priority)) }},
+	{"PROTOCOL", func(p proxy.Policy) string { return p.Protocol }},
+	{"KEY", func(p proxy.Policy) string { return p.Key() }},
+}
+
+// parseColumns returns the columns named in a comma-separated list, in the
+// given order, or all the columns if the list is empty. Names are
+// case-insensitive.
+func parseColumns(list string) ([]policyColumn, error) {
+	if len(list) == 0 {
+		return policyColumns, nil
+	}
+
+	var columns []policyColumn
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		found := false
+		for _, column := range policyColumns {
+			if strings.EqualFold(column.name, name) {
+				columns = append(columns, column)
+				found = true
+				break
+			}
+		}
+		if !found {
+			var valid []string
+			for _, column := range policyColumns {
+				valid = append(valid, strings.ToLower(column.name))
+			}
+			return nil, fmt.Errorf("unknown column %q, valid columns are %s", name, strings.Join(valid, ", "))
+		}
+	}
+	return columns, nil
+}
+
+// formatTable formats the given columns of the policies as a table. If
+// statuses is not nil, it holds the status of the proxy of each policy, shown
+// in a last STATUS column.
+func formatTable(policies []proxy.Policy, statuses []proxy.ProxyStatus, columns []policyColumn) string {
+	var b strings.Builder
+	w := tabwriter.NewWriter(&b, 0, 8, 2, ' ', 0)
+
+	var cells []string
+	for _, column := range columns {
+		cells = append(cells, column.name)
+	}
+	if statuses != nil {
+		cells = append(cells, "STATUS")
+	}
+	fmt.Fprintln(w, strings.Join(cells, "\t"))
+
+	for i, policy := range policies {
+		cells = cells[:0]
+		for _, column := range columns {
+			cells = append(cells, column.value(policy))
+		}
+		if statuses != nil {
+			cells = append(cells, string(statuses[i]))
+		}
+		fmt.Fprintln(w, strings.Join(cells, "\t"))
+	}
+
+	w.Flush()
+	return b.String()
+}
+
+// listedPolicy is a policy as output by the list command in the json and yaml
+// formats, along with its key, eg. for remove --key, and the status of its
+// proxy with --check-proxy.
+type listedPolicy struct {
+	proxy.Policy
+	Key    string            `json:"key"`
+	Status proxy.ProxyStatus `json:"status,omitempty"`
+}
+
+// listedPolicies returns the policies for the json and yaml output formats. If
+// statuses is not nil, it holds the status of the proxy of each policy.
+func listedPolicies(policies []proxy.Policy, statuses []proxy.ProxyStatus) []listedPolicy {
+	listed := make([]listedPolicy, len(policies))
+	for i, policy := range policies {
+		listed[i].Policy = policy
+		listed[i].Key = policy.Key()
+		if statuses != nil {
+			listed[i].Status = statuses[i]
+		}
+	}
+	return listed
+}
+
+// formatEnv formats the policies as shell variable assignments that can be
+// sourced by a POSIX shell, eg.
+//
+//      HCNPROXY_COUNT=1
+//      HCNPROXY_0_PORT='8000'
+//      HCNPROXY_0_REMOTEPORTS='80-443'
+//
+// Values are single-quoted so that they are never interpreted by the shell.
+func formatEnv(policies []proxy.Policy) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "HCNPROXY_COUNT=%d\n", len(policies))
+	for i, policy := range policies {
+		vars := []struct {
+			name  string
+			value string
+		}{
+			{"PORT", policy.ProxyPort},
+			{"USERSID", policy.UserSID},
+			{"LOCALADDRESSES", policy.LocalAddresses},
+			{"REMOTEADDRESSES", policy.RemoteAddresses},
+			{"LOCALPORTS", policy.LocalPorts},
+			{"REMOTEPORTS", policy.RemotePorts},
+			{"PRIORITY", strconv.Itoa(int(policy.Priority))},
+			{"PROTOCOL", policy.Protocol},
+		}
+		for _, v := range vars {
+			fmt.Fprintf(&b, "HCNPROXY_%d_%s=%s\n", i, v.name, shellQuote(v.value))
+		}
+	}
+	return b.String()
+}
+
+// csvHeader is the header row of the CSV output, naming the fields of the
+// policies.
+var csvHeader = []string{"ProxyPort", "UserSID", "LocalAddresses", "RemoteAddresses", "LocalPorts", "RemotePorts", "Priority", "Protocol"}
+
+// writeCSV writes a CSV row for each policy, preceded by the header row if
+// header is true. If endpointID is not empty, it is added as a first column.
+// If statuses is not nil, it holds the status of the proxy of each policy,
+// added as a last column.
+func writeCSV(w io.Writer, endpointID string, policies []proxy.Policy, statuses []proxy.ProxyStatus, header bool) error {
+	writer := csv.NewWriter(w)
+	if header {
+		row := csvHeader
+		if len(endpointID) > 0 {
+			row = append([]string{"EndpointID"}, row...)
+		}
+		if statuses != nil {
+			row = append(row, "Status")
+		}
+		if err := writer.Write(row); err != nil {
+			return err
+		}
+	}
+
+	for i, policy := range policies {
+		row := []string{
+			policy.ProxyPort,
+			policy.UserSID,
+			policy.LocalAddresses,
+			policy.RemoteAddresses,
+			policy.LocalPorts,
+			policy.RemotePorts,
+			strconv.Itoa(int(policy.Priority)),
+			policy.Protocol,
+		}
+		if len(endpointID) > 0 {
+			row = append([]string{endpointID}, row...)
+		}
+		if statuses != nil {
+			row = append(row, string(statuses[i]))
+		}
+		if err := writer.Write(row); err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// formatSummary formats the policies of an endpoint as a single line, eg.
+//
+//      endpoint=93f86a7f-e361-4362-b8a4-81bbb6a622dd policies=2 protocols=tcp priorities=100-200
+func formatSummary(endpointID string, policies []proxy.Policy) string {
+	summary := fmt.Sprintf("endpoint=%s policies=%d", endpointID, len(policies))
+	if len(policies) == 0 {
+		return summary
+	}
+
+	var protocols []string
+	seen := make(map[string]bool)
+	minPriority, maxPriority := policies[0].Priority, policies[0].Priority
+	for _, policy := range policies {
+		name := protocolName(policy.Protocol)
+		if !seen[name] {
+			seen[name] = true
+			protocols = append(protocols, name)
+		}
+		if policy.Priority < minPriority {
+			minPriority = policy.Priority
+		}
+		if policy.Priority > maxPriority {
+			maxPriority = policy.Priority
+		}
+	}
+	sort.Strings(protocols)
+
+	priorities := strconv.Itoa(int(minPriority))
+	if maxPriority != minPriority {
+		priorities += "-" + strconv.Itoa(int(maxPriority))
+	}
+
+	return fmt.Sprintf("%s protocols=%s priorities=%s", summary, strings.Join(protocols, ","), priorities)
+}
+
+// protocolName returns the lowercase name of an IANA protocol number if it is
+// well known, or the number itself otherwise.
+func protocolName(protocol string) string {
+	switch protocol {
+	case "6":
+		return "tcp"
+	case "17":
+		return "udp"
+	}
+	return protocol
+}
+
+// shellQuote wraps s in single quotes, escaping the single quotes it contains.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,279 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	proxy "github.com/microsoft/hcnproxyctrl/proxy"
+)
+
+var testPolicies = []proxy.Policy{
+	{ProxyPort: "15001", RemotePorts: "80", Protocol: "6"},
+	{ProxyPort: "15002", RemotePorts: "443", Protocol: "6"},
+}
+
+func TestFormatTableStatus(t *testing.T) {
+	columns, err := parseColumns("proxyport,remoteports")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		statuses []proxy.ProxyStatus
+		want     string
+	}{
+		{
+			name: "without --check-proxy",
+			want: "PROXYPORT  REMOTEPORTS\n" +
+				"15001      80\n" +
+				"15002      443\n",
+		},
+		{
+			name:     "with --check-proxy",
+			statuses: []proxy.ProxyStatus{proxy.ProxyHealthy, proxy.ProxyStale},
+			want: "PROXYPORT  REMOTEPORTS  STATUS\n" +
+				"15001      80           healthy\n" +
+				"15002      443          stale\n",
+		},
+	}
+	for _, test := range tests {
+		if got := formatTable(testPolicies, test.statuses, columns); got != test.want {
+			t.Errorf("%s: formatTable =\n%s\nwant\n%s", test.name, got, test.want)
+		}
+	}
+}
+
+func TestWriteCSVStatus(t *testing.T) {
+	tests := []struct {
+		name       string
+		endpointID string
+		policies   []proxy.Policy
+		statuses   []proxy.ProxyStatus
+		want       string
+	}{
+		{
+			name:     "without --check-proxy",
+			policies: testPolicies,
+			want: "ProxyPort,UserSID,LocalAddresses,RemoteAddresses,LocalPorts,RemotePorts,Priority,Protocol\n" +
+				"15001,,,,,80,0,6\n" +
+				"15002,,,,,443,0,6\n",
+		},
+		{
+			name:       "with --check-proxy and an endpoint column",
+			endpointID: "ep",
+			policies:   testPolicies,
+			statuses:   []proxy.ProxyStatus{proxy.ProxyUnknown, proxy.ProxyHealthy},
+			want: "EndpointID,ProxyPort,UserSID,LocalAddresses,RemoteAddresses,LocalPorts,RemotePorts,Priority,Protocol,Status\n" +
+				"ep,15001,,,,,80,0,6,unknown\n" +
+				"ep,15002,,,,,443,0,6,healthy\n",
+		},
+		{
+			name:     "with --check-proxy and no policy",
+			statuses: []proxy.ProxyStatus{},
+			want:     "ProxyPort,UserSID,LocalAddresses,RemoteAddresses,LocalPorts,RemotePorts,Priority,Protocol,Status\n",
+		},
+	}
+	for _, test := range tests {
+		var b bytes.Buffer
+		if err := writeCSV(&b, test.endpointID, test.policies, test.statuses, true); err != nil {
+			t.Fatal(err)
+		}
+		if got := b.String(); got != test.want {
+			t.Errorf("%s: writeCSV =\n%s\nwant\n%s", test.name, got, test.want)
+		}
+	}
+}
+
+func TestListedPoliciesJSON(t *testing.T) {
+	encoded, err := json.Marshal(listedPolicies(testPolicies[:1], nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(encoded), `"status"`) {
+		t.Errorf("policies listed without --check-proxy have a status: %s", encoded)
+	}
+
+	encoded, err = json.Marshal(listedPolicies(testPolicies[:1], []proxy.ProxyStatus{proxy.ProxyStale}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var listed []map[string]interface{}
+	if err := json.Unmarshal(encoded, &listed); err != nil {
+		t.Fatal(err)
+	}
+	if len(listed) != 1 || listed[0]["status"] != "stale" || listed[0]["proxyPort"] != "15001" {
+		t.Errorf("listed policies %s, want the policy fields along with status stale", encoded)
+	}
+	if key := testPolicies[0].Key(); len(listed) != 1 || listed[0]["key"] != key {
+		t.Errorf("listed policies %s, want the key %s of the policy", encoded, key)
+	}
+
+	if encoded, _ := json.Marshal(listedPolicies(nil, nil)); string(encoded) != "[]" {
+		t.Errorf("no policies listed as %s, want []", encoded)
+	}
+}
+
+func TestFormatEnv(t *testing.T) {
+	tests := []struct {
+		name     string
+		policies []proxy.Policy
+		want     string
+	}{
+		{
+			name: "no policy",
+			want: "HCNPROXY_COUNT=0\n",
+		},
+		{
+			name: "quoted values",
+			policies: []proxy.Policy{
+				{ProxyPort: "15001", UserSID: "S-1-5-18", RemoteAddresses: "10.0.0.1,10.0.0.2", RemotePorts: "80-443", Priority: 100, Protocol: "6"},
+				{ProxyPort: "15002", LocalAddresses: "it's $(not) `run`"},
+			},
+			want: "HCNPROXY_COUNT=2\n" +
+				"HCNPROXY_0_PORT='15001'\n" +
+				"HCNPROXY_0_USERSID='S-1-5-18'\n" +
+				"HCNPROXY_0_LOCALADDRESSES=''\n" +
+				"HCNPROXY_0_REMOTEADDRESSES='10.0.0.1,10.0.0.2'\n" +
+				"HCNPROXY_0_LOCALPORTS=''\n" +
+				"HCNPROXY_0_REMOTEPORTS='80-443'\n" +
+				"HCNPROXY_0_PRIORITY='100'\n" +
+				"HCNPROXY_0_PROTOCOL='6'\n" +
+				"HCNPROXY_1_PORT='15002'\n" +
+				"HCNPROXY_1_USERSID=''\n" +
+				"HCNPROXY_1_LOCALADDRESSES='it'\\''s $(not) `run`'\n" +
+				"HCNPROXY_1_REMOTEADDRESSES=''\n" +
+				"HCNPROXY_1_LOCALPORTS=''\n" +
+				"HCNPROXY_1_REMOTEPORTS=''\n" +
+				"HCNPROXY_1_PRIORITY='0'\n" +
+				"HCNPROXY_1_PROTOCOL=''\n",
+		},
+	}
+	for _, test := range tests {
+		if got := formatEnv(test.policies); got != test.want {
+			t.Errorf("%s: formatEnv =\n%s\nwant\n%s", test.name, got, test.want)
+		}
+	}
+}
+
+func TestFormatSummary(t *testing.T) {
+	tests := []struct {
+		name     string
+		policies []proxy.Policy
+		want     string
+	}{
+		{
+			name: "no policy",
+			want: "endpoint=ep policies=0",
+		},
+		{
+			name:     "single priority",
+			policies: testPolicies,
+			want:     "endpoint=ep policies=2 protocols=tcp priorities=0",
+		},
+		{
+			name: "protocols and priority range",
+			policies: []proxy.Policy{
+				{ProxyPort: "15001", Protocol: "17", Priority: 200},
+				{ProxyPort: "15002", Protocol: "6", Priority: 100},
+				{ProxyPort: "15003", Protocol: "47", Priority: 150},
+				{ProxyPort: "15004", Protocol: "6", Priority: 100},
+			},
+			want: "endpoint=ep policies=4 protocols=47,tcp,udp priorities=100-200",
+		},
+	}
+	for _, test := range tests {
+		if got := formatSummary("ep", test.policies); got != test.want {
+			t.Errorf("%s: formatSummary = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestParseColumns(t *testing.T) {
+	tests := []struct {
+		list  string
+		names []string
+		err   string
+	}{
+		{list: "", names: []string{"PROXYPORT", "USERSID", "LOCALADDRESSES", "REMOTEADDRESSES", "LOCALPORTS", "REMOTEPORTS", "PRIORITY", "PROTOCOL", "KEY"}},
+		{list: "RemotePorts, proxyport", names: []string{"REMOTEPORTS", "PROXYPORT"}},
+		{list: "proxyport,bogus", err: `unknown column "bogus", valid columns are proxyport, usersid, localaddresses, remoteaddresses, localports, remoteports, priority, protocol, key`},
+		{list: "proxyport,", err: `unknown column ""`},
+	}
+	for _, test := range tests {
+		columns, err := parseColumns(test.list)
+		if len(test.err) > 0 {
+			if err == nil || !strings.HasPrefix(err.Error(), test.err) {
+				t.Errorf("parseColumns(%q) error %v, want %q", test.list, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseColumns(%q) error %v", test.list, err)
+			continue
+		}
+		var names []string
+		for _, column := range columns {
+			names = append(names, column.name)
+		}
+		if strings.Join(names, ",") != strings.Join(test.names, ",") {
+			t.Errorf("parseColumns(%q) = %v, want %v", test.list, names, test.names)
+		}
+	}
+}
+
+func TestWriteCSVParsesBack(t *testing.T) {
+	policies := []proxy.Policy{
+		{ProxyPort: "15001", UserSID: "S-1-5-18", LocalAddresses: "10.0.0.1,10.0.0.2", RemoteAddresses: "10.1.0.0/16,fd00::/8", LocalPorts: "8080", RemotePorts: "80-443", Priority: 100, Protocol: "6"},
+		{ProxyPort: "15002", RemoteAddresses: `"quoted", with commas`, Protocol: "17"},
+		{ProxyPort: "15003"},
+	}
+
+	for _, endpointID := range []string{"", "ep"} {
+		var b bytes.Buffer
+		if err := writeCSV(&b, endpointID, policies, nil, true); err != nil {
+			t.Fatal(err)
+		}
+		rows, err := csv.NewReader(&b).ReadAll()
+		if err != nil {
+			t.Fatalf("the CSV output does not parse: %v", err)
+		}
+
+		header := rows[0]
+		var parsed []proxy.Policy
+		for _, row := range rows[1:] {
+			fields := make(map[string]string)
+			for i, name := range header {
+				fields[name] = row[i]
+			}
+			if fields["EndpointID"] != endpointID {
+				t.Errorf("endpoint %q parsed back, want %q", fields["EndpointID"], endpointID)
+			}
+			priority, err := strconv.Atoi(fields["Priority"])
+			if err != nil {
+				t.Fatal(err)
+			}
+			parsed = append(parsed, proxy.Policy{
+				ProxyPort:       fields["ProxyPort"],
+				UserSID:         fields["UserSID"],
+				LocalAddresses:  fields["LocalAddresses"],
+				RemoteAddresses: fields["RemoteAddresses"],
+				LocalPorts:      fields["LocalPorts"],
+				RemotePorts:     fields["RemotePorts"],
+				Priority:        uint16(priority),
+				Protocol:        fields["Protocol"],
+			})
+		}
+		if !reflect.DeepEqual(parsed, policies) {
+			t.Errorf("endpoint %q: policies parsed back from CSV\n%+v\nwant\n%+v", endpointID, parsed, policies)
+		}
+	}
+}
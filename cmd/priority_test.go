@@ -0,0 +1,52 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package cmd
+
+import (
+	"reflect"
+	"testing"
+
+	proxy "github.com/microsoft/hcnproxyctrl/proxy"
+)
+
+// setPriorityBand sets --priority-band for the duration of the test.
+func setPriorityBand(t *testing.T, band string) {
+	previous := priorityBand
+	priorityBand = band
+	t.Cleanup(func() { priorityBand = previous })
+}
+
+func TestDistributePrioritiesFlag(t *testing.T) {
+	policies := []proxy.Policy{
+		{ProxyPort: "15001", RemotePorts: "80", Priority: 5},
+		{ProxyPort: "15001", RemotePorts: "443", Priority: 5},
+		{ProxyPort: "15002", RemotePorts: "8080", Priority: 5},
+	}
+
+	setPriorityBand(t, "")
+	if got, err := distributePriorities(policies); err != nil || !reflect.DeepEqual(got, policies) {
+		t.Errorf("distributePriorities without --priority-band = %+v, %v, want the policies unchanged", got, err)
+	}
+
+	setPriorityBand(t, "1000-2000")
+	got, err := distributePriorities(policies)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []uint16{1000, 1500, 2000} {
+		if got[i].Priority != want || got[i].RemotePorts != policies[i].RemotePorts {
+			t.Errorf("policy %d is %+v, want priority %d", i, got[i], want)
+		}
+	}
+
+	setPriorityBand(t, "1000-1001")
+	if _, err := distributePriorities(policies); err == nil {
+		t.Error("distributePriorities spread 3 policies across a band of 2 priorities")
+	}
+
+	setPriorityBand(t, "2000-1000")
+	if _, err := distributePriorities(policies); err == nil {
+		t.Error("distributePriorities accepted an inverted band")
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	proxy "github.com/microsoft/hcnproxyctrl/proxy"
+	"github.com/spf13/cobra"
+)
+
+// Flags for the "add" and "verify-receipt" commands
+var (
+	receiptPath    string
+	receiptKeyFile string
+)
+
+var cmdVerifyReceipt = &cobra.Command{
+	Use:   "verify-receipt <receipt file>",
+	Short: "Verify that the policy recorded in a receipt is applied",
+	Args:  cobra.ExactArgs(1),
+
+	Run: func(cmd *cobra.Command, args []string) {
+		data, err := ioutil.ReadFile(args[0])
+		if err != nil {
+			errorOut(err)
+		}
+		var receipt proxy.Receipt
+		if err := json.Unmarshal(data, &receipt); err != nil {
+			errorOut(fmt.Errorf("invalid receipt: %v", err))
+		}
+
+		key, err := readReceiptKey()
+		if err != nil {
+			errorOut(err)
+		}
+		err = callHNS(func() error {
+			return proxy.VerifyReceipt(receipt, key)
+		})
+		if err != nil {
+			errorOut(err)
+		}
+		fmt.Println("Receipt verified")
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(cmdVerifyReceipt)
+
+	cmdAdd.Flags().StringVar(&receiptPath, "receipt", "", "write a receipt of the policy applied to a single endpoint to this file")
+	cmdAdd.Flags().StringVar(&receiptKeyFile, "receipt-key-file", "", "sign the receipt with the key read from this file")
+	cmdVerifyReceipt.Flags().StringVar(&receiptKeyFile, "receipt-key-file", "", "verify the receipt signature with the key read from this file")
+}
+
+// checkReceiptTarget returns an error if --receipt is set while add applies
+// several policies or applies them to several endpoints, as a receipt records
+// a single policy applied to a single endpoint.
+func checkReceiptTarget(args []string) error {
+	if len(receiptPath) == 0 {
+		return nil
+	}
+	if addAll || len(addFile) > 0 || len(excludedPorts) > 0 || (len(args) > 0 && args[0] == "-") {
+		return errors.New("--receipt records a single policy applied to a single endpoint, and cannot be combined with --all-endpoints, --file, --exclude-remoteports or endpoints read from stdin")
+	}
+	return nil
+}
+
+// writeReceipt writes a receipt for the policy applied to the endpoint, if
+// --receipt is set.
+func writeReceipt(endpointID string, policy proxy.Policy) error {
+	if len(receiptPath) == 0 {
+		return nil
+	}
+
+	key, err := readReceiptKey()
+	if err != nil {
+		return err
+	}
+	receipt := proxy.NewReceipt(endpointID, policy, VERSION, key)
+	data, err := json.MarshalIndent(receipt, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(receiptPath, data, 0644)
+}
+
+// readReceiptKey returns the content of --receipt-key-file, or nil if it is
+// not set.
+func readReceiptKey() ([]byte, error) {
+	if len(receiptKeyFile) == 0 {
+		return nil, nil
+	}
+	return ioutil.ReadFile(receiptKeyFile)
+}
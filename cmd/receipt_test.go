@@ -0,0 +1,36 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package cmd
+
+import "testing"
+
+func TestCheckReceiptTarget(t *testing.T) {
+	oldReceiptPath, oldAddAll, oldAddFile, oldExcludedPorts := receiptPath, addAll, addFile, excludedPorts
+	defer func() {
+		receiptPath, addAll, addFile, excludedPorts = oldReceiptPath, oldAddAll, oldAddFile, oldExcludedPorts
+	}()
+
+	tests := []struct {
+		name          string
+		receiptPath   string
+		args          []string
+		addAll        bool
+		addFile       string
+		excludedPorts []string
+		valid         bool
+	}{
+		{name: "single endpoint and policy", receiptPath: "receipt.json", args: []string{"ep"}, valid: true},
+		{name: "no receipt", args: []string{"-"}, addFile: "policies.json", valid: true},
+		{name: "endpoints from stdin", receiptPath: "receipt.json", args: []string{"-"}},
+		{name: "all endpoints", receiptPath: "receipt.json", addAll: true},
+		{name: "policy file", receiptPath: "receipt.json", args: []string{"ep"}, addFile: "policies.json"},
+		{name: "excluded ports", receiptPath: "receipt.json", args: []string{"ep"}, excludedPorts: []string{"22"}},
+	}
+	for _, test := range tests {
+		receiptPath, addAll, addFile, excludedPorts = test.receiptPath, test.addAll, test.addFile, test.excludedPorts
+		if err := checkReceiptTarget(test.args); (err == nil) != test.valid {
+			t.Errorf("%s: checkReceiptTarget = %v, want valid = %v", test.name, err, test.valid)
+		}
+	}
+}
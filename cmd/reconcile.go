@@ -0,0 +1,232 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	proxy "github.com/microsoft/hcnproxyctrl/proxy"
+	"github.com/spf13/cobra"
+	"sigs.k8s.io/yaml"
+)
+
+// Flags for the "reconcile" command
+var (
+	reconcileFile          string
+	reconcileCheckOnly     bool
+	reconcileTransactional bool
+	reconcileAll           bool
+	reconcileDir           string
+	reconcileParallelism   int
+)
+
+var cmdReconcile = &cobra.Command{
+	Use:   "reconcile <HNS endpoint ID or name> | --all",
+	Short: "Make the proxy policies of an endpoint match the ones of a file",
+	Args:  cobra.MaximumNArgs(1),
+
+	Run: func(cmd *cobra.Command, args []string) {
+		if reconcileAll {
+			if len(args) > 0 || len(reconcileFile) > 0 || len(reconcileDir) == 0 {
+				errorOut(errors.New("--all requires --dir, and no endpoint or --file"))
+			}
+			if reconcileTransactional {
+				errorOut(errors.New("--transactional is not supported with --all"))
+			}
+			reconcileDirectory()
+			return
+		}
+		if len(args) == 0 || len(reconcileFile) == 0 {
+			errorOut(errors.New("an endpoint and --file must be specified, or --all and --dir"))
+		}
+
+		endpointID := resolveEndpoint(args[0])
+		desired, err := readPolicyFile(reconcileFile)
+		if err != nil {
+			errorOut(err)
+		}
+		if desired, err = distributePriorities(desired); err != nil {
+			errorOut(err)
+		}
+
+		if reconcileCheckOnly {
+			var plan proxy.ReconcilePlan
+			err := callHNS(func() (err error) {
+				plan, err = proxy.PlanReconcile(endpointID, desired)
+				return err
+			})
+			if err != nil {
+				errorOut(err)
+			}
+			if plan.InSync() {
+				fmt.Println("The policies are in sync")
+				return
+			}
+			printPlan(plan)
+			os.Exit(1)
+		}
+
+		var result proxy.ReconcileResult
+		err = callHNS(func() (err error) {
+			options := proxy.ReconcileOptions{Transactional: reconcileTransactional}
+			result, err = proxy.ReconcilePolicies(endpointID, desired, options)
+			return err
+		})
+		if err != nil {
+			errorOut(err)
+		}
+		if err := audit("reconcile", endpointID, fmt.Sprintf("added %d and removed %d policies", result.Added, result.Removed)); err != nil {
+			errorOut(err)
+		}
+		fmt.Println("Added", result.Added, "and removed", result.Removed, "policies")
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(cmdReconcile)
+
+	cmdReconcile.Flags().StringVar(&reconcileFile, "file", "", "YAML or JSON file holding the list of desired policies")
+	cmdReconcile.Flags().BoolVar(&reconcileCheckOnly, "check-only", false, "only print the changes that would be made, exiting with an error status if there are any")
+	cmdReconcile.Flags().BoolVar(&reconcileCheckOnly, "plan", false, "same as --check-only")
+	cmdReconcile.Flags().BoolVar(&reconcileAll, "all", false, "reconcile every endpoint that has a policy file in --dir")
+	cmdReconcile.Flags().StringVar(&reconcileDir, "dir", "", `directory holding a YAML or JSON policy file per endpoint, named after the endpoint ID or name (eg. "<endpoint ID>.yaml")`)
+	cmdReconcile.Flags().IntVar(&reconcileParallelism, "parallelism", 4, "how many endpoints to reconcile at once with --all")
+	cmdReconcile.Flags().BoolVar(&reconcileTransactional, "transactional", false, "restore the original policies if any change fails")
+	cmdReconcile.Flags().StringVar(&priorityBand, "priority-band", "", "spread the priorities of the desired policies of each endpoint evenly across this range (eg. 1000-2000)")
+}
+
+// readPolicyFile reads a list of policies from a YAML or JSON file.
+func readPolicyFile(path string) ([]proxy.Policy, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var policies []proxy.Policy
+	if err := yaml.Unmarshal(data, &policies); err != nil {
+		return nil, fmt.Errorf("invalid policy file %s: %v", path, err)
+	}
+	if len(policies) == 0 {
+		return nil, errors.New("the policy file has no policies")
+	}
+	return policies, nil
+}
+
+// reconcileDirectory reconciles the endpoints of the policy files of --dir,
+// or only prints the consolidated plan with --check-only.
+func reconcileDirectory() {
+	desired, err := readPolicyDir(reconcileDir)
+	if err != nil {
+		errorOut(err)
+	}
+	for endpointID, policies := range desired {
+		if desired[endpointID], err = distributePriorities(policies); err != nil {
+			errorOut(fmt.Errorf("%s: %v", endpointID, err))
+		}
+	}
+	endpointIDs := make([]string, 0, len(desired))
+	for endpointID := range desired {
+		endpointIDs = append(endpointIDs, endpointID)
+	}
+	sort.Strings(endpointIDs)
+
+	if reconcileCheckOnly {
+		numChanged, numToAdd, numToRemove := 0, 0, 0
+		for _, endpointID := range endpointIDs {
+			var plan proxy.ReconcilePlan
+			err := callHNS(func() (err error) {
+				plan, err = proxy.PlanReconcile(endpointID, desired[endpointID])
+				return err
+			})
+			if err != nil {
+				errorOut(fmt.Errorf("%s: %v", endpointID, err))
+			}
+			if plan.InSync() {
+				continue
+			}
+			fmt.Printf("%s: %d to add, %d to remove\n", endpointID, len(plan.ToAdd), len(plan.ToRemove))
+			printPlan(plan)
+			numChanged++
+			numToAdd += len(plan.ToAdd)
+			numToRemove += len(plan.ToRemove)
+		}
+		fmt.Printf("Total: %d of %d endpoints to change, %d policies to add, %d policies to remove\n",
+			numChanged, len(endpointIDs), numToAdd, numToRemove)
+		if numChanged > 0 {
+			os.Exit(1)
+		}
+		return
+	}
+
+	results := proxy.ReconcileAll(desired, reconcileParallelism)
+	var summary batchSummary
+	for _, endpointID := range endpointIDs {
+		result := results[endpointID]
+		if result.Added > 0 || result.Removed > 0 {
+			if err := audit("reconcile", endpointID, fmt.Sprintf("added %d and removed %d policies", result.Added, result.Removed)); err != nil {
+				errorOut(err)
+			}
+		}
+		if result.Err != nil {
+			summary.fail(endpointID, result.Err)
+			continue
+		}
+		fmt.Printf("%s: added %d and removed %d policies\n", endpointID, result.Added, result.Removed)
+		summary.succeeded++
+	}
+
+	summary.finish()
+}
+
+// readPolicyDir reads the policy files of a directory, keyed by the ID of the
+// endpoint designated by their name without extension. Only files with a
+// .yaml, .yml or .json extension are read.
+func readPolicyDir(dir string) (map[string][]proxy.Policy, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	desired := make(map[string][]proxy.Policy)
+	paths := make(map[string]string)
+	for _, file := range files {
+		ext := filepath.Ext(file.Name())
+		if file.IsDir() || (ext != ".yaml" && ext != ".yml" && ext != ".json") {
+			continue
+		}
+		path := filepath.Join(dir, file.Name())
+
+		endpointID := resolveEndpoint(strings.TrimSuffix(file.Name(), ext))
+		if other, ok := paths[endpointID]; ok {
+			return nil, fmt.Errorf("%s and %s are both for endpoint %s", other, path, endpointID)
+		}
+		policies, err := readPolicyFile(path)
+		if err != nil {
+			return nil, err
+		}
+		desired[endpointID] = policies
+		paths[endpointID] = path
+	}
+
+	if len(desired) == 0 {
+		return nil, fmt.Errorf("%s has no policy files", dir)
+	}
+	return desired, nil
+}
+
+// printPlan prints the policies that would be added and removed, prefixed
+// with "+" and "-" respectively.
+func printPlan(plan proxy.ReconcilePlan) {
+	for _, policy := range plan.ToRemove {
+		fmt.Printf("- %+v\n", policy)
+	}
+	for _, policy := range plan.ToAdd {
+		fmt.Printf("+ %+v\n", policy)
+	}
+}
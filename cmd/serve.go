@@ -0,0 +1,276 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/Microsoft/hcsshim/hcn"
+	cri "github.com/microsoft/hcnproxyctrl/cri"
+	proxy "github.com/microsoft/hcnproxyctrl/proxy"
+	"github.com/spf13/cobra"
+)
+
+// Flags for the "serve" command
+var (
+	serveHTTP           string
+	serveRequestTimeout time.Duration
+)
+
+// maxRequestBodySize bounds the size of the policies posted to the server.
+const maxRequestBodySize = 1 << 20
+
+var cmdServe = &cobra.Command{
+	Use:   "serve --http <address>",
+	Short: "Serve a REST API to manage the proxy policies",
+	Long: `Serve a REST API to manage the proxy policies.
+
+The API has the following routes, all returning JSON:
+
+  GET    /endpoints/{id}/policies  list the proxy policies of an endpoint
+  POST   /endpoints/{id}/policies  add the policy of the request body to an endpoint
+  DELETE /endpoints/{id}/policies  remove all the proxy policies of an endpoint
+  GET    /containers/{id}/endpoint look up the endpoints of a container
+
+Errors are returned as {"error": "..."} with a matching status code. The
+server does not authenticate its clients, so it should only listen on a
+loopback address. It runs until it is interrupted, letting the requests in
+progress complete.`,
+	Example: `  hcnproxyctrl.exe serve --http 127.0.0.1:8080`,
+	Args:    cobra.NoArgs,
+
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(serveHTTP) == 0 {
+			errorOut(errors.New("--http must be specified"))
+		}
+		if serveRequestTimeout <= 0 {
+			errorOut(fmt.Errorf("invalid request timeout: %v", serveRequestTimeout))
+		}
+		// The policy routes do not need CRI, so do not refuse to serve them
+		// if no runtime can be found.
+		if err := configureCRI(cmd); err != nil {
+			fmt.Fprintln(os.Stderr, "warning: container lookups will fail:", err)
+		}
+
+		server := &http.Server{
+			Addr:              serveHTTP,
+			Handler:           http.TimeoutHandler(newAPIHandler(), serveRequestTimeout, `{"error":"request timed out"}`),
+			ReadHeaderTimeout: serveRequestTimeout,
+		}
+
+		interrupted := make(chan os.Signal, 1)
+		signal.Notify(interrupted, os.Interrupt, syscall.SIGTERM)
+		shutdown := make(chan error, 1)
+		go func() {
+			<-interrupted
+			ctx, cancel := context.WithTimeout(context.Background(), serveRequestTimeout)
+			defer cancel()
+			shutdown <- server.Shutdown(ctx)
+		}()
+
+		fmt.Println("Serving on", serveHTTP)
+		if err := server.ListenAndServe(); err != http.ErrServerClosed {
+			errorOut(err)
+		}
+		if err := <-shutdown; err != nil {
+			errorOut(err)
+		}
+	},
+}
+
+// newAPIHandler returns the handler of the routes of the REST API.
+func newAPIHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/endpoints/", func(w http.ResponseWriter, r *http.Request) {
+		endpointID, ok := routeID(r.URL.Path, "/endpoints/", "/policies")
+		if !ok {
+			writeAPIError(w, http.StatusNotFound, errors.New("not found"))
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			listEndpointPolicies(w, r, endpointID)
+		case http.MethodPost:
+			addEndpointPolicy(w, r, endpointID)
+		case http.MethodDelete:
+			clearEndpointPolicies(w, r, endpointID)
+		default:
+			w.Header().Set("Allow", "GET, POST, DELETE")
+			writeAPIError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		}
+	})
+	mux.HandleFunc("/containers/", func(w http.ResponseWriter, r *http.Request) {
+		containerID, ok := routeID(r.URL.Path, "/containers/", "/endpoint")
+		if !ok {
+			writeAPIError(w, http.StatusNotFound, errors.New("not found"))
+			return
+		}
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", "GET")
+			writeAPIError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+			return
+		}
+		lookupContainerEndpoint(w, r, containerID)
+	})
+	return mux
+}
+
+// routeID returns the ID in a path of the form <prefix><id><suffix>.
+func routeID(path, prefix, suffix string) (string, bool) {
+	if !strings.HasPrefix(path, prefix) {
+		return "", false
+	}
+	id := strings.TrimPrefix(path, prefix)
+	if !strings.HasSuffix(id, suffix) {
+		return "", false
+	}
+	id = strings.TrimSuffix(id, suffix)
+	if len(id) == 0 || strings.Contains(id, "/") {
+		return "", false
+	}
+	return id, true
+}
+
+func listEndpointPolicies(w http.ResponseWriter, r *http.Request, endpointID string) {
+	var policies []proxy.Policy
+	err := callHNSContext(r.Context(), func(ctx context.Context) (err error) {
+		policies, err = listPoliciesContext(ctx, endpointID)
+		return err
+	})
+	if err != nil {
+		writeAPIError(w, hnsErrorStatus(err), err)
+		return
+	}
+	if policies == nil {
+		policies = []proxy.Policy{}
+	}
+	writeAPIResponse(w, http.StatusOK, policies)
+}
+
+func addEndpointPolicy(w http.ResponseWriter, r *http.Request, endpointID string) {
+	var policy proxy.Policy
+	decoder := json.NewDecoder(io.LimitReader(r.Body, maxRequestBodySize))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&policy); err != nil {
+		writeAPIError(w, http.StatusBadRequest, fmt.Errorf("invalid policy: %v", err))
+		return
+	}
+	if _, err := proxy.RenderPolicy(policy); err != nil {
+		writeAPIError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	err := callHNSContext(r.Context(), func(ctx context.Context) error {
+		return addPolicyContext(ctx, endpointID, policy)
+	})
+	if err != nil {
+		writeAPIError(w, hnsErrorStatus(err), err)
+		return
+	}
+	if err := audit("add", endpointID, fmt.Sprintf("%+v", policy)); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeAPIResponse(w, http.StatusCreated, policy)
+}
+
+func clearEndpointPolicies(w http.ResponseWriter, r *http.Request, endpointID string) {
+	var numRemoved int
+	err := callHNSContext(r.Context(), func(ctx context.Context) (err error) {
+		numRemoved, err = clearPoliciesContext(ctx, endpointID)
+		return err
+	})
+	if err != nil {
+		writeAPIError(w, hnsErrorStatus(err), err)
+		return
+	}
+	if err := audit("clear", endpointID, fmt.Sprintf("removed %d policies", numRemoved)); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeAPIResponse(w, http.StatusOK, map[string]int{"removed": numRemoved})
+}
+
+func lookupContainerEndpoint(w http.ResponseWriter, r *http.Request, containerID string) {
+	var result *proxy.LookupResult
+	err := callHNSContext(r.Context(), func(ctx context.Context) (err error) {
+		result, err = getContainerEndpointInfoContext(ctx, containerID, runtimeEndpoint)
+		return err
+	})
+	if err != nil {
+		writeAPIError(w, lookupErrorStatus(err), err)
+		return
+	}
+	writeAPIResponse(w, http.StatusOK, result)
+}
+
+// lookupErrorStatus returns the status code to report a failed container
+// lookup with: not found only if the container is not found or not attached
+// to any endpoint, and unavailable if the runtime is unreachable.
+func lookupErrorStatus(err error) int {
+	var connectErr *cri.ConnectError
+	switch {
+	case errors.Is(err, proxy.ErrContainerNotFound), errors.Is(err, proxy.ErrEndpointNotAttached):
+		return http.StatusNotFound
+	case errors.As(err, &connectErr):
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+// hnsErrorStatus returns the status code to report an HNS failure with.
+func hnsErrorStatus(err error) int {
+	if hcn.IsNotFoundError(err) {
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
+
+func writeAPIResponse(w http.ResponseWriter, status int, v interface{}) {
+	out, err := marshalJSON(v)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(append(out, '\n'))
+}
+
+// writeAPIError writes an error as {"error": "..."}, along with the list of
+// problems of invalid policies.
+func writeAPIError(w http.ResponseWriter, status int, err error) {
+	output := struct {
+		Error    string   `json:"error"`
+		Problems []string `json:"problems,omitempty"`
+	}{Error: err.Error()}
+	if validationErr, ok := err.(*proxy.ValidationError); ok {
+		for _, problem := range validationErr.Problems {
+			output.Problems = append(output.Problems, problem.Error())
+		}
+	}
+	out, _ := json.Marshal(output)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(append(out, '\n'))
+}
+
+func init() {
+	rootCmd.AddCommand(cmdServe)
+
+	cmdServe.Flags().StringVar(&serveHTTP, "http", "", `address to serve the REST API on, eg. "127.0.0.1:8080"`)
+	cmdServe.Flags().DurationVar(&serveRequestTimeout, "request-timeout", 30*time.Second, "give up on requests taking longer than this duration, and on the requests in progress when shutting down")
+	cmdServe.Flags().StringVar(&runtimeEndpoint, "runtimeendpoint", "", "CRI RuntimeEndpoint to look up containers through (if neither this, CONTAINER_RUNTIME_ENDPOINT nor the crictl config sets one, the well-known containerd and Docker endpoints are probed)")
+	cmdServe.Flags().StringVar(&crictlConfig, "crictl-config", cri.DefaultCrictlConfigPath(), "crictl config file from which to read the runtime endpoint and timeout, when neither --runtimeendpoint nor CONTAINER_RUNTIME_ENDPOINT is set")
+}
@@ -0,0 +1,320 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Microsoft/hcsshim/hcn"
+	cri "github.com/microsoft/hcnproxyctrl/cri"
+	proxy "github.com/microsoft/hcnproxyctrl/proxy"
+)
+
+// fakeAPI replaces the HNS and CRI calls of the routes of serve. The endpoint
+// "broken" and the container "broken" fail, the container "unreachable"
+// cannot be looked up because the runtime is not reachable, and the endpoints
+// and containers not in the given maps are not found.
+func fakeAPI(t *testing.T, policies map[string][]proxy.Policy, containers map[string]*proxy.LookupResult) {
+	endpointErr := func(endpointID string) error {
+		if endpointID == "broken" {
+			return errors.New("HNS failure")
+		}
+		if _, ok := policies[endpointID]; !ok {
+			return hcn.EndpointNotFoundError{EndpointID: endpointID}
+		}
+		return nil
+	}
+
+	previousList, previousAdd := listPoliciesContext, addPolicyContext
+	previousClear, previousLookup := clearPoliciesContext, getContainerEndpointInfoContext
+	listPoliciesContext = func(ctx context.Context, endpointID string) ([]proxy.Policy, error) {
+		if err := endpointErr(endpointID); err != nil {
+			return nil, err
+		}
+		return policies[endpointID], nil
+	}
+	addPolicyContext = func(ctx context.Context, endpointID string, policy proxy.Policy) error {
+		if err := endpointErr(endpointID); err != nil {
+			return err
+		}
+		policies[endpointID] = append(policies[endpointID], policy)
+		return nil
+	}
+	clearPoliciesContext = func(ctx context.Context, endpointID string) (int, error) {
+		if err := endpointErr(endpointID); err != nil {
+			return 0, err
+		}
+		numRemoved := len(policies[endpointID])
+		policies[endpointID] = nil
+		return numRemoved, nil
+	}
+	getContainerEndpointInfoContext = func(ctx context.Context, containerID string, runtimeEndpoint string) (*proxy.LookupResult, error) {
+		switch containerID {
+		case "broken":
+			return nil, errors.New("CRI failure")
+		case "unreachable":
+			return nil, &cri.ConnectError{Err: errors.New("connection refused")}
+		}
+		result, ok := containers[containerID]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", proxy.ErrContainerNotFound, containerID)
+		}
+		if len(result.EndpointIDs) == 0 {
+			return nil, fmt.Errorf("%w: %s", proxy.ErrEndpointNotAttached, containerID)
+		}
+		return result, nil
+	}
+	t.Cleanup(func() {
+		listPoliciesContext, addPolicyContext = previousList, previousAdd
+		clearPoliciesContext, getContainerEndpointInfoContext = previousClear, previousLookup
+	})
+}
+
+func TestAPIHandler(t *testing.T) {
+	setAuditLog(t)
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		body     string
+		status   int
+		allow    string
+		contains []string
+		policies map[string][]proxy.Policy
+	}{
+		{
+			name:     "list policies",
+			method:   http.MethodGet,
+			path:     "/endpoints/ep/policies",
+			status:   http.StatusOK,
+			contains: []string{`"proxyPort": "8000"`, `"proxyPort": "9000"`},
+			policies: map[string][]proxy.Policy{"ep": {{ProxyPort: "8000"}, {ProxyPort: "9000"}}},
+		},
+		{
+			name:     "list no policies",
+			method:   http.MethodGet,
+			path:     "/endpoints/empty/policies",
+			status:   http.StatusOK,
+			contains: []string{"[]"},
+			policies: map[string][]proxy.Policy{"empty": nil},
+		},
+		{
+			name:     "list policies of an unknown endpoint",
+			method:   http.MethodGet,
+			path:     "/endpoints/unknown/policies",
+			status:   http.StatusNotFound,
+			contains: []string{`"error"`},
+		},
+		{
+			name:     "list policies failing",
+			method:   http.MethodGet,
+			path:     "/endpoints/broken/policies",
+			status:   http.StatusInternalServerError,
+			contains: []string{"HNS failure"},
+		},
+		{
+			name:     "add policy",
+			method:   http.MethodPost,
+			path:     "/endpoints/ep/policies",
+			body:     `{"proxyPort": "8000", "remotePorts": "80"}`,
+			status:   http.StatusCreated,
+			contains: []string{`"proxyPort": "8000"`},
+			policies: map[string][]proxy.Policy{"ep": {{ProxyPort: "8000", RemotePorts: "80"}}},
+		},
+		{
+			name:     "add policy with malformed body",
+			method:   http.MethodPost,
+			path:     "/endpoints/ep/policies",
+			body:     `{"proxyPort": `,
+			status:   http.StatusBadRequest,
+			contains: []string{"invalid policy"},
+			policies: map[string][]proxy.Policy{"ep": nil},
+		},
+		{
+			name:     "add policy with unknown field",
+			method:   http.MethodPost,
+			path:     "/endpoints/ep/policies",
+			body:     `{"proxyPort": "8000", "port": "80"}`,
+			status:   http.StatusBadRequest,
+			contains: []string{"invalid policy", "port"},
+			policies: map[string][]proxy.Policy{"ep": nil},
+		},
+		{
+			name:     "add invalid policy",
+			method:   http.MethodPost,
+			path:     "/endpoints/ep/policies",
+			body:     `{"proxyPort": "8000", "remotePorts": "90-80"}`,
+			status:   http.StatusBadRequest,
+			contains: []string{`"problems"`, "90-80"},
+			policies: map[string][]proxy.Policy{"ep": nil},
+		},
+		{
+			name:     "add policy to an unknown endpoint",
+			method:   http.MethodPost,
+			path:     "/endpoints/unknown/policies",
+			body:     `{"proxyPort": "8000"}`,
+			status:   http.StatusNotFound,
+			contains: []string{`"error"`},
+		},
+		{
+			name:     "add policy failing",
+			method:   http.MethodPost,
+			path:     "/endpoints/broken/policies",
+			body:     `{"proxyPort": "8000"}`,
+			status:   http.StatusInternalServerError,
+			contains: []string{"HNS failure"},
+		},
+		{
+			name:     "clear policies",
+			method:   http.MethodDelete,
+			path:     "/endpoints/ep/policies",
+			status:   http.StatusOK,
+			contains: []string{`"removed": 2`},
+			policies: map[string][]proxy.Policy{"ep": nil},
+		},
+		{
+			name:     "clear policies of an unknown endpoint",
+			method:   http.MethodDelete,
+			path:     "/endpoints/unknown/policies",
+			status:   http.StatusNotFound,
+			contains: []string{`"error"`},
+		},
+		{
+			name:     "clear policies failing",
+			method:   http.MethodDelete,
+			path:     "/endpoints/broken/policies",
+			status:   http.StatusInternalServerError,
+			contains: []string{"HNS failure"},
+		},
+		{
+			name:     "method not allowed on endpoints",
+			method:   http.MethodPut,
+			path:     "/endpoints/ep/policies",
+			status:   http.StatusMethodNotAllowed,
+			allow:    "GET, POST, DELETE",
+			contains: []string{"method PUT not allowed"},
+		},
+		{
+			name:     "endpoint without policies suffix",
+			method:   http.MethodGet,
+			path:     "/endpoints/ep",
+			status:   http.StatusNotFound,
+			contains: []string{`{"error":"not found"}`},
+		},
+		{
+			name:     "nested endpoint path",
+			method:   http.MethodGet,
+			path:     "/endpoints/ep/other/policies",
+			status:   http.StatusNotFound,
+			contains: []string{`{"error":"not found"}`},
+		},
+		{
+			name:     "missing endpoint ID",
+			method:   http.MethodGet,
+			path:     "/endpoints/policies",
+			status:   http.StatusNotFound,
+			contains: []string{`{"error":"not found"}`},
+		},
+		{
+			name:   "unknown route",
+			method: http.MethodGet,
+			path:   "/policies",
+			status: http.StatusNotFound,
+		},
+		{
+			name:     "lookup container",
+			method:   http.MethodGet,
+			path:     "/containers/c1/endpoint",
+			status:   http.StatusOK,
+			contains: []string{`"containerID": "c1"`, `"ep"`},
+		},
+		{
+			name:     "lookup unknown container",
+			method:   http.MethodGet,
+			path:     "/containers/unknown/endpoint",
+			status:   http.StatusNotFound,
+			contains: []string{"could not find the container"},
+		},
+		{
+			name:     "lookup container not attached",
+			method:   http.MethodGet,
+			path:     "/containers/detached/endpoint",
+			status:   http.StatusNotFound,
+			contains: []string{"could not find an endpoint"},
+		},
+		{
+			name:     "lookup container with runtime unreachable",
+			method:   http.MethodGet,
+			path:     "/containers/unreachable/endpoint",
+			status:   http.StatusServiceUnavailable,
+			contains: []string{"failed to connect"},
+		},
+		{
+			name:     "lookup container failing",
+			method:   http.MethodGet,
+			path:     "/containers/broken/endpoint",
+			status:   http.StatusInternalServerError,
+			contains: []string{"CRI failure"},
+		},
+		{
+			name:     "method not allowed on containers",
+			method:   http.MethodPost,
+			path:     "/containers/c1/endpoint",
+			status:   http.StatusMethodNotAllowed,
+			allow:    "GET",
+			contains: []string{"method POST not allowed"},
+		},
+		{
+			name:     "container without endpoint suffix",
+			method:   http.MethodGet,
+			path:     "/containers/c1",
+			status:   http.StatusNotFound,
+			contains: []string{`{"error":"not found"}`},
+		},
+	}
+
+	for _, test := range tests {
+		policies := map[string][]proxy.Policy{
+			"ep":    {{ProxyPort: "8000"}, {ProxyPort: "9000"}},
+			"empty": nil,
+		}
+		if test.method == http.MethodPost {
+			policies["ep"] = nil
+		}
+		fakeAPI(t, policies, map[string]*proxy.LookupResult{
+			"c1":       {ContainerID: "c1", NamespaceID: "ns1", EndpointIDs: []string{"ep"}},
+			"detached": {ContainerID: "detached", NamespaceID: "ns2"},
+		})
+
+		request := httptest.NewRequest(test.method, test.path, strings.NewReader(test.body))
+		recorder := httptest.NewRecorder()
+		newAPIHandler().ServeHTTP(recorder, request)
+
+		if recorder.Code != test.status {
+			t.Errorf("%s: got status %d, expected %d (body %q)", test.name, recorder.Code, test.status, recorder.Body.String())
+		}
+		if allow := recorder.Header().Get("Allow"); allow != test.allow {
+			t.Errorf("%s: got Allow header %q, expected %q", test.name, allow, test.allow)
+		}
+		for _, expected := range test.contains {
+			if !strings.Contains(recorder.Body.String(), expected) {
+				t.Errorf("%s: body %q does not contain %q", test.name, recorder.Body.String(), expected)
+			}
+		}
+		if test.policies != nil {
+			for endpointID, expected := range test.policies {
+				if !reflect.DeepEqual(policies[endpointID], expected) {
+					t.Errorf("%s: got policies %+v on %s, expected %+v", test.name, policies[endpointID], endpointID, expected)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,65 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// failOnSkip makes batch commands exit with an error status when endpoints
+// were skipped, and not only when some failed.
+var failOnSkip bool
+
+// batchSummary counts the outcomes of a command operating on many endpoints.
+type batchSummary struct {
+	succeeded int
+	failed    int
+	skipped   int
+
+	// Where finish prints the summary, stdout if nil, eg. stderr to keep
+	// the output parseable.
+	out io.Writer
+}
+
+// fail records that the operation failed on the endpoint, and reports the
+// error.
+func (s *batchSummary) fail(endpoint string, err error) {
+	fmt.Fprintf(os.Stderr, "%s: %v\n", endpoint, err)
+	s.failed++
+}
+
+// skip records that the endpoint was skipped, and reports why.
+func (s *batchSummary) skip(endpoint string, reason string) {
+	fmt.Printf("%s: skipped, %s\n", endpoint, reason)
+	s.skipped++
+}
+
+func (s batchSummary) String() string {
+	return fmt.Sprintf("Succeeded: %d, Failed: %d, Skipped: %d", s.succeeded, s.failed, s.skipped)
+}
+
+// exitCode returns the exit status of the command: 0 only if no endpoint
+// failed, or with --fail-on-skip, none was skipped either.
+func (s batchSummary) exitCode(failOnSkip bool) int {
+	if s.failed > 0 || (failOnSkip && s.skipped > 0) {
+		return 1
+	}
+	return 0
+}
+
+// finish prints the summary and exits with an error status if the batch
+// failed.
+func (s batchSummary) finish() {
+	out := s.out
+	if out == nil {
+		out = os.Stdout
+	}
+	fmt.Fprintln(out, s)
+	waitWebhooks()
+	if code := s.exitCode(failOnSkip); code != 0 {
+		os.Exit(code)
+	}
+}
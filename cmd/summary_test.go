@@ -0,0 +1,47 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package cmd
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestBatchSummaryExitCode(t *testing.T) {
+	tests := []struct {
+		name       string
+		summary    batchSummary
+		failOnSkip bool
+		code       int
+	}{
+		{name: "success", summary: batchSummary{succeeded: 3}, code: 0},
+		{name: "nothing to do", summary: batchSummary{}, code: 0},
+		{name: "failure", summary: batchSummary{succeeded: 2, failed: 1}, code: 1},
+		{name: "failure only", summary: batchSummary{failed: 1}, code: 1},
+		{name: "skip", summary: batchSummary{succeeded: 2, skipped: 1}, code: 0},
+		{name: "skip with --fail-on-skip", summary: batchSummary{succeeded: 2, skipped: 1}, failOnSkip: true, code: 1},
+		{name: "success with --fail-on-skip", summary: batchSummary{succeeded: 2}, failOnSkip: true, code: 0},
+		{name: "failure and skip", summary: batchSummary{failed: 1, skipped: 1}, code: 1},
+	}
+	for _, test := range tests {
+		if code := test.summary.exitCode(test.failOnSkip); code != test.code {
+			t.Errorf("%s: exitCode(%v) = %d, want %d", test.name, test.failOnSkip, code, test.code)
+		}
+	}
+}
+
+func TestBatchSummaryCounts(t *testing.T) {
+	var summary batchSummary
+	summary.succeeded++
+	summary.fail("ep1", errors.New("HNS failure"))
+	summary.skip("ep2", "the endpoint does not support proxy policies")
+	summary.skip("ep3", "the endpoint does not support proxy policies")
+
+	if want := "Succeeded: 1, Failed: 1, Skipped: 2"; summary.String() != want {
+		t.Errorf("summary %q, want %q", summary, want)
+	}
+	if code := summary.exitCode(false); code != 1 {
+		t.Errorf("exitCode = %d after a failure, want 1", code)
+	}
+}
@@ -0,0 +1,154 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package cmd
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	proxy "github.com/microsoft/hcnproxyctrl/proxy"
+	"github.com/spf13/cobra"
+)
+
+// Flags for the "test-matrix" command
+var (
+	testMatrixFile string
+)
+
+var cmdTestMatrix = &cobra.Command{
+	Use:   "test-matrix <HNS endpoint ID or name>",
+	Short: "Report which proxy policy of an endpoint would intercept each connection of a file",
+	Args:  cobra.ExactArgs(1),
+
+	Run: func(cmd *cobra.Command, args []string) {
+		endpointID := resolveEndpoint(args[0])
+		tupleRanges, err := readTuples(testMatrixFile)
+		if err != nil {
+			errorOut(err)
+		}
+
+		var policies []proxy.Policy
+		err = callHNS(func() (err error) {
+			policies, err = proxy.ListPolicies(endpointID)
+			return err
+		})
+		if err != nil {
+			errorOut(err)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "PROTOCOL\tLOCAL\tREMOTE\tPROXYPORT\tPOLICY")
+		for _, tupleRange := range tupleRanges {
+			if !isRange(tupleRange) {
+				tuple := proxy.Tuple{
+					Protocol:      tupleRange.Protocol,
+					LocalAddress:  tupleRange.LocalAddresses,
+					LocalPort:     tupleRange.LocalPorts,
+					RemoteAddress: tupleRange.RemoteAddresses,
+					RemotePort:    tupleRange.RemotePorts,
+					UserSID:       tupleRange.UserSID,
+				}
+				policy, ok := proxy.MatchingPolicy(policies, tuple)
+				printInterception(w, tupleRange.Protocol, proxy.Interception{
+					Policy:          policy,
+					Intercepted:     ok,
+					LocalAddresses:  tuple.LocalAddress,
+					LocalPorts:      tuple.LocalPort,
+					RemoteAddresses: tuple.RemoteAddress,
+					RemotePorts:     tuple.RemotePort,
+				})
+				continue
+			}
+
+			interceptions, err := proxy.Simulate(policies, tupleRange)
+			if err != nil {
+				errorOut(err)
+			}
+			for _, interception := range interceptions {
+				printInterception(w, tupleRange.Protocol, interception)
+			}
+		}
+		w.Flush()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(cmdTestMatrix)
+
+	cmdTestMatrix.Flags().StringVar(&testMatrixFile, "file", "", "CSV file of connections, one per line: protocol,localaddr,localport,remoteaddr,remoteport[,usersid], where addresses can be IPv4 CIDRs and ports can be ranges, or empty for all of them, to report which parts of them each policy intercepts")
+	cmdTestMatrix.MarkFlagRequired("file")
+}
+
+// printInterception prints a row of the test-matrix table.
+func printInterception(w io.Writer, protocol string, interception proxy.Interception) {
+	proxyPort, key := "-", "none"
+	if interception.Intercepted {
+		proxyPort, key = interception.Policy.ProxyPort, interception.Policy.Key()
+	}
+	fmt.Fprintf(w, "%s\t%s:%s\t%s:%s\t%s\t%s\n", protocol, interception.LocalAddresses, interception.LocalPorts,
+		interception.RemoteAddresses, interception.RemotePorts, proxyPort, key)
+}
+
+// isRange returns true if the addresses or ports of the connections are
+// ranges rather than single values. Empty addresses and ports stand for all of
+// them.
+func isRange(tupleRange proxy.TupleRange) bool {
+	for _, addresses := range []string{tupleRange.LocalAddresses, tupleRange.RemoteAddresses} {
+		if len(addresses) == 0 || strings.Contains(addresses, "/") {
+			return true
+		}
+	}
+	for _, ports := range []string{tupleRange.LocalPorts, tupleRange.RemotePorts} {
+		if len(ports) == 0 || strings.Contains(ports, "-") {
+			return true
+		}
+	}
+	return false
+}
+
+// readTuples reads connections, or ranges of connections, from a CSV file.
+// Lines starting with "#" are ignored. Protocols can be given by name or
+// number, as accepted by proxy.ParseProtocol.
+func readTuples(path string) ([]proxy.TupleRange, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	reader := csv.NewReader(f)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	var tuples []proxy.TupleRange
+	for i, record := range records {
+		if len(record) != 5 && len(record) != 6 {
+			return nil, fmt.Errorf("%s: connection %d: expected 5 or 6 fields, got %d", path, i+1, len(record))
+		}
+		tuple := proxy.TupleRange{
+			Protocol:        record[0],
+			LocalAddresses:  record[1],
+			LocalPorts:      record[2],
+			RemoteAddresses: record[3],
+			RemotePorts:     record[4],
+		}
+		if tuple.Protocol, err = proxy.ParseProtocol(record[0]); err != nil {
+			return nil, fmt.Errorf("%s: connection %d: %v", path, i+1, err)
+		}
+		if len(record) == 6 {
+			tuple.UserSID = record[5]
+		}
+		tuples = append(tuples, tuple)
+	}
+	return tuples, nil
+}
@@ -0,0 +1,68 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package cmd
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	proxy "github.com/microsoft/hcnproxyctrl/proxy"
+)
+
+func TestReadTuples(t *testing.T) {
+	path := writeFile(t, "tuples.csv", `# protocol,localaddr,localport,remoteaddr,remoteport,usersid
+tcp,10.0.0.2,8080,10.1.0.1,80
+UDP, 10.0.0.2, 5353, 10.1.0.1, 53, S-1-5-18
+17,,,,
+47,10.0.0.0/24,1-1024,,
+`)
+	tuples, err := readTuples(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []proxy.TupleRange{
+		{Protocol: "6", LocalAddresses: "10.0.0.2", LocalPorts: "8080", RemoteAddresses: "10.1.0.1", RemotePorts: "80"},
+		{Protocol: "17", LocalAddresses: "10.0.0.2", LocalPorts: "5353", RemoteAddresses: "10.1.0.1", RemotePorts: "53", UserSID: "S-1-5-18"},
+		{Protocol: "17"},
+		{Protocol: "47", LocalAddresses: "10.0.0.0/24", LocalPorts: "1-1024"},
+	}
+	if !reflect.DeepEqual(tuples, want) {
+		t.Errorf("readTuples =\n%+v\nwant\n%+v", tuples, want)
+	}
+
+	for _, test := range []struct {
+		content string
+		err     string
+	}{
+		{content: "sctp,10.0.0.2,8080,10.1.0.1,80\n", err: `connection 1: unknown protocol "sctp"`},
+		{content: "tcp,10.0.0.2,8080,10.1.0.1,80\n256,,,,\n", err: "connection 2: invalid protocol number 256"},
+		{content: "tcp,10.0.0.2,8080\n", err: "connection 1: expected 5 or 6 fields, got 3"},
+	} {
+		if _, err := readTuples(writeFile(t, "tuples.csv", test.content)); err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("readTuples(%q) error %v, want %q", test.content, err, test.err)
+		}
+	}
+}
+
+func TestIsRange(t *testing.T) {
+	single := proxy.TupleRange{Protocol: "6", LocalAddresses: "10.0.0.2", LocalPorts: "8080", RemoteAddresses: "10.1.0.1", RemotePorts: "80"}
+	if isRange(single) {
+		t.Errorf("isRange(%+v) = true, want a single connection", single)
+	}
+
+	ranges := []func(*proxy.TupleRange){
+		func(r *proxy.TupleRange) { r.LocalAddresses = "10.0.0.0/24" },
+		func(r *proxy.TupleRange) { r.RemoteAddresses = "" },
+		func(r *proxy.TupleRange) { r.LocalPorts = "" },
+		func(r *proxy.TupleRange) { r.RemotePorts = "80-443" },
+	}
+	for _, makeRange := range ranges {
+		tupleRange := single
+		makeRange(&tupleRange)
+		if !isRange(tupleRange) {
+			t.Errorf("isRange(%+v) = false, want a range", tupleRange)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	proxy "github.com/microsoft/hcnproxyctrl/proxy"
+	"sigs.k8s.io/yaml"
+)
+
+// policyTemplate is the document read by add --file, once merged with the
+// --values files. Each of the policies is merged over the defaults, so that
+// they only need to specify what sets them apart, eg.
+//
+//      defaults:
+//        proxyPort: "15001"
+//        userSID: system
+//      policies:
+//        - remotePorts: "80"
+//        - remotePorts: "443"
+//          priority: 10
+//
+// The fields are named as in the JSON form of proxy.Policy, but are matched
+// case-insensitively, as keys are when merging (see canonicalKeys).
+type policyTemplate struct {
+	Defaults map[string]interface{}   `json:"defaults"`
+	Policies []map[string]interface{} `json:"policies"`
+}
+
+// readPolicyTemplate reads the policies of a template file, after merging the
+// values files over it in order (see mergeValues).
+func readPolicyTemplate(path string, valuesPaths []string) ([]proxy.Policy, error) {
+	document, err := readValuesFile(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, valuesPath := range valuesPaths {
+		values, err := readValuesFile(valuesPath)
+		if err != nil {
+			return nil, err
+		}
+		document = mergeValues(document, values)
+	}
+
+	// Round-trip through JSON to decode the merged document
+	data, err := json.Marshal(document)
+	if err != nil {
+		return nil, err
+	}
+	var template policyTemplate
+	if err := json.Unmarshal(data, &template); err != nil {
+		return nil, fmt.Errorf("invalid policy template %s: %v", path, err)
+	}
+	if len(template.Policies) == 0 {
+		return nil, errors.New("the policy template has no policies")
+	}
+
+	policies := make([]proxy.Policy, len(template.Policies))
+	for i, fields := range template.Policies {
+		data, err := json.Marshal(mergeValues(template.Defaults, fields))
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, &policies[i]); err != nil {
+			return nil, fmt.Errorf("invalid policy %d of template %s: %v", i+1, path, err)
+		}
+	}
+	return policies, nil
+}
+
+// readValuesFile reads a YAML or JSON document whose top level is a map.
+func readValuesFile(path string) (map[string]interface{}, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var values map[string]interface{}
+	if err := yaml.Unmarshal(data, &values); err != nil {
+		return nil, fmt.Errorf("invalid values file %s: %v", path, err)
+	}
+	canonical, err := canonicalKeys(values)
+	if err != nil {
+		return nil, fmt.Errorf("invalid values file %s: %v", path, err)
+	}
+	return canonical, nil
+}
+
+// canonicalKeys returns the values with the keys of their maps lowercased,
+// recursively, so that keys differing only in case, eg. "ProxyPort" and
+// "proxyPort", override each other when merging, as they designate the same
+// field when decoding. An error is returned if a map has several such keys.
+func canonicalKeys(values map[string]interface{}) (map[string]interface{}, error) {
+	canonical := make(map[string]interface{}, len(values))
+	for key, value := range values {
+		canonicalKey := strings.ToLower(key)
+		if _, ok := canonical[canonicalKey]; ok {
+			return nil, fmt.Errorf("duplicate key %q", key)
+		}
+		canonicalValue, err := canonicalValue(value)
+		if err != nil {
+			return nil, err
+		}
+		canonical[canonicalKey] = canonicalValue
+	}
+	return canonical, nil
+}
+
+// canonicalValue canonicalizes the keys of the maps of a value, see
+// canonicalKeys.
+func canonicalValue(value interface{}) (interface{}, error) {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		return canonicalKeys(value)
+	case []interface{}:
+		canonical := make([]interface{}, len(value))
+		for i, element := range value {
+			var err error
+			if canonical[i], err = canonicalValue(element); err != nil {
+				return nil, err
+			}
+		}
+		return canonical, nil
+	}
+	return value, nil
+}
+
+// mergeValues returns the values of dst overridden by the ones of src, with
+// the semantics of Helm: maps are merged recursively, any other value of src,
+// lists included, replaces the one of dst, and a null value in src removes the
+// key. Keys are compared as is, so they are expected to be canonical (see
+// canonicalKeys). Neither dst nor src is modified.
+func mergeValues(dst, src map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{}, len(dst)+len(src))
+	for key, value := range dst {
+		merged[key] = value
+	}
+
+	for key, value := range src {
+		if value == nil {
+			delete(merged, key)
+			continue
+		}
+		srcMap, srcIsMap := value.(map[string]interface{})
+		dstMap, dstIsMap := merged[key].(map[string]interface{})
+		if srcIsMap && dstIsMap {
+			merged[key] = mergeValues(dstMap, srcMap)
+			continue
+		}
+		merged[key] = value
+	}
+	return merged
+}
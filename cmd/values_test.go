@@ -0,0 +1,100 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package cmd
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	proxy "github.com/microsoft/hcnproxyctrl/proxy"
+)
+
+// writeFile writes a file in a temporary directory and returns its path.
+func writeFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestMergeValues(t *testing.T) {
+	dst := map[string]interface{}{
+		"defaults": map[string]interface{}{"proxyport": "15001", "usersid": "system"},
+		"policies": []interface{}{"a", "b"},
+		"kept":     "dst",
+	}
+	src := map[string]interface{}{
+		"defaults": map[string]interface{}{"proxyport": "16001", "usersid": nil},
+		"policies": []interface{}{"c"},
+	}
+	want := map[string]interface{}{
+		"defaults": map[string]interface{}{"proxyport": "16001"},
+		"policies": []interface{}{"c"},
+		"kept":     "dst",
+	}
+	if merged := mergeValues(dst, src); !reflect.DeepEqual(merged, want) {
+		t.Errorf("mergeValues = %v, want %v", merged, want)
+	}
+	if dst["defaults"].(map[string]interface{})["proxyport"] != "15001" {
+		t.Error("mergeValues modified dst")
+	}
+}
+
+func TestReadPolicyTemplate(t *testing.T) {
+	template := writeFile(t, "template.yaml", `
+defaults:
+  ProxyPort: "15001"
+  userSID: system
+policies:
+  - remotePorts: "80"
+  - RemotePorts: "443"
+    priority: 10
+`)
+	// Keys differing in case from the template still override its values.
+	values := writeFile(t, "values.yaml", `
+Defaults:
+  proxyPort: "16001"
+  UserSID: null
+`)
+
+	policies, err := readPolicyTemplate(template, []string{values})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []proxy.Policy{
+		{ProxyPort: "16001", RemotePorts: "80"},
+		{ProxyPort: "16001", RemotePorts: "443", Priority: 10},
+	}
+	if !reflect.DeepEqual(policies, want) {
+		t.Errorf("readPolicyTemplate = %+v, want %+v", policies, want)
+	}
+
+	policies, err = readPolicyTemplate(template, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if policies[0].ProxyPort != "15001" || policies[0].UserSID != "system" {
+		t.Errorf("readPolicyTemplate without values = %+v, want the defaults of the template", policies)
+	}
+}
+
+func TestReadPolicyTemplateInvalid(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+	}{
+		{"no policies", "defaults:\n  proxyPort: \"15001\"\n"},
+		{"duplicate keys", "policies:\n  - proxyPort: \"15001\"\n    ProxyPort: \"15002\"\n"},
+		{"not a map", "- proxyPort: \"15001\"\n"},
+	}
+	for _, test := range tests {
+		path := writeFile(t, "template.yaml", test.template)
+		if policies, err := readPolicyTemplate(path, nil); err == nil {
+			t.Errorf("%s: readPolicyTemplate = %+v, want an error", test.name, policies)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+
+	proxy "github.com/microsoft/hcnproxyctrl/proxy"
+	"github.com/spf13/cobra"
+)
+
+// Flags for the "verify-intercept" command
+var (
+	verifyEndpoint string
+	verifyRemote   string
+	verifyListen   string
+)
+
+var cmdVerifyIntercept = &cobra.Command{
+	Use:   "verify-intercept",
+	Short: "Check that a connection is redirected to the proxy port by the policies of an endpoint",
+	Long: `Check that a connection is redirected to the proxy port by the policies of an endpoint.
+
+This requires a test listener to be serving in place of the proxy on the proxy
+port, which is started with --listen <port> (eg. while the proxy is stopped).
+The test listener answers every connection with a marker line naming its port.
+A connection to --remote is then made: if it receives the marker, it was
+intercepted, and the port of the listener is checked against the proxy ports
+of the policies of --endpoint.`,
+	Args: cobra.NoArgs,
+
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(verifyListen) > 0 {
+			if len(verifyRemote) > 0 {
+				errorOut(errors.New("--listen cannot be combined with --remote"))
+			}
+			listener, err := net.Listen("tcp", net.JoinHostPort("", verifyListen))
+			if err != nil {
+				errorOut(err)
+			}
+			fmt.Println("Test listener serving on", listener.Addr())
+			errorOut(proxy.ServeInterceptMarker(listener))
+		}
+
+		if len(verifyEndpoint) == 0 || len(verifyRemote) == 0 {
+			errorOut(errors.New("--endpoint and --remote must be specified, or --listen"))
+		}
+		endpointID := resolveEndpoint(verifyEndpoint)
+
+		var policies []proxy.Policy
+		err := callHNS(func() (err error) {
+			policies, err = proxy.ListPolicies(endpointID)
+			return err
+		})
+		if err != nil {
+			errorOut(err)
+		}
+
+		result := proxy.VerifyIntercept(verifyRemote, proxy.DialTCP)
+		if !result.Intercepted {
+			fmt.Println("Not intercepted:", result.Detail)
+			os.Exit(1)
+		}
+		for _, policy := range policies {
+			if policy.ProxyPort == result.ProxyPort {
+				fmt.Printf("Intercepted: %s, the proxy port of policy %s\n", result.Detail, policy.Key())
+				return
+			}
+		}
+		fmt.Printf("Intercepted, but not by the endpoint's policies: %s\n", result.Detail)
+		os.Exit(1)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(cmdVerifyIntercept)
+
+	cmdVerifyIntercept.Flags().StringVar(&verifyEndpoint, "endpoint", "", "ID or name of the endpoint whose policies should intercept the connection")
+	cmdVerifyIntercept.Flags().StringVar(&verifyRemote, "remote", "", "address to connect to, as ip:port")
+	cmdVerifyIntercept.Flags().StringVar(&verifyListen, "listen", "", "run a test listener on this port instead, until interrupted")
+}
@@ -0,0 +1,100 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/Microsoft/hcsshim/hcn"
+	proxy "github.com/microsoft/hcnproxyctrl/proxy"
+	"github.com/spf13/cobra"
+)
+
+// Flags for the "watch" command
+var (
+	watchFile            string
+	watchNetwork         string
+	watchNamePattern     string
+	watchInterval        time.Duration
+	watchIncludeExisting bool
+)
+
+var cmdWatch = &cobra.Command{
+	Use:   "watch",
+	Short: "Apply the proxy policies of a file to every new endpoint",
+	Long: `Apply the proxy policies of a file to every new endpoint.
+
+The endpoints are listed at every --interval, and the proxy policies of each
+new endpoint are made to match the ones of --file, as with reconcile. Only the
+endpoints of the --network network whose name matches --name-pattern are
+handled, if set. Endpoints are only handled once, unless they fail to be, in
+which case they are retried at the next interval. The command runs until it is
+interrupted.`,
+	Args: cobra.NoArgs,
+
+	Run: func(cmd *cobra.Command, args []string) {
+		if watchInterval <= 0 {
+			errorOut(fmt.Errorf("invalid interval: %v", watchInterval))
+		}
+		desired, err := readPolicyFile(watchFile)
+		if err != nil {
+			errorOut(err)
+		}
+
+		filter := proxy.EndpointFilter{NamePattern: watchNamePattern}
+		if len(watchNetwork) > 0 {
+			if filter.NetworkID, err = proxy.ResolveNetworkID(watchNetwork); err != nil {
+				errorOut(err)
+			}
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		interrupted := make(chan os.Signal, 1)
+		signal.Notify(interrupted, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			<-interrupted
+			cancel()
+		}()
+
+		logf := func(format string, args ...interface{}) {
+			fmt.Printf("%s %s\n", time.Now().Format(time.RFC3339), fmt.Sprintf(format, args...))
+		}
+		onNew := func(endpoint hcn.HostComputeEndpoint) error {
+			var result proxy.ReconcileResult
+			err := callHNS(func() (err error) {
+				result, err = proxy.ReconcilePolicies(endpoint.Id, desired, proxy.ReconcileOptions{})
+				return err
+			})
+			if err != nil {
+				return err
+			}
+			if err := audit("reconcile", endpoint.Id, fmt.Sprintf("added %d and removed %d policies", result.Added, result.Removed)); err != nil {
+				return err
+			}
+			logf("%s (%s): added %d and removed %d policies", endpoint.Id, endpoint.Name, result.Added, result.Removed)
+			return nil
+		}
+
+		options := proxy.WatchOptions{Interval: watchInterval, IncludeExisting: watchIncludeExisting}
+		if err := proxy.WatchEndpoints(ctx, hcn.ListEndpoints, filter, options, onNew, logf); err != nil {
+			errorOut(err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(cmdWatch)
+
+	cmdWatch.Flags().StringVar(&watchFile, "file", "", "YAML or JSON file holding the list of policies to apply to new endpoints")
+	cmdWatch.MarkFlagRequired("file")
+	cmdWatch.Flags().StringVar(&watchNetwork, "network", "", "only handle the endpoints of the network with this ID or name")
+	cmdWatch.Flags().StringVar(&watchNamePattern, "name-pattern", "", `only handle the endpoints whose name matches this pattern (eg. "cni-*")`)
+	cmdWatch.Flags().DurationVar(&watchInterval, "interval", 5*time.Second, "how often to look for new endpoints")
+	cmdWatch.Flags().BoolVar(&watchIncludeExisting, "include-existing", false, "also handle the endpoints that exist when the command starts")
+}
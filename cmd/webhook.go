@@ -0,0 +1,104 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	proxy "github.com/microsoft/hcnproxyctrl/proxy"
+)
+
+// Webhook notified of every successful change made to the proxy policies.
+var (
+	webhookURL     string
+	webhookTimeout time.Duration
+)
+
+// webhookAttempts is how many times a notification is sent before giving up,
+// when the webhook cannot be reached or fails with a 5xx status.
+const webhookAttempts = 3
+
+// webhookRetryInterval is how long to wait before the first retry, doubled
+// before each of the next ones.
+var webhookRetryInterval = time.Second
+
+// webhooksInFlight tracks the notifications being sent, so that the process
+// does not exit before they are.
+var webhooksInFlight sync.WaitGroup
+
+// webhookEvent is the JSON document posted to the webhook.
+type webhookEvent struct {
+	Timestamp   time.Time `json:"timestamp"`
+	Operation   string    `json:"operation"`
+	Endpoint    string    `json:"endpoint"`
+	NumPolicies int       `json:"numPolicies"`
+	PolicyKeys  []string  `json:"policyKeys"`
+}
+
+// notifyWebhook posts the event to the webhook in the background. Failures
+// are only reported on stderr, since the change was already made.
+func notifyWebhook(event proxy.HookEvent) {
+	payload := webhookEvent{
+		Timestamp:   time.Now().UTC(),
+		Operation:   event.Operation,
+		Endpoint:    event.EndpointID,
+		NumPolicies: len(event.Policies),
+		PolicyKeys:  []string{},
+	}
+	for _, policy := range event.Policies {
+		payload.PolicyKeys = append(payload.PolicyKeys, policy.Key())
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "could not notify the webhook:", err)
+		return
+	}
+
+	webhooksInFlight.Add(1)
+	go func() {
+		defer webhooksInFlight.Done()
+		client := &http.Client{Timeout: webhookTimeout}
+		if err := postWebhook(client, webhookURL, body, webhookAttempts, webhookRetryInterval); err != nil {
+			fmt.Fprintln(os.Stderr, "could not notify the webhook:", err)
+		}
+	}()
+}
+
+// postWebhook posts the body to the URL, retrying when the request fails or
+// the server responds with a 5xx status.
+func postWebhook(client *http.Client, url string, body []byte, attempts int, interval time.Duration) error {
+	var err error
+	for attempt := 1; ; attempt++ {
+		var resp *http.Response
+		resp, err = client.Post(url, "application/json", bytes.NewReader(body))
+		if err == nil {
+			resp.Body.Close()
+			switch {
+			case resp.StatusCode >= 500:
+				err = fmt.Errorf("%s returned %s", url, resp.Status)
+			case resp.StatusCode >= 300:
+				return fmt.Errorf("%s returned %s", url, resp.Status)
+			default:
+				return nil
+			}
+		}
+		if attempt >= attempts {
+			return err
+		}
+		time.Sleep(interval)
+		interval *= 2
+	}
+}
+
+// waitWebhooks waits for the notifications being sent, so that exiting does
+// not cut them short.
+func waitWebhooks() {
+	webhooksInFlight.Wait()
+}
@@ -0,0 +1,127 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package cmd
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	proxy "github.com/microsoft/hcnproxyctrl/proxy"
+)
+
+// webhookServer is a test webhook responding with the given statuses in turn,
+// the last one repeating, and recording the bodies it receives.
+type webhookServer struct {
+	*httptest.Server
+
+	mutex    sync.Mutex
+	statuses []int
+	bodies   [][]byte
+}
+
+func newWebhookServer(t *testing.T, statuses ...int) *webhookServer {
+	server := &webhookServer{statuses: statuses}
+	server.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("webhook received a %s request of type %q", r.Method, r.Header.Get("Content-Type"))
+		}
+
+		server.mutex.Lock()
+		defer server.mutex.Unlock()
+		server.bodies = append(server.bodies, body)
+		status := server.statuses[0]
+		if len(server.statuses) > 1 {
+			server.statuses = server.statuses[1:]
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func (server *webhookServer) requests() int {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	return len(server.bodies)
+}
+
+func TestPostWebhook(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+		requests int
+		ok       bool
+	}{
+		{name: "success", statuses: []int{http.StatusOK}, requests: 1, ok: true},
+		{name: "retried 5xx", statuses: []int{http.StatusServiceUnavailable, http.StatusBadGateway, http.StatusNoContent}, requests: 3, ok: true},
+		{name: "persistent 5xx", statuses: []int{http.StatusInternalServerError}, requests: 3},
+		{name: "4xx not retried", statuses: []int{http.StatusBadRequest}, requests: 1},
+		{name: "4xx after 5xx", statuses: []int{http.StatusInternalServerError, http.StatusNotFound}, requests: 2},
+	}
+	for _, test := range tests {
+		server := newWebhookServer(t, test.statuses...)
+		err := postWebhook(server.Client(), server.URL, []byte(`{}`), 3, time.Millisecond)
+		if (err == nil) != test.ok {
+			t.Errorf("%s: postWebhook = %v, want success = %v", test.name, err, test.ok)
+		}
+		if requests := server.requests(); requests != test.requests {
+			t.Errorf("%s: webhook received %d requests, want %d", test.name, requests, test.requests)
+		}
+	}
+}
+
+func TestPostWebhookUnreachable(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	url := server.URL
+	server.Close()
+
+	if err := postWebhook(http.DefaultClient, url, []byte(`{}`), 2, time.Millisecond); err == nil {
+		t.Error("postWebhook succeeded without a server")
+	}
+}
+
+func TestNotifyWebhook(t *testing.T) {
+	server := newWebhookServer(t, http.StatusOK)
+	previous := webhookURL
+	webhookURL = server.URL
+	defer func() { webhookURL = previous }()
+
+	policies := []proxy.Policy{{ProxyPort: "15001", RemotePorts: "80"}, {ProxyPort: "15001", RemotePorts: "443"}}
+	notifyWebhook(proxy.HookEvent{Operation: "add", EndpointID: "ep", Policies: policies})
+	notifyWebhook(proxy.HookEvent{Operation: "clear", EndpointID: "ep"})
+	waitWebhooks()
+
+	if server.requests() != 2 {
+		t.Fatalf("webhook received %d requests, want 2", server.requests())
+	}
+	var events []webhookEvent
+	for _, body := range server.bodies {
+		var event webhookEvent
+		if err := json.Unmarshal(body, &event); err != nil {
+			t.Fatalf("invalid webhook event %s: %v", body, err)
+		}
+		events = append(events, event)
+	}
+	// The notifications are sent concurrently.
+	if events[0].Operation != "add" {
+		events[0], events[1] = events[1], events[0]
+	}
+
+	add := events[0]
+	if add.Operation != "add" || add.Endpoint != "ep" || add.NumPolicies != 2 ||
+		!reflect.DeepEqual(add.PolicyKeys, []string{policies[0].Key(), policies[1].Key()}) || add.Timestamp.IsZero() {
+		t.Errorf("add event %+v", add)
+	}
+	cleared := events[1]
+	if cleared.Operation != "clear" || cleared.NumPolicies != 0 || cleared.PolicyKeys == nil {
+		t.Errorf("clear event %+v, want no policy key but an empty list", cleared)
+	}
+}
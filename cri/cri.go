@@ -6,7 +6,9 @@ package cri
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/urfave/cli"
@@ -30,18 +32,35 @@ type CriParameters struct {
 	Timeout         time.Duration
 }
 
-// DefaultCriParameters
+// DefaultContainerdRuntimeEndpoint is the named pipe containerd listens on
+// on Windows. npipe endpoints are only supported on Windows.
+const DefaultContainerdRuntimeEndpoint = "npipe:////./pipe/containerd-containerd"
+
+// DefaultContainerdCriParameters connect to containerd on its default named
+// pipe. Docker-era setups listening on tcp://127.0.0.1:2376 need to set the
+// RuntimeEndpoint explicitly.
 func DefaultContainerdCriParameters() CriParameters {
 	params := CriParameters{}
-	params.RuntimeEndpoint = "tcp://127.0.0.1:2376"
+	params.RuntimeEndpoint = DefaultContainerdRuntimeEndpoint
 	params.Timeout = 2 * time.Second
 	return params
 }
 
 // ContainerInfo
 type ContainerInfo struct {
-	ContainerId string
-	NamespaceId string
+	ContainerId  string
+	Name         string
+	NamespaceId  string
+	PodSandboxId string
+	// Pid is the ID of the init process of the container, or 0 if the
+	// runtime does not report it.
+	Pid int
+}
+
+// PodSandboxInfo
+type PodSandboxInfo struct {
+	PodSandboxId string
+	IPs          []string
 }
 
 // ListContainers
@@ -65,43 +84,172 @@ func ListContainers(criParameters CriParameters) (containers []ContainerInfo, er
 	}
 
 	criContainers := response.GetContainers()
+	// The containers left out, along with the reason why
+	var skipped []string
 	for _, container := range criContainers {
 		containerStatusRequest := &pb.ContainerStatusRequest{
 			ContainerId: container.Id,
 			Verbose:     true, // Populates the info json
 		}
+		// A container whose status cannot be read, eg. because it was
+		// removed since it was listed, does not abort the listing.
 		containerStatusResponse, err := runtimeClient.ContainerStatus(context.Background(), containerStatusRequest)
 		if err != nil {
-			return nil, err
+			skipped = append(skipped, fmt.Sprintf("%s: %v", container.Id, err))
+			continue
 		}
 
 		// Read the info json
-		info := containerStatusResponse.Info["info"]
-		var infoMap map[string]interface{}
-		json.Unmarshal([]byte(info), &infoMap)
+		infoMap, err := parseStatusInfo(containerStatusResponse.Info["info"])
+		if err != nil {
+			skipped = append(skipped, fmt.Sprintf("%s: %v", container.Id, err))
+			continue
+		}
 
-		runtimeSpec := infoMap["runtimeSpec"].(map[string]interface{})
-		windows := runtimeSpec["windows"].(map[string]interface{})
-		network := windows["network"].(map[string]interface{})
-		networkNamespace := network["networkNamespace"].(string)
+		// Skip the containers without a Windows network namespace, eg.
+		// Linux containers or runtimes reporting a different structure.
+		networkNamespace, err := windowsNetworkNamespace(infoMap)
+		if err != nil {
+			skipped = append(skipped, fmt.Sprintf("%s: %v", container.Id, err))
+			continue
+		}
 
 		foundContainer := ContainerInfo{
-			ContainerId: container.Id,
-			NamespaceId: networkNamespace,
+			ContainerId:  container.Id,
+			Name:         container.GetMetadata().GetName(),
+			NamespaceId:  networkNamespace,
+			PodSandboxId: container.PodSandboxId,
+		}
+		if pid, ok := infoMap["pid"].(float64); ok {
+			foundContainer.Pid = int(pid)
 		}
 		foundContainers = append(foundContainers, foundContainer)
 	}
 
+	if len(foundContainers) == 0 && len(skipped) > 0 {
+		return nil, fmt.Errorf("none of the %d containers has a Windows network namespace in its status info: %s", len(skipped), strings.Join(skipped, "; "))
+	}
 	return foundContainers, nil
 }
 
+// parseStatusInfo parses the info json of a verbose container status.
+func parseStatusInfo(info string) (map[string]interface{}, error) {
+	var infoMap map[string]interface{}
+	if err := json.Unmarshal([]byte(info), &infoMap); err != nil {
+		return nil, fmt.Errorf("invalid status info: %v", err)
+	}
+	return infoMap, nil
+}
+
+// windowsNetworkNamespace returns the network namespace found at
+// runtimeSpec.windows.network.networkNamespace in the info of a container
+// status, and an error naming the first missing or malformed field otherwise.
+func windowsNetworkNamespace(infoMap map[string]interface{}) (string, error) {
+	runtimeSpec, ok := infoMap["runtimeSpec"].(map[string]interface{})
+	if !ok {
+		return "", errors.New("no runtimeSpec in the status info")
+	}
+	windows, ok := runtimeSpec["windows"].(map[string]interface{})
+	if !ok {
+		return "", errors.New("no runtimeSpec.windows in the status info")
+	}
+	network, ok := windows["network"].(map[string]interface{})
+	if !ok {
+		return "", errors.New("no runtimeSpec.windows.network in the status info")
+	}
+	networkNamespace, ok := network["networkNamespace"].(string)
+	if !ok || len(networkNamespace) == 0 {
+		return "", errors.New("no runtimeSpec.windows.network.networkNamespace in the status info")
+	}
+	return networkNamespace, nil
+}
+
+// ListPodSandboxes
+func ListPodSandboxes(criParameters CriParameters) (sandboxes []PodSandboxInfo, err error) {
+	foundSandboxes := []PodSandboxInfo{}
+	// Connect to the CRI Endpoint
+	RuntimeEndpoint = criParameters.RuntimeEndpoint
+	Timeout = criParameters.Timeout
+	app := cli.NewApp()
+	ctx := cli.NewContext(app, nil, nil)
+	runtimeClient, runtimeConn, err := getRuntimeClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer closeConnection(ctx, runtimeConn)
+
+	request := &pb.ListPodSandboxRequest{}
+	response, err := runtimeClient.ListPodSandbox(context.Background(), request)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, sandbox := range response.GetItems() {
+		statusRequest := &pb.PodSandboxStatusRequest{
+			PodSandboxId: sandbox.Id,
+		}
+		statusResponse, err := runtimeClient.PodSandboxStatus(context.Background(), statusRequest)
+		if err != nil {
+			return nil, err
+		}
+
+		// Dual-stack pods have their second IP in AdditionalIps
+		foundSandbox := PodSandboxInfo{
+			PodSandboxId: sandbox.Id,
+		}
+		network := statusResponse.GetStatus().GetNetwork()
+		if len(network.GetIp()) > 0 {
+			foundSandbox.IPs = append(foundSandbox.IPs, network.GetIp())
+		}
+		for _, podIP := range network.GetAdditionalIps() {
+			foundSandbox.IPs = append(foundSandbox.IPs, podIP.GetIp())
+		}
+		foundSandboxes = append(foundSandboxes, foundSandbox)
+	}
+
+	return foundSandboxes, nil
+}
+
+// RuntimeVersion returns the name and version of the container runtime
+// behind the CRI RuntimeEndpoint
+func RuntimeVersion(criParameters CriParameters) (name string, version string, err error) {
+	// Connect to the CRI Endpoint
+	RuntimeEndpoint = criParameters.RuntimeEndpoint
+	Timeout = criParameters.Timeout
+	app := cli.NewApp()
+	ctx := cli.NewContext(app, nil, nil)
+	runtimeClient, runtimeConn, err := getRuntimeClient(ctx)
+	if err != nil {
+		return "", "", err
+	}
+	defer closeConnection(ctx, runtimeConn)
+
+	request := &pb.VersionRequest{}
+	response, err := runtimeClient.Version(context.Background(), request)
+	if err != nil {
+		return "", "", err
+	}
+
+	return response.GetRuntimeName(), response.GetRuntimeVersion(), nil
+}
+
+// ConnectError is returned when the connection to the CRI RuntimeEndpoint
+// could not be established, as opposed to a failed call
+type ConnectError struct {
+	Err error
+}
+
+func (e *ConnectError) Error() string {
+	return fmt.Sprintf("failed to connect: %v", e.Err)
+}
+
 // Copied from https://github.com/kubernetes-sigs/cri-tools/cmd/crictl/util.go
 
 func getRuntimeClient(context *cli.Context) (pb.RuntimeServiceClient, *grpc.ClientConn, error) {
 	// Set up a connection to the server.
 	conn, err := getRuntimeClientConnection(context)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to connect: %v", err)
+		return nil, nil, &ConnectError{Err: err}
 	}
 
 	runtimeClient := pb.NewRuntimeServiceClient(conn)
@@ -118,6 +266,8 @@ func closeConnection(context *cli.Context, conn *grpc.ClientConn) error {
 // Copied from https://github.com/kubernetes-sigs/cri-tools/cmd/crictl/main.go
 
 func getRuntimeClientConnection(context *cli.Context) (*grpc.ClientConn, error) {
+	// On Windows, the kubelet util dials npipe:// endpoints through winio
+	// and tcp:// ones through net.Dial.
 	addr, dialer, err := util.GetAddressAndDialer(RuntimeEndpoint)
 	if err != nil {
 		return nil, err
@@ -0,0 +1,55 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package cri
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWindowsNetworkNamespace(t *testing.T) {
+	tests := []struct {
+		name      string
+		info      string
+		namespace string
+		// The error is expected to contain err, or no error if empty.
+		err string
+	}{
+		{
+			name:      "namespace",
+			info:      `{"pid": 1234, "runtimeSpec": {"windows": {"network": {"networkNamespace": "ns1"}}}}`,
+			namespace: "ns1",
+		},
+		{name: "invalid JSON", info: `{"runtimeSpec": `, err: "invalid status info"},
+		{name: "empty info", info: "", err: "invalid status info"},
+		{name: "missing runtimeSpec", info: `{"pid": 1234}`, err: "no runtimeSpec in"},
+		{name: "null runtimeSpec", info: `{"runtimeSpec": null}`, err: "no runtimeSpec in"},
+		{name: "missing windows", info: `{"runtimeSpec": {"linux": {}}}`, err: "no runtimeSpec.windows in"},
+		{name: "non-map windows", info: `{"runtimeSpec": {"windows": "ns1"}}`, err: "no runtimeSpec.windows in"},
+		{name: "missing network", info: `{"runtimeSpec": {"windows": {}}}`, err: "no runtimeSpec.windows.network in"},
+		{
+			name: "non-string networkNamespace",
+			info: `{"runtimeSpec": {"windows": {"network": {"networkNamespace": 1}}}}`,
+			err:  "no runtimeSpec.windows.network.networkNamespace in",
+		},
+		{
+			name: "empty networkNamespace",
+			info: `{"runtimeSpec": {"windows": {"network": {"networkNamespace": ""}}}}`,
+			err:  "no runtimeSpec.windows.network.networkNamespace in",
+		},
+	}
+	for _, test := range tests {
+		infoMap, err := parseStatusInfo(test.info)
+		var namespace string
+		if err == nil {
+			namespace, err = windowsNetworkNamespace(infoMap)
+		}
+		if len(test.err) == 0 && (err != nil || namespace != test.namespace) {
+			t.Errorf("%s: got namespace %q, error %v, expected %q", test.name, namespace, err, test.namespace)
+		}
+		if len(test.err) > 0 && (err == nil || !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("%s: got namespace %q, error %v, expected an error containing %q", test.name, namespace, err, test.err)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package cri
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"sigs.k8s.io/yaml"
+)
+
+// CrictlConfig holds the settings of a crictl config file that are relevant
+// to connecting to the CRI RuntimeEndpoint
+type CrictlConfig struct {
+	RuntimeEndpoint string `json:"runtime-endpoint"`
+	// Timeout is in seconds, as in crictl
+	Timeout int `json:"timeout"`
+}
+
+// DefaultCrictlConfigPath returns the path of the config file crictl reads by default
+func DefaultCrictlConfigPath() string {
+	if runtime.GOOS == "windows" {
+		return filepath.Join(os.Getenv("USERPROFILE"), ".crictl", "crictl.yaml")
+	}
+	return "/etc/crictl.yaml"
+}
+
+// LoadCrictlConfig reads a crictl config file
+func LoadCrictlConfig(path string) (*CrictlConfig, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseCrictlConfig(path, data)
+}
+
+func parseCrictlConfig(path string, data []byte) (*CrictlConfig, error) {
+	config := &CrictlConfig{}
+	if err := yaml.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("invalid crictl config file %s: %v", path, err)
+	}
+	if config.Timeout < 0 {
+		return nil, fmt.Errorf("invalid crictl config file %s: negative timeout %d", path, config.Timeout)
+	}
+	return config, nil
+}
+
+// Apply sets the RuntimeEndpoint and Timeout of the parameters to the ones of
+// the config file, when the latter are set
+func (config *CrictlConfig) Apply(params *CriParameters) {
+	if len(config.RuntimeEndpoint) > 0 {
+		params.RuntimeEndpoint = config.RuntimeEndpoint
+	}
+	if config.Timeout > 0 {
+		params.Timeout = time.Duration(config.Timeout) * time.Second
+	}
+}
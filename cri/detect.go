@@ -0,0 +1,62 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package cri
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WellKnownRuntimeEndpoints are the CRI RuntimeEndpoints probed by
+// DetectRuntimeEndpoint, in order: containerd, then Docker through dockershim
+// over a named pipe and TCP
+var WellKnownRuntimeEndpoints = []string{
+	DefaultContainerdRuntimeEndpoint,
+	"npipe:////./pipe/dockershim",
+	"tcp://127.0.0.1:2376",
+}
+
+// probeRuntimeEndpoint returns an error unless a CRI runtime responds on the endpoint
+var probeRuntimeEndpoint = func(criParameters CriParameters) error {
+	_, _, err := RuntimeVersion(criParameters)
+	return err
+}
+
+var (
+	detectedRuntimeEndpoint string
+	detectMutex             sync.Mutex
+)
+
+// DetectRuntimeEndpoint returns the first of the WellKnownRuntimeEndpoints on
+// which a CRI runtime responds to a Version request. The endpoint found is
+// cached for the lifetime of the process.
+func DetectRuntimeEndpoint(timeout time.Duration) (string, error) {
+	detectMutex.Lock()
+	defer detectMutex.Unlock()
+
+	if len(detectedRuntimeEndpoint) > 0 {
+		return detectedRuntimeEndpoint, nil
+	}
+
+	endpoint, err := detectRuntimeEndpoint(WellKnownRuntimeEndpoints, timeout, probeRuntimeEndpoint)
+	if err != nil {
+		return "", err
+	}
+	detectedRuntimeEndpoint = endpoint
+	return endpoint, nil
+}
+
+func detectRuntimeEndpoint(candidates []string, timeout time.Duration, probe func(CriParameters) error) (string, error) {
+	var failures []string
+	for _, candidate := range candidates {
+		err := probe(CriParameters{RuntimeEndpoint: candidate, Timeout: timeout})
+		if err == nil {
+			return candidate, nil
+		}
+		failures = append(failures, fmt.Sprintf("%s: %v", candidate, err))
+	}
+	return "", fmt.Errorf("no CRI runtime responded on any of the well-known endpoints (%s)", strings.Join(failures, "; "))
+}
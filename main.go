@@ -8,12 +8,28 @@
 //    hcnproxyctrl.exe [command]
 //
 //    Available Commands:
-//      add         Add a proxy policy to an endpoint
-//      clear       Remove all proxy policies from an endpoint
-//      help        Help about any command
-//      list        List the proxy policies on an endpoint
-//      lookup      Report the ID of the HNS endpoint to which the specified container is attached
-//      version     Output the version of hcnproxyctrl
+//      add                 Add a proxy policy to an endpoint
+//      apply-to-containers Add a proxy policy to the endpoints of a list of containers
+//      clear               Remove all proxy policies from an endpoint
+//      count               Print the number of proxy policies on an endpoint
+//      coverage            Report the TCP traffic that no proxy policy of an endpoint intercepts
+//      diff-endpoints      Compare the proxy policies of two endpoints
+//      find-orphans        Report the proxy policies whose proxy port has no listener
+//      help                Help about any command
+//      lint                Check the policies of a file against the conventions of a rules file
+//      list                List the proxy policies on an endpoint
+//      list-containers     List the containers known to the container runtime with their endpoints
+//      lookup              Report the ID of the HNS endpoint to which the specified container is attached
+//      normalize           Rewrite the proxy policies of an endpoint in canonical form
+//      reconcile           Make the proxy policies of an endpoint match the ones of a file
+//      remove              Remove the proxy policies with the specified keys from an endpoint
+//      render              Print the HNS policy JSON that add would apply, without applying it
+//      serve               Serve a REST API to manage the proxy policies
+//      test-matrix         Report which proxy policy of an endpoint would intercept each connection of a file
+//      verify-intercept    Check that a connection is redirected to the proxy port by the policies of an endpoint
+//      verify-receipt      Verify that the policy recorded in a receipt is applied
+//      version             Output the version of hcnproxyctrl
+//      watch               Apply the proxy policies of a file to every new endpoint
 //
 //    Flags:
 //      -h, --help   help for hcnproxyctrl.exe
@@ -0,0 +1,63 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package hcnproxyctrl
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/Microsoft/hcsshim/hcn"
+)
+
+// ListACLPolicies returns the settings of the ACL policies of the endpoint, in
+// the order HNS reports them. Their indexes are the ones expected by
+// PriorityAfterACL.
+func ListACLPolicies(hnsEndpointID string) ([]hcn.AclPolicySetting, error) {
+	endpoint, err := getEndpointByID(hnsEndpointID)
+	if err != nil {
+		return nil, err
+	}
+
+	var acls []hcn.AclPolicySetting
+	for _, policy := range endpoint.Policies {
+		if policy.Type != hcn.ACL {
+			continue
+		}
+		var acl hcn.AclPolicySetting
+		if err := json.Unmarshal(policy.Settings, &acl); err != nil {
+			return nil, fmt.Errorf("invalid ACL policy settings: %v", err)
+		}
+		acls = append(acls, acl)
+	}
+	return acls, nil
+}
+
+// PriorityAfterACL returns the priority a proxy policy needs to be evaluated
+// right after the ACL policy with the given index (see ListACLPolicies).
+func PriorityAfterACL(hnsEndpointID string, index int) (uint16, error) {
+	acls, err := ListACLPolicies(hnsEndpointID)
+	if err != nil {
+		return 0, err
+	}
+	if index < 0 || index >= len(acls) {
+		return 0, fmt.Errorf("endpoint %s has no ACL policy with index %d (it has %d)", hnsEndpointID, index, len(acls))
+	}
+	return priorityAfterACL(acls[index].Priority)
+}
+
+// priorityAfterACL computes the proxy policy priority to use to be evaluated
+// right after an ACL policy of the given priority.
+//
+// The two kinds of policies order in opposite directions: ACL policies with
+// lower priorities are evaluated first, while proxy policies with higher
+// priorities are evaluated first. On a common scale where higher weights are
+// evaluated first, an ACL priority p therefore weighs 65535 - p, and the proxy
+// policy must weigh one less, ie. have priority 65534 - p. An ACL policy with
+// priority 65535 is evaluated last, so nothing can come after it.
+func priorityAfterACL(aclPriority uint16) (uint16, error) {
+	if aclPriority == 65535 {
+		return 0, fmt.Errorf("no proxy policy priority comes after ACL priority %d", aclPriority)
+	}
+	return 65534 - aclPriority, nil
+}
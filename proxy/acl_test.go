@@ -0,0 +1,72 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package hcnproxyctrl
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/Microsoft/hcsshim/hcn"
+)
+
+func TestPriorityAfterACLPriority(t *testing.T) {
+	tests := []struct {
+		aclPriority uint16
+		priority    uint16
+		valid       bool
+	}{
+		{0, 65534, true},
+		{1, 65533, true},
+		{100, 65434, true},
+		{65534, 0, true},
+		{65535, 0, false},
+	}
+	for _, test := range tests {
+		priority, err := priorityAfterACL(test.aclPriority)
+		if (err == nil) != test.valid || priority != test.priority {
+			t.Errorf("priorityAfterACL(%d) = %d, %v, want %d (valid = %v)", test.aclPriority, priority, err, test.priority, test.valid)
+		}
+	}
+
+	// The later an ACL policy is evaluated, the later the proxy policy.
+	for aclPriority := uint16(1); aclPriority < 65535; aclPriority++ {
+		before, _ := priorityAfterACL(aclPriority - 1)
+		after, _ := priorityAfterACL(aclPriority)
+		if after >= before {
+			t.Fatalf("priorityAfterACL(%d) = %d, not lower than priorityAfterACL(%d) = %d", aclPriority, after, aclPriority-1, before)
+		}
+	}
+}
+
+func TestPriorityAfterACL(t *testing.T) {
+	endpoint := proxyEndpoint(t, "ep", Policy{ProxyPort: "15001"})
+	for _, priority := range []uint16{200, 100, 65535} {
+		settings, err := json.Marshal(hcn.AclPolicySetting{Action: hcn.ActionTypeBlock, Direction: hcn.DirectionTypeOut, Priority: priority})
+		if err != nil {
+			t.Fatal(err)
+		}
+		endpoint.Policies = append(endpoint.Policies, hcn.EndpointPolicy{Type: hcn.ACL, Settings: settings})
+	}
+	newFakeHNS(t, endpoint)
+
+	tests := []struct {
+		index    int
+		priority uint16
+		valid    bool
+	}{
+		// ACL policies are indexed in the order HNS reports them, skipping
+		// the proxy policies.
+		{0, 65334, true},
+		{1, 65434, true},
+		{2, 0, false},
+		{3, 0, false},
+		{-1, 0, false},
+	}
+	for _, test := range tests {
+		priority, err := PriorityAfterACL("ep", test.index)
+		if (err == nil) != test.valid || priority != test.priority {
+			t.Errorf("PriorityAfterACL(%d) = %d, %v, want %d (valid = %v)", test.index, priority, err, test.priority, test.valid)
+		}
+	}
+}
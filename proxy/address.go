@@ -0,0 +1,238 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package hcnproxyctrl
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// LocalTrafficCIDRs are the IPv4 loopback and link-local ranges, whose traffic
+// is typically not meant to go through the proxy (eg. local health checks).
+var LocalTrafficCIDRs = []string{"127.0.0.0/8", "169.254.0.0/16"}
+
+// ipFamily returns 4 or 6 depending on whether the address, which may be an
+// IP or a CIDR, is IPv4 or IPv6. It returns 0 if the address is neither.
+func ipFamily(address string) int {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		var err error
+		if ip, _, err = net.ParseCIDR(address); err != nil {
+			return 0
+		}
+	}
+	if ip.To4() != nil {
+		return 4
+	}
+	return 6
+}
+
+// validateAddresses returns an error naming the first entry of the
+// comma-separated list of addresses that is neither an IP address nor a CIDR.
+func validateAddresses(list string) error {
+	for i, address := range strings.Split(list, ",") {
+		address = strings.TrimSpace(address)
+		if len(address) == 0 {
+			return fmt.Errorf("entry %d is empty", i+1)
+		}
+		if net.ParseIP(address) != nil {
+			continue
+		}
+		if _, _, err := net.ParseCIDR(address); err != nil {
+			return fmt.Errorf("entry %d (%q) is neither an IP address nor a CIDR", i+1, address)
+		}
+	}
+	return nil
+}
+
+// trimAddresses removes the whitespace around the entries of a
+// comma-separated list of addresses, eg. "10.0.0.1, 10.0.0.2" becomes
+// "10.0.0.1,10.0.0.2".
+func trimAddresses(list string) string {
+	if len(list) == 0 {
+		return list
+	}
+	addresses := strings.Split(list, ",")
+	for i, address := range addresses {
+		addresses[i] = strings.TrimSpace(address)
+	}
+	return strings.Join(addresses, ",")
+}
+
+// validateAddressFamilies returns an error if the local and remote addresses
+// of the policy are not all of the same IP family. HNS does not match traffic
+// against policies mixing IPv4 and IPv6 addresses.
+func validateAddressFamilies(policy Policy) error {
+	var first string
+	var firstFamily int
+	for _, list := range []string{policy.LocalAddresses, policy.RemoteAddresses} {
+		for _, address := range strings.Split(list, ",") {
+			address = strings.TrimSpace(address)
+			family := ipFamily(address)
+			if family == 0 {
+				continue
+			}
+			if firstFamily == 0 {
+				first, firstFamily = address, family
+			} else if family != firstFamily {
+				return fmt.Errorf("policy mixes IPv%d address %s and IPv%d address %s", firstFamily, first, family, address)
+			}
+		}
+	}
+	return nil
+}
+
+// CheckEndpointAddressFamilies returns an error if the policy has addresses of
+// an IP family the endpoint has no address of, since such a policy can never
+// match the traffic of the endpoint (eg. IPv6 addresses on an IPv4-only
+// endpoint). Endpoints without addresses are not checked.
+func CheckEndpointAddressFamilies(hnsEndpointID string, policy Policy) error {
+	endpoint, err := getEndpointByID(hnsEndpointID)
+	if err != nil {
+		return err
+	}
+
+	var addresses []string
+	for _, ipConfig := range endpoint.IpConfigurations {
+		addresses = append(addresses, ipConfig.IpAddress)
+	}
+	return checkAddressFamilies(addresses, policy)
+}
+
+// checkAddressFamilies returns an error if the policy has addresses of an IP
+// family none of the endpoint addresses is of.
+func checkAddressFamilies(endpointAddresses []string, policy Policy) error {
+	families := make(map[int]bool)
+	for _, address := range endpointAddresses {
+		if family := ipFamily(address); family != 0 {
+			families[family] = true
+		}
+	}
+	if len(families) == 0 {
+		return nil
+	}
+
+	for _, list := range []string{policy.LocalAddresses, policy.RemoteAddresses} {
+		for _, address := range strings.Split(list, ",") {
+			address = strings.TrimSpace(address)
+			family := ipFamily(address)
+			if family != 0 && !families[family] {
+				return fmt.Errorf("policy address %s is IPv%d but the endpoint has no IPv%d address (%s)",
+					address, family, family, strings.Join(endpointAddresses, ", "))
+			}
+		}
+	}
+	return nil
+}
+
+// ExcludeAddresses returns a comma-separated list of CIDRs covering the IPv4
+// addresses in the comma-separated list, except for the excluded IPv4 CIDRs.
+// IPv6 entries are kept as is.
+// Since HNS address filters cannot express exclusions, this is how traffic to
+// some addresses is kept from being proxied, eg. by excluding LocalTrafficCIDRs.
+// The list must have IPv4 entries: an empty list matches IPv6 traffic too,
+// which would stop being proxied if it were restricted to IPv4 ranges. Use
+// 0.0.0.0/0 to only proxy IPv4 traffic.
+func ExcludeAddresses(addresses string, excluded []string) (string, error) {
+	if len(addresses) == 0 {
+		return "", errors.New("cannot exclude addresses without IPv4 addresses to restrict, as all the IPv6 traffic would stop being proxied: use 0.0.0.0/0 to only proxy IPv4 traffic")
+	}
+
+	var exclusions []*net.IPNet
+	for _, cidr := range excluded {
+		_, exclusion, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return "", fmt.Errorf("invalid address range %q", cidr)
+		}
+		if exclusion.IP.To4() == nil {
+			return "", fmt.Errorf("cannot exclude the IPv6 address range %q", cidr)
+		}
+		exclusions = append(exclusions, exclusion)
+	}
+
+	var result []string
+	restricted := false
+	for _, address := range strings.Split(addresses, ",") {
+		address = strings.TrimSpace(address)
+		network, err := parseIPv4Network(address)
+		if err != nil {
+			return "", err
+		}
+		if network == nil {
+			result = append(result, address)
+			continue
+		}
+
+		remaining := []*net.IPNet{network}
+		for _, exclusion := range exclusions {
+			var next []*net.IPNet
+			for _, n := range remaining {
+				next = append(next, subtractNetwork(n, exclusion)...)
+			}
+			remaining = next
+		}
+		for _, n := range remaining {
+			result = append(result, n.String())
+		}
+		restricted = true
+	}
+	if !restricted {
+		return "", fmt.Errorf("cannot exclude addresses from %q, which has no IPv4 addresses", addresses)
+	}
+	if len(result) == 0 {
+		// An empty list would match all the addresses instead.
+		return "", fmt.Errorf("all the addresses of %q are excluded", addresses)
+	}
+
+	return strings.Join(result, ","), nil
+}
+
+// parseIPv4Network parses an IP or a CIDR, returning a /32 network for an
+// IPv4 address, and nil for an IPv6 address or range.
+func parseIPv4Network(address string) (*net.IPNet, error) {
+	if ip := net.ParseIP(address); ip != nil {
+		if ip.To4() == nil {
+			return nil, nil
+		}
+		return &net.IPNet{IP: ip.To4(), Mask: net.CIDRMask(32, 32)}, nil
+	}
+
+	_, network, err := net.ParseCIDR(address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid address %q", address)
+	}
+	if network.IP.To4() == nil {
+		return nil, nil
+	}
+	return network, nil
+}
+
+// subtractNetwork returns the minimal set of IPv4 networks covering the
+// addresses of n that are not in x.
+func subtractNetwork(n, x *net.IPNet) []*net.IPNet {
+	nOnes, _ := n.Mask.Size()
+	xOnes, _ := x.Mask.Size()
+	if !n.Contains(x.IP) && !x.Contains(n.IP) {
+		return []*net.IPNet{n}
+	}
+	if xOnes <= nOnes {
+		// x contains n entirely
+		return nil
+	}
+
+	// Split n in halves and subtract x from each of them.
+	lowIP := binary.BigEndian.Uint32(n.IP.To4())
+	highIP := lowIP | 1<<uint(31-nOnes)
+	mask := net.CIDRMask(nOnes+1, 32)
+	var result []*net.IPNet
+	for _, ip := range []uint32{lowIP, highIP} {
+		half := &net.IPNet{IP: make(net.IP, 4), Mask: mask}
+		binary.BigEndian.PutUint32(half.IP, ip)
+		result = append(result, subtractNetwork(half, x)...)
+	}
+	return result
+}
@@ -0,0 +1,210 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package hcnproxyctrl
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/Microsoft/hcsshim/hcn"
+)
+
+func TestExcludeAddresses(t *testing.T) {
+	tests := []struct {
+		addresses string
+		excluded  []string
+		want      string
+	}{
+		{
+			addresses: "10.0.0.0/8",
+			excluded:  []string{"10.0.0.0/9"},
+			want:      "10.128.0.0/9",
+		},
+		{
+			addresses: "10.0.0.0/8, 192.168.1.1",
+			excluded:  LocalTrafficCIDRs,
+			want:      "10.0.0.0/8,192.168.1.1/32",
+		},
+		{
+			addresses: "127.0.0.0/7",
+			excluded:  LocalTrafficCIDRs,
+			want:      "126.0.0.0/8",
+		},
+		{
+			// IPv6 entries are still proxied.
+			addresses: "192.0.0.0/2,fd00::/8",
+			excluded:  LocalTrafficCIDRs,
+			want:      "192.0.0.0/2,fd00::/8",
+		},
+	}
+	for _, test := range tests {
+		got, err := ExcludeAddresses(test.addresses, test.excluded)
+		if err != nil || got != test.want {
+			t.Errorf("ExcludeAddresses(%q, %q) = %q, %v, want %q", test.addresses, test.excluded, got, err, test.want)
+		}
+	}
+}
+
+func TestExcludeAddressesFromAllIPv4(t *testing.T) {
+	got, err := ExcludeAddresses("0.0.0.0/0", LocalTrafficCIDRs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, address := range []string{"127.0.0.1", "169.254.169.254"} {
+		if addressInList(t, address, got) {
+			t.Errorf("ExcludeAddresses(0.0.0.0/0) = %s, which covers the excluded %s", got, address)
+		}
+	}
+	for _, address := range []string{"0.0.0.1", "10.0.0.1", "126.255.255.255", "128.0.0.0", "169.253.255.255", "169.255.0.0", "255.255.255.255"} {
+		if !addressInList(t, address, got) {
+			t.Errorf("ExcludeAddresses(0.0.0.0/0) = %s, which does not cover %s", got, address)
+		}
+	}
+}
+
+func TestExcludeAddressesInvalid(t *testing.T) {
+	// Restricting an unset address list to IPv4 ranges would stop proxying
+	// the IPv6 traffic, and excluding from IPv6 ranges only is a no-op.
+	for _, addresses := range []string{"", "::/0", "fd00::1,fd00::/8"} {
+		if got, err := ExcludeAddresses(addresses, LocalTrafficCIDRs); err == nil {
+			t.Errorf("ExcludeAddresses(%q) = %q, want an error", addresses, got)
+		}
+	}
+	if got, err := ExcludeAddresses("127.0.0.1", LocalTrafficCIDRs); err == nil {
+		t.Errorf("ExcludeAddresses excluding all the addresses = %q, want an error rather than a list matching all of them", got)
+	}
+	if got, err := ExcludeAddresses("0.0.0.0/0", []string{"::1/128"}); err == nil {
+		t.Errorf("ExcludeAddresses excluding an IPv6 range = %q, want an error", got)
+	}
+}
+
+// addressInList returns whether the IP is in one of the comma-separated CIDRs.
+func addressInList(t *testing.T, address string, cidrs string) bool {
+	t.Helper()
+	for _, cidr := range strings.Split(cidrs, ",") {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			t.Fatalf("invalid CIDR %q: %v", cidr, err)
+		}
+		if network.Contains(net.ParseIP(address)) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestValidateAddresses(t *testing.T) {
+	tests := []struct {
+		name string
+		list string
+		err  string
+	}{
+		{name: "IP", list: "10.0.0.1"},
+		{name: "IPs and CIDRs", list: "10.0.0.1, 10.1.0.0/16,fd00::1,fd01::/64"},
+		{
+			name: "invalid entry",
+			list: "10.0.0.1,bogus,10.0.0.0/33",
+			err:  `entry 2 ("bogus") is neither an IP address nor a CIDR`,
+		},
+		{
+			name: "invalid prefix length",
+			list: "10.0.0.1, 10.0.0.0/33",
+			err:  `entry 2 ("10.0.0.0/33") is neither an IP address nor a CIDR`,
+		},
+		{name: "empty entry", list: "10.0.0.1,,10.0.0.2", err: "entry 2 is empty"},
+		{name: "trailing comma", list: "10.0.0.1,", err: "entry 2 is empty"},
+		{name: "empty list", list: "", err: "entry 1 is empty"},
+	}
+	for _, test := range tests {
+		err := validateAddresses(test.list)
+		if len(test.err) == 0 && err != nil {
+			t.Errorf("%s: validateAddresses(%q) error %v", test.name, test.list, err)
+		}
+		if len(test.err) > 0 && (err == nil || err.Error() != test.err) {
+			t.Errorf("%s: validateAddresses(%q) error %v, want %q", test.name, test.list, err, test.err)
+		}
+	}
+}
+
+func TestValidateAddressFamilies(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy Policy
+		err    string
+	}{
+		{name: "no address", policy: Policy{}},
+		{name: "IPv4 only", policy: Policy{LocalAddresses: "10.0.0.1", RemoteAddresses: "10.1.0.0/16, 192.168.1.1"}},
+		{name: "IPv6 only", policy: Policy{LocalAddresses: "fd00::1", RemoteAddresses: "fd01::/64"}},
+		{
+			name:   "mixed in a list",
+			policy: Policy{RemoteAddresses: "10.0.0.1,fd00::/8"},
+			err:    "policy mixes IPv4 address 10.0.0.1 and IPv6 address fd00::/8",
+		},
+		{
+			name:   "mixed across lists",
+			policy: Policy{LocalAddresses: "fd00::1", RemoteAddresses: "10.0.0.0/8"},
+			err:    "policy mixes IPv6 address fd00::1 and IPv4 address 10.0.0.0/8",
+		},
+	}
+	for _, test := range tests {
+		err := validateAddressFamilies(test.policy)
+		if len(test.err) == 0 && err != nil {
+			t.Errorf("%s: validateAddressFamilies error %v", test.name, err)
+		}
+		if len(test.err) > 0 && (err == nil || err.Error() != test.err) {
+			t.Errorf("%s: validateAddressFamilies error %v, want %q", test.name, err, test.err)
+		}
+	}
+}
+
+func TestCheckAddressFamilies(t *testing.T) {
+	tests := []struct {
+		name      string
+		addresses []string
+		policy    Policy
+		err       string
+	}{
+		{name: "endpoint without addresses", policy: Policy{RemoteAddresses: "fd00::/8"}},
+		{name: "policy without addresses", addresses: []string{"10.0.0.2"}, policy: Policy{}},
+		{name: "IPv4", addresses: []string{"10.0.0.2"}, policy: Policy{LocalAddresses: "10.0.0.2", RemoteAddresses: "10.1.0.0/16"}},
+		{name: "dual-stack", addresses: []string{"10.0.0.2", "fd00::2"}, policy: Policy{RemoteAddresses: "fd01::/64"}},
+		{
+			name:      "IPv6 policy on an IPv4 endpoint",
+			addresses: []string{"10.0.0.2"},
+			policy:    Policy{RemoteAddresses: "fd00::/8"},
+			err:       "policy address fd00::/8 is IPv6 but the endpoint has no IPv6 address (10.0.0.2)",
+		},
+		{
+			name:      "IPv4 policy on an IPv6 endpoint",
+			addresses: []string{"fd00::2", "fd00::3"},
+			policy:    Policy{LocalAddresses: " 10.0.0.2"},
+			err:       "policy address 10.0.0.2 is IPv4 but the endpoint has no IPv4 address (fd00::2, fd00::3)",
+		},
+	}
+	for _, test := range tests {
+		err := checkAddressFamilies(test.addresses, test.policy)
+		if len(test.err) == 0 && err != nil {
+			t.Errorf("%s: checkAddressFamilies error %v", test.name, err)
+		}
+		if len(test.err) > 0 && (err == nil || err.Error() != test.err) {
+			t.Errorf("%s: checkAddressFamilies error %v, want %q", test.name, err, test.err)
+		}
+	}
+}
+
+func TestCheckEndpointAddressFamilies(t *testing.T) {
+	endpoint := hcn.HostComputeEndpoint{Id: "ep", IpConfigurations: []hcn.IpConfig{{IpAddress: "10.0.0.2"}}}
+	newFakeHNS(t, endpoint)
+
+	if err := CheckEndpointAddressFamilies("ep", Policy{RemoteAddresses: "10.1.0.0/16"}); err != nil {
+		t.Errorf("CheckEndpointAddressFamilies error %v", err)
+	}
+	if err := CheckEndpointAddressFamilies("ep", Policy{RemoteAddresses: "fd00::/8"}); err == nil {
+		t.Error("CheckEndpointAddressFamilies accepted an IPv6 policy on an IPv4 endpoint")
+	}
+	if err := CheckEndpointAddressFamilies("missing", Policy{}); !hcn.IsNotFoundError(err) {
+		t.Errorf("CheckEndpointAddressFamilies error %v, want not found", err)
+	}
+}
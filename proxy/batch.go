@@ -0,0 +1,94 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package hcnproxyctrl
+
+import (
+	"fmt"
+
+	"github.com/Microsoft/hcsshim/hcn"
+)
+
+// PolicyError is an error about one of several policies given together.
+type PolicyError struct {
+	// Position of the policy in the list, from 0.
+	Index int
+	Err   error
+}
+
+func (e *PolicyError) Error() string {
+	return fmt.Sprintf("policy %d: %v", e.Index, e.Err)
+}
+
+func (e *PolicyError) Unwrap() error {
+	return e.Err
+}
+
+// renderPolicies renders each of the policies (see RenderPolicy). If a single
+// policy is given, its error is returned as is; otherwise, the errors of all
+// the invalid policies are returned together in a *ValidationError.
+func renderPolicies(policies []Policy) ([]hcn.EndpointPolicy, error) {
+	var (
+		endpointPolicies []hcn.EndpointPolicy
+		problems         []error
+	)
+	for i, policy := range policies {
+		endpointPolicy, err := RenderPolicy(policy)
+		if err != nil {
+			if len(policies) == 1 {
+				return nil, err
+			}
+			problems = append(problems, &PolicyError{Index: i, Err: err})
+			continue
+		}
+		endpointPolicies = append(endpointPolicies, endpointPolicy)
+	}
+	if len(problems) > 0 {
+		return nil, &ValidationError{Problems: problems}
+	}
+	return endpointPolicies, nil
+}
+
+// rollbackAdd removes the policies that HNS applied from a request adding the
+// given ones, which failed with cause, given the policies the endpoint had
+// before the request. It returns cause, as a *PolicyError naming the first
+// policy that was not applied if it can be told.
+func rollbackAdd(hnsEndpointID string, existing []hcn.EndpointPolicy, added []hcn.EndpointPolicy, cause error) error {
+	current, err := listPolicies(hnsEndpointID)
+	if err != nil {
+		return fmt.Errorf("%v (could not check which policies to roll back: %v)", cause, err)
+	}
+
+	remaining := make(map[Policy]int)
+	for _, hcnPolicy := range existing {
+		remaining[hcnPolicyToAPIPolicy(hcnPolicy)]++
+	}
+	var applied []hcn.EndpointPolicy
+	appliedCounts := make(map[Policy]int)
+	for _, hcnPolicy := range current {
+		policy := hcnPolicyToAPIPolicy(hcnPolicy)
+		if remaining[policy] > 0 {
+			remaining[policy]--
+			continue
+		}
+		applied = append(applied, hcnPolicy)
+		appliedCounts[policy]++
+	}
+
+	err = cause
+	for i, hcnPolicy := range added {
+		policy := hcnPolicyToAPIPolicy(hcnPolicy)
+		if appliedCounts[policy] == 0 {
+			err = &PolicyError{Index: i, Err: cause}
+			break
+		}
+		appliedCounts[policy]--
+	}
+
+	if len(applied) > 0 {
+		if rollbackErr := removePolicies(hnsEndpointID, applied); rollbackErr != nil {
+			return fmt.Errorf("%v (removing the %d policies applied also failed: %v)", err, len(applied), rollbackErr)
+		}
+	}
+	return err
+}
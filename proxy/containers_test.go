@@ -0,0 +1,85 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package hcnproxyctrl
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/Microsoft/hcsshim/hcn"
+	cri "github.com/microsoft/hcnproxyctrl/cri"
+)
+
+// setCRIContainers replaces CRI by a fake listing the given containers for the
+// duration of the test.
+func setCRIContainers(t *testing.T, containers ...cri.ContainerInfo) {
+	previous := criListContainers
+	criListContainers = func(cri.CriParameters) ([]cri.ContainerInfo, error) {
+		return append([]cri.ContainerInfo(nil), containers...), nil
+	}
+	t.Cleanup(func() { criListContainers = previous })
+}
+
+// namespacedEndpoint returns an endpoint attached to a network namespace.
+func namespacedEndpoint(id, namespaceID string) hcn.HostComputeEndpoint {
+	return hcn.HostComputeEndpoint{Id: id, HostComputeNamespace: namespaceID}
+}
+
+func TestGetEndpointsForContainers(t *testing.T) {
+	newFakeHNS(t,
+		namespacedEndpoint("ep1", "ns1"),
+		namespacedEndpoint("ep2", "ns2"),
+		namespacedEndpoint("ep3", "ns2"),
+	)
+	setCRIContainers(t,
+		cri.ContainerInfo{ContainerId: "c1", NamespaceId: "ns1"},
+		cri.ContainerInfo{ContainerId: "c2", NamespaceId: "ns2"},
+		cri.ContainerInfo{ContainerId: "detached", NamespaceId: "ns3"},
+	)
+
+	tests := []struct {
+		name         string
+		containerIDs []string
+		endpoints    map[string][]string
+		unresolved   []string
+	}{
+		{
+			name:         "all resolved",
+			containerIDs: []string{"c1", "c2"},
+			endpoints:    map[string][]string{"c1": {"ep1"}, "c2": {"ep2", "ep3"}},
+		},
+		{
+			name:         "unknown and detached containers",
+			containerIDs: []string{"c1", "unknown", "detached"},
+			endpoints:    map[string][]string{"c1": {"ep1"}},
+			unresolved:   []string{"unknown", "detached"},
+		},
+		{
+			name:         "none resolved",
+			containerIDs: []string{"unknown"},
+			endpoints:    map[string][]string{},
+			unresolved:   []string{"unknown"},
+		},
+	}
+	for _, test := range tests {
+		endpoints, err := GetEndpointsForContainers(test.containerIDs, "")
+		for _, endpointIDs := range endpoints {
+			sort.Strings(endpointIDs)
+		}
+		if !reflect.DeepEqual(endpoints, test.endpoints) {
+			t.Errorf("%s: GetEndpointsForContainers = %v, want %v", test.name, endpoints, test.endpoints)
+		}
+		var unresolvedErr *UnresolvedContainersError
+		switch {
+		case test.unresolved == nil && err != nil:
+			t.Errorf("%s: GetEndpointsForContainers error %v", test.name, err)
+		case test.unresolved != nil && !errors.As(err, &unresolvedErr):
+			t.Errorf("%s: GetEndpointsForContainers error %v, want an *UnresolvedContainersError", test.name, err)
+		case test.unresolved != nil && !reflect.DeepEqual(unresolvedErr.ContainerIDs, test.unresolved):
+			t.Errorf("%s: unresolved containers %v, want %v", test.name, unresolvedErr.ContainerIDs, test.unresolved)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package hcnproxyctrl
+
+import (
+	"context"
+
+	"github.com/Microsoft/hcsshim/hcn"
+)
+
+// The functions of this file honor the cancellation and deadline of their
+// context: it is checked between the HNS calls they make, so that no further
+// call is started once it is done. HNS calls cannot be interrupted though:
+// when the context is done during one, they return its error right away while
+// the call runs to completion in the background, so the change may still be
+// made. The functions without a context use context.Background().
+
+// AddPolicyContext is like AddPolicy, but returns ctx.Err() as soon as the
+// context is done. The policy may still be added in that case.
+func AddPolicyContext(ctx context.Context, hnsEndpointID string, policy Policy) error {
+	return runContext(ctx, func() error {
+		return applyPolicyRequest(ctx, hnsEndpointID, hcn.RequestTypeAdd, []Policy{policy})
+	})
+}
+
+// ListPoliciesContext is like ListPolicies, but returns ctx.Err() as soon as
+// the context is done.
+func ListPoliciesContext(ctx context.Context, hnsEndpointID string) ([]Policy, error) {
+	var policies []Policy
+	err := runContext(ctx, func() (err error) {
+		policies, err = listAPIPolicies(hnsEndpointID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return policies, nil
+}
+
+// ClearPoliciesContext is like ClearPolicies, but returns ctx.Err() as soon as
+// the context is done. The policies may still be removed in that case, but
+// the number of policies removed is then unknown and reported as zero.
+func ClearPoliciesContext(ctx context.Context, hnsEndpointID string) (numRemoved int, err error) {
+	var report ClearReport
+	err = runContext(ctx, func() (err error) {
+		report, err = clearPoliciesWithReport(ctx, hnsEndpointID, PolicyFilter{})
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return report.Removed, nil
+}
+
+// runContext runs fn in the background and returns its error, or ctx.Err() if
+// the context is done first. fn is not started if the context is already done.
+// The variables fn sets must only be read if runContext returns nil.
+func runContext(ctx context.Context, fn func() error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- fn()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package hcnproxyctrl
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Microsoft/hcsshim/hcn"
+)
+
+func TestContextVariants(t *testing.T) {
+	newFakeHNS(t, proxyEndpoint(t, "ep", Policy{ProxyPort: "15001"}))
+	ctx := context.Background()
+
+	if err := AddPolicyContext(ctx, "ep", Policy{ProxyPort: "15002"}); err != nil {
+		t.Fatal(err)
+	}
+	policies, err := ListPoliciesContext(ctx, "ep")
+	if err != nil || len(policies) != 2 {
+		t.Fatalf("ListPoliciesContext = %+v, %v, want 2 policies", policies, err)
+	}
+	if removed, err := ClearPoliciesContext(ctx, "ep"); err != nil || removed != 2 {
+		t.Errorf("ClearPoliciesContext = %d, %v, want 2 policies removed", removed, err)
+	}
+}
+
+func TestContextDoneBeforehand(t *testing.T) {
+	fake := newFakeHNS(t, proxyEndpoint(t, "ep", Policy{ProxyPort: "15001"}))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := AddPolicyContext(ctx, "ep", Policy{ProxyPort: "15002"}); err != context.Canceled {
+		t.Errorf("AddPolicyContext error %v, want %v", err, context.Canceled)
+	}
+	if _, err := ListPoliciesContext(ctx, "ep"); err != context.Canceled {
+		t.Errorf("ListPoliciesContext error %v, want %v", err, context.Canceled)
+	}
+	if _, err := ClearPoliciesContext(ctx, "ep"); err != context.Canceled {
+		t.Errorf("ClearPoliciesContext error %v, want %v", err, context.Canceled)
+	}
+	if len(fake.requests) > 0 {
+		t.Errorf("requests %+v made with a done context", fake.requests)
+	}
+}
+
+func TestContextCheckedBetweenHNSCalls(t *testing.T) {
+	fake := newFakeHNS(t, proxyEndpoint(t, "ep", Policy{ProxyPort: "15001"}))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Cancel the context while ClearPoliciesContext lists the policies to
+	// remove.
+	getEndpoint := getEndpointByID
+	getEndpointByID = func(endpointID string) (*hcn.HostComputeEndpoint, error) {
+		cancel()
+		return getEndpoint(endpointID)
+	}
+
+	if _, err := ClearPoliciesContext(ctx, "ep"); err != context.Canceled {
+		t.Errorf("ClearPoliciesContext error %v, want %v", err, context.Canceled)
+	}
+	// Wait for the clear to complete in the background.
+	lockEndpoint("ep")()
+
+	if len(fake.requests) > 0 {
+		t.Errorf("ClearPoliciesContext made requests %+v after the context was done", fake.requests)
+	}
+	if policies := mustListPolicies(t, "ep"); len(policies) != 1 {
+		t.Errorf("policies %+v, want the policy kept", policies)
+	}
+}
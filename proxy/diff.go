@@ -0,0 +1,83 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package hcnproxyctrl
+
+// PolicyChange is a policy that only differs from another in its proxy port
+// or priority, ie. that intercepts the same traffic differently.
+type PolicyChange struct {
+	From Policy `json:"from"`
+	To   Policy `json:"to"`
+}
+
+// PolicyDiff lists the differences between two sets of policies.
+type PolicyDiff struct {
+	Added   []Policy       `json:"added"`
+	Removed []Policy       `json:"removed"`
+	Changed []PolicyChange `json:"changed"`
+}
+
+// Empty returns true iff the sets of policies are equivalent.
+func (diff PolicyDiff) Empty() bool {
+	return len(diff.Added) == 0 && len(diff.Removed) == 0 && len(diff.Changed) == 0
+}
+
+// DiffPolicies returns the differences between the policies from and to.
+// Policies are compared by Key, so equivalent policies are identical. The
+// policies of from that have a counterpart in to intercepting the same
+// traffic are reported as changed, the others as removed; the remaining
+// policies of to are reported as added.
+func DiffPolicies(from, to []Policy) PolicyDiff {
+	toKeys := make(map[string]bool, len(to))
+	for _, policy := range to {
+		toKeys[policy.Key()] = true
+	}
+	fromKeys := make(map[string]bool, len(from))
+	for _, policy := range from {
+		fromKeys[policy.Key()] = true
+	}
+
+	// Index the policies only found in to by the traffic they intercept
+	var added []Policy
+	bySelector := make(map[Policy][]int)
+	for _, policy := range to {
+		if fromKeys[policy.Key()] {
+			continue
+		}
+		added = append(added, policy)
+		selector := trafficSelector(policy)
+		bySelector[selector] = append(bySelector[selector], len(added)-1)
+	}
+
+	var diff PolicyDiff
+	paired := make(map[int]bool)
+	for _, policy := range from {
+		if toKeys[policy.Key()] {
+			continue
+		}
+		selector := trafficSelector(policy)
+		if candidates := bySelector[selector]; len(candidates) > 0 {
+			bySelector[selector] = candidates[1:]
+			paired[candidates[0]] = true
+			diff.Changed = append(diff.Changed, PolicyChange{From: policy, To: added[candidates[0]]})
+			continue
+		}
+		diff.Removed = append(diff.Removed, policy)
+	}
+	for i, policy := range added {
+		if !paired[i] {
+			diff.Added = append(diff.Added, policy)
+		}
+	}
+
+	return diff
+}
+
+// trafficSelector returns the canonical form of the policy without its proxy
+// port and priority, which only identifies the traffic it intercepts.
+func trafficSelector(policy Policy) Policy {
+	policy = NormalizePolicy(policy)
+	policy.ProxyPort = ""
+	policy.Priority = 0
+	return policy
+}
@@ -0,0 +1,79 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package hcnproxyctrl
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiffPolicies(t *testing.T) {
+	http := Policy{ProxyPort: "15001", RemotePorts: "80", Protocol: "6"}
+	https := Policy{ProxyPort: "15001", RemotePorts: "443", Protocol: "6"}
+	dns := Policy{ProxyPort: "15053", RemotePorts: "53", Protocol: "17"}
+
+	httpOtherPort := http
+	httpOtherPort.ProxyPort = "15002"
+	httpsPrioritized := https
+	httpsPrioritized.Priority = 100
+	// Equivalent to http once normalized
+	httpEquivalent := Policy{ProxyPort: "15001", RemotePorts: "80", Protocol: "tcp"}
+
+	tests := []struct {
+		name     string
+		from, to []Policy
+		want     PolicyDiff
+	}{
+		{
+			name: "identical",
+			from: []Policy{http, https},
+			to:   []Policy{https, httpEquivalent},
+			want: PolicyDiff{},
+		},
+		{
+			name: "added",
+			from: []Policy{http},
+			to:   []Policy{http, https, dns},
+			want: PolicyDiff{Added: []Policy{https, dns}},
+		},
+		{
+			name: "removed",
+			from: []Policy{http, https, dns},
+			to:   []Policy{https},
+			want: PolicyDiff{Removed: []Policy{http, dns}},
+		},
+		{
+			name: "changed by selector",
+			from: []Policy{http, https, dns},
+			to:   []Policy{httpOtherPort, httpsPrioritized, dns},
+			want: PolicyDiff{Changed: []PolicyChange{
+				{From: http, To: httpOtherPort},
+				{From: https, To: httpsPrioritized},
+			}},
+		},
+		{
+			name: "added, removed and changed",
+			from: []Policy{http, dns},
+			to:   []Policy{httpOtherPort, https},
+			want: PolicyDiff{
+				Added:   []Policy{https},
+				Removed: []Policy{dns},
+				Changed: []PolicyChange{{From: http, To: httpOtherPort}},
+			},
+		},
+		{
+			name: "empty sets",
+			want: PolicyDiff{},
+		},
+	}
+	for _, test := range tests {
+		diff := DiffPolicies(test.from, test.to)
+		if !reflect.DeepEqual(diff, test.want) {
+			t.Errorf("%s: DiffPolicies = %+v, want %+v", test.name, diff, test.want)
+		}
+		if diff.Empty() != test.want.Empty() {
+			t.Errorf("%s: Empty() = %v, want %v", test.name, diff.Empty(), test.want.Empty())
+		}
+	}
+}
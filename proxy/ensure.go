@@ -0,0 +1,80 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package hcnproxyctrl
+
+import (
+	"context"
+	"time"
+)
+
+// EnsurePolicy adds the policy to the endpoint, then checks at every interval
+// that it is still applied and adds it back if it is not, until ctx is done.
+// This protects the policy from being removed by other actors.
+// An error is returned if the policy could not be added initially; later
+// failures, as well as each time the policy is added back, are reported
+// through logf and do not stop the loop. EnsurePolicy returns nil once ctx
+// is done.
+// If added is not nil, it is called each time the policy was added, initially
+// and when added back, eg. to record the change. Its error is returned after
+// the initial addition, and reported through logf afterwards.
+func EnsurePolicy(ctx context.Context, hnsEndpointID string, policy Policy, interval time.Duration, logf func(format string, args ...interface{}), added func() error) error {
+	effective, err := EffectivePolicy(policy)
+	if err != nil {
+		return err
+	}
+	key := effective.Key()
+
+	if err := AddPolicy(hnsEndpointID, policy); err != nil {
+		return err
+	}
+	if added != nil {
+		if err := added(); err != nil {
+			return err
+		}
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		applied, err := hasPolicy(hnsEndpointID, key)
+		if err != nil {
+			logf("could not check the policy: %v", err)
+			continue
+		}
+		if applied {
+			continue
+		}
+
+		if err := AddPolicy(hnsEndpointID, policy); err != nil {
+			logf("could not add the missing policy back: %v", err)
+			continue
+		}
+		logf("added the missing policy back")
+		if added != nil {
+			if err := added(); err != nil {
+				logf("%v", err)
+			}
+		}
+	}
+}
+
+// hasPolicy returns true iff the endpoint has a proxy policy with the given key.
+func hasPolicy(hnsEndpointID string, key string) (bool, error) {
+	policies, err := ListPolicies(hnsEndpointID)
+	if err != nil {
+		return false, err
+	}
+	for _, policy := range policies {
+		if policy.Key() == key {
+			return true, nil
+		}
+	}
+	return false, nil
+}
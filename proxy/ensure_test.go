@@ -0,0 +1,66 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package hcnproxyctrl
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Microsoft/hcsshim/hcn"
+)
+
+func TestEnsurePolicyReportsAdditions(t *testing.T) {
+	newFakeHNS(t, hcn.HostComputeEndpoint{Id: "ep"})
+	policy := Policy{ProxyPort: "15001"}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	additions := make(chan struct{}, 10)
+	done := make(chan error, 1)
+	go func() {
+		logf := func(format string, args ...interface{}) {}
+		done <- EnsurePolicy(ctx, "ep", policy, time.Millisecond, logf, func() error {
+			additions <- struct{}{}
+			return nil
+		})
+	}()
+
+	waitAddition := func(what string) {
+		select {
+		case <-additions:
+		case err := <-done:
+			t.Fatalf("EnsurePolicy returned %v before the %s", err, what)
+		case <-time.After(10 * time.Second):
+			t.Fatalf("the %s was not reported", what)
+		}
+	}
+	waitAddition("initial addition")
+	if _, err := ClearPolicies("ep"); err != nil {
+		t.Fatal(err)
+	}
+	waitAddition("addition back")
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("EnsurePolicy error %v once interrupted", err)
+	}
+	if policies := mustListPolicies(t, "ep"); len(policies) != 1 {
+		t.Errorf("endpoint has policies %+v, want the ensured one", policies)
+	}
+}
+
+func TestEnsurePolicyInitialAdditionNotRecorded(t *testing.T) {
+	newFakeHNS(t, hcn.HostComputeEndpoint{Id: "ep"})
+	recordErr := errors.New("could not write the audit log")
+
+	logf := func(format string, args ...interface{}) {}
+	err := EnsurePolicy(context.Background(), "ep", Policy{ProxyPort: "15001"}, time.Millisecond, logf, func() error {
+		return recordErr
+	})
+	if err != recordErr {
+		t.Errorf("EnsurePolicy error %v, want the error recording the initial addition", err)
+	}
+}
@@ -0,0 +1,53 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package hcnproxyctrl
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Explain returns a sentence describing the traffic the policy redirects to
+// the proxy, eg. "Redirects TCP traffic from any local address and port to
+// 10.0.0.0/16 on ports 80-443 to the proxy on port 15001, except traffic
+// from user SID S-1-5-18 (priority 0)."
+func (policy Policy) Explain() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Redirects %s traffic from %s to %s to the proxy on port %s",
+		protocolLabel(policy.Protocol),
+		explainEndpoint(policy.LocalAddresses, policy.LocalPorts, "local"),
+		explainEndpoint(policy.RemoteAddresses, policy.RemotePorts, "remote"),
+		policy.ProxyPort)
+	if len(policy.UserSID) > 0 {
+		fmt.Fprintf(&b, ", except traffic from user SID %s", policy.UserSID)
+	}
+	fmt.Fprintf(&b, " (priority %d).", policy.Priority)
+	return b.String()
+}
+
+// explainEndpoint describes one end of the traffic matched by the address
+// and port filters.
+func explainEndpoint(addresses, ports, side string) string {
+	switch {
+	case len(addresses) == 0 && len(ports) == 0:
+		return "any " + side + " address and port"
+	case len(addresses) == 0:
+		return "any " + side + " address on ports " + ports
+	case len(ports) == 0:
+		return addresses + " on any port"
+	}
+	return addresses + " on ports " + ports
+}
+
+// protocolLabel returns the name of an IANA protocol number if it is well
+// known, or the number itself otherwise.
+func protocolLabel(protocol string) string {
+	switch protocol {
+	case "", "6":
+		return "TCP"
+	case "17":
+		return "UDP"
+	}
+	return "protocol " + protocol
+}
@@ -0,0 +1,57 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package hcnproxyctrl
+
+import "strings"
+
+// PolicyFilter selects proxy policies by the value of their fields.
+// A policy matches the filter if each non-empty field of the filter is
+// equal to the corresponding field of the policy, both being compared in
+// canonical form (see NormalizePolicy): eg. a Protocol of "udp" selects the
+// policies HNS reports with protocol 17, and a UserSID of "system" the ones
+// with LocalSystemSID. The zero value matches every policy.
+type PolicyFilter struct {
+	ProxyPort       string
+	UserSID         string
+	LocalAddresses  string
+	RemoteAddresses string
+	LocalPorts      string
+	RemotePorts     string
+	Protocol        string
+}
+
+// Matches returns true iff the given policy is selected by the filter.
+func (f PolicyFilter) Matches(policy Policy) bool {
+	f = f.normalize()
+	policy = NormalizePolicy(policy)
+	return matchField(f.ProxyPort, policy.ProxyPort) &&
+		matchField(f.UserSID, policy.UserSID) &&
+		matchField(f.LocalAddresses, policy.LocalAddresses) &&
+		matchField(f.RemoteAddresses, policy.RemoteAddresses) &&
+		matchField(f.LocalPorts, policy.LocalPorts) &&
+		matchField(f.RemotePorts, policy.RemotePorts) &&
+		matchField(f.Protocol, policy.Protocol)
+}
+
+// normalize returns the filter with its non-empty fields in the canonical
+// form of NormalizePolicy, and its user SID shorthand resolved.
+func (f PolicyFilter) normalize() PolicyFilter {
+	f.LocalAddresses = normalizeAddresses(f.LocalAddresses)
+	f.RemoteAddresses = normalizeAddresses(f.RemoteAddresses)
+	if len(f.Protocol) > 0 {
+		if number, err := ParseProtocol(f.Protocol); err == nil {
+			f.Protocol = number
+		}
+	}
+	if sid, ok := sidShorthands[strings.ToLower(f.UserSID)]; ok {
+		f.UserSID = sid
+	}
+	f.UserSID = strings.ToUpper(f.UserSID)
+	return f
+}
+
+// matchField returns true if the filter value is empty or equal to value.
+func matchField(filter, value string) bool {
+	return len(filter) == 0 || filter == value
+}
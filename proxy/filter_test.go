@@ -0,0 +1,70 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package hcnproxyctrl
+
+import "testing"
+
+func TestPolicyFilterMatches(t *testing.T) {
+	// As reported by HNS
+	policy := Policy{
+		ProxyPort:       "15001",
+		UserSID:         LocalSystemSID,
+		RemoteAddresses: "10.0.0.2,10.0.0.1",
+		RemotePorts:     "80",
+		Protocol:        "17",
+	}
+
+	tests := []struct {
+		name    string
+		filter  PolicyFilter
+		matches bool
+	}{
+		{"zero value", PolicyFilter{}, true},
+		{"proxy port", PolicyFilter{ProxyPort: "15001"}, true},
+		{"other proxy port", PolicyFilter{ProxyPort: "15002"}, false},
+		{"protocol number", PolicyFilter{Protocol: "17"}, true},
+		{"protocol name", PolicyFilter{Protocol: "udp"}, true},
+		{"uppercase protocol name", PolicyFilter{Protocol: "UDP"}, true},
+		{"other protocol name", PolicyFilter{Protocol: "tcp"}, false},
+		{"unknown protocol", PolicyFilter{Protocol: "icmp"}, false},
+		{"user SID", PolicyFilter{UserSID: "s-1-5-18"}, true},
+		{"user SID shorthand", PolicyFilter{UserSID: "System"}, true},
+		{"other user SID shorthand", PolicyFilter{UserSID: "networkservice"}, false},
+		{"addresses in another order", PolicyFilter{RemoteAddresses: "10.0.0.1, 10.0.0.2"}, true},
+		{"subset of the addresses", PolicyFilter{RemoteAddresses: "10.0.0.1"}, false},
+		{"several fields", PolicyFilter{ProxyPort: "15001", RemotePorts: "80", Protocol: "udp"}, true},
+		{"one field differing", PolicyFilter{ProxyPort: "15001", RemotePorts: "443", Protocol: "udp"}, false},
+	}
+	for _, test := range tests {
+		if matches := test.filter.Matches(policy); matches != test.matches {
+			t.Errorf("%s: %+v.Matches = %v, want %v", test.name, test.filter, matches, test.matches)
+		}
+	}
+}
+
+func TestPolicyFilterDefaultProtocol(t *testing.T) {
+	policy := Policy{ProxyPort: "15001"}
+	if !(PolicyFilter{Protocol: "tcp"}).Matches(policy) {
+		t.Error("a policy without protocol does not match a tcp filter")
+	}
+	if (PolicyFilter{Protocol: "udp"}).Matches(policy) {
+		t.Error("a policy without protocol matches a udp filter")
+	}
+}
+
+func TestClearPoliciesMatchingProtocolName(t *testing.T) {
+	newFakeHNS(t, proxyEndpoint(t, "ep",
+		Policy{ProxyPort: "15001", Protocol: "tcp"},
+		Policy{ProxyPort: "15001", Protocol: "udp"},
+	))
+
+	removed, err := ClearPoliciesMatching("ep", PolicyFilter{Protocol: "udp"})
+	if err != nil || removed != 1 {
+		t.Fatalf("ClearPoliciesMatching = %d, %v, want 1", removed, err)
+	}
+	remaining := mustListPolicies(t, "ep")
+	if len(remaining) != 1 || remaining[0].Protocol != "6" {
+		t.Errorf("remaining policies %+v, want the TCP one", remaining)
+	}
+}
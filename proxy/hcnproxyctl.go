@@ -3,10 +3,14 @@
 package hcnproxyctrl
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
-	"strconv"
+	"fmt"
+	"net"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/Microsoft/hcsshim/hcn"
 	cri "github.com/microsoft/hcnproxyctrl/cri"
@@ -24,31 +28,80 @@ const LocalSystemSID = "S-1-5-18"
 // intercepted by the proxy.
 type Policy struct {
 	// The port the proxy is listening on. (Required)
-	ProxyPort string
+	ProxyPort string `json:"proxyPort"`
 
 	// Ignore traffic originating from the specified user SID. (Optional)
-	UserSID string
+	// The shorthands "system", "localsystem", "localservice" and
+	// "networkservice" can be used for the built-in service accounts.
+	UserSID string `json:"userSID"`
 
-	// Only proxy traffic originating from the specified address. (Optional)
-	LocalAddresses string
+	// Only proxy traffic originating from the specified addresses, a
+	// comma-separated list of IPs and CIDRs (eg. "10.0.0.1,10.1.0.0/16"). (Optional)
+	LocalAddresses string `json:"localAddresses"`
 
-	// Only proxy traffic destinated to the specified address. (Optional)
-	RemoteAddresses string
+	// Only proxy traffic destinated to the specified addresses, a
+	// comma-separated list of IPs and CIDRs (eg. "10.0.0.1,10.1.0.0/16"). (Optional)
+	RemoteAddresses string `json:"remoteAddresses"`
 
 	// Only proxy traffic originating from the specified port or port range. (Optional)
-	LocalPorts string
+	LocalPorts string `json:"localPorts"`
 
 	// Only proxy traffic destinated to the specified port or port range. (Optional)
-	RemotePorts string
+	RemotePorts string `json:"remotePorts"`
 
 	// The priority of this policy. (Optional)
 	// For more info, see https://docs.microsoft.com/en-us/windows/win32/fwp/filter-weight-assignment.
-	Priority uint16
+	Priority uint16 `json:"priority"`
 
-	// Only proxy traffic using this protocol. TCP is the only supported
-	// protocol for now, and this field defaults to that if left blank. (Optional)
+	// Only proxy traffic using this protocol, given as an IANA protocol
+	// number. HNS only supports proxy policies for TCP (6) and UDP (17), see
+	// SupportedProtocols, and this field defaults to TCP if left blank. The
+	// names "tcp" and "udp" are also accepted (see ParseProtocol). (Optional)
 	// Ex: 6 = TCP
-	Protocol string
+	Protocol string `json:"protocol"`
+
+	// Only proxy traffic originating from the process with this image path.
+	// The HNS L4WfpProxyPolicySetting has no such condition yet, so policies
+	// setting this field are rejected with ErrImagePathUnsupported. (Optional)
+	ImagePath string `json:"imagePath"`
+}
+
+// ErrImagePathUnsupported is returned when adding a policy that sets
+// ImagePath, which HNS does not support.
+var ErrImagePathUnsupported = errors.New("HNS does not support scoping proxy policies by process image path")
+
+// ErrInvalidProxyPort is wrapped by the problem reported when the proxy port
+// of a policy is missing or is not a valid port number.
+var ErrInvalidProxyPort = errors.New("invalid proxy port")
+
+// ValidationError is returned when a policy is invalid. It lists all the
+// problems found in the policy rather than only the first one, so that they
+// can all be fixed at once. When several policies are validated together,
+// each problem is a *PolicyError holding the ValidationError of a policy.
+type ValidationError struct {
+	Problems []error
+}
+
+func (e *ValidationError) Error() string {
+	if len(e.Problems) == 1 {
+		return e.Problems[0].Error()
+	}
+	messages := make([]string, len(e.Problems))
+	for i, problem := range e.Problems {
+		messages[i] = problem.Error()
+	}
+	return "invalid policy: " + strings.Join(messages, "; ")
+}
+
+// Is reports whether target is, or is wrapped by, one of the problems, so
+// that errors.Is can be used to check for eg. ErrInvalidProxyPort.
+func (e *ValidationError) Is(target error) bool {
+	for _, problem := range e.Problems {
+		if errors.Is(problem, target) {
+			return true
+		}
+	}
+	return false
 }
 
 // AddPolicy adds a layer-4 proxy policy to HNS. The endpointID refers to the
@@ -56,12 +109,135 @@ type Policy struct {
 // An error is returned if the policy passed in argument is invalid, or if it
 // could not be applied for any reason.
 func AddPolicy(hnsEndpointID string, policy Policy) error {
-	if err := validatePolicy(policy); err != nil {
+	return AddPolicyContext(context.Background(), hnsEndpointID, policy)
+}
+
+// AddPolicyIfNotExists is like AddPolicy, but does nothing if the endpoint
+// already has a proxy policy matching the given one (see RemovePolicy). It
+// returns whether the policy was added, so that it can be called repeatedly,
+// eg. by a reconcile loop, without stacking identical policies.
+func AddPolicyIfNotExists(hnsEndpointID string, policy Policy) (added bool, err error) {
+	effective, err := EffectivePolicy(policy)
+	if err != nil {
+		return false, err
+	}
+	endpointPolicies, err := renderPolicies([]Policy{policy})
+	if err != nil {
+		return false, err
+	}
+
+	defer lockEndpoint(hnsEndpointID)()
+
+	hcnPolicies, err := listPolicies(hnsEndpointID)
+	if err != nil {
+		return false, err
+	}
+	for _, hcnPolicy := range hcnPolicies {
+		if matchesEndpointPolicy(effective, hcnPolicy) {
+			return false, nil
+		}
+	}
+
+	event := HookEvent{Operation: "add", EndpointID: hnsEndpointID, Policies: []Policy{policy}}
+	err = withHooks(event, func() error {
+		err := applyRequest(hnsEndpointID, hcn.RequestTypeAdd, endpointPolicies)
+		if err != nil {
+			if supported, checkErr := SupportsProxyPolicy(hnsEndpointID); checkErr == nil && !supported {
+				return ErrProxyNotSupported
+			}
+		}
+		return err
+	})
+	return err == nil, err
+}
+
+// AddPolicies adds several layer-4 proxy policies to HNS in a single request.
+// All the policies are validated first: if any is invalid, no policy is
+// applied and a *ValidationError lists the problems of each invalid policy as
+// a *PolicyError. If the request fails after HNS applied some of the policies,
+// those are removed again and a *PolicyError names the first policy that was
+// not applied, when it can be told.
+func AddPolicies(hnsEndpointID string, policies []Policy) error {
+	return ApplyPolicyRequest(hnsEndpointID, hcn.RequestTypeAdd, policies)
+}
+
+// ApplyPolicyRequest issues a single HNS request of the given type for the
+// proxy policies, eg. RequestTypeUpdate to replace the policies of the
+// endpoint with the given ones. It is the building block of the higher-level
+// functions, and is only needed for request types they do not cover. An
+// error is returned, and no request is issued, if any of the policies is
+// invalid.
+func ApplyPolicyRequest(hnsEndpointID string, requestType hcn.RequestType, policies []Policy) error {
+	return applyPolicyRequest(context.Background(), hnsEndpointID, requestType, policies)
+}
+
+// applyPolicyRequest is ApplyPolicyRequest, checking the context between the
+// HNS calls it makes. A failed addition is still rolled back once the context
+// is done.
+func applyPolicyRequest(ctx context.Context, hnsEndpointID string, requestType hcn.RequestType, policies []Policy) error {
+	defer lockEndpoint(hnsEndpointID)()
+
+	endpointPolicies, err := renderPolicies(policies)
+	if err != nil {
+		return err
+	}
+
+	event := HookEvent{Operation: strings.ToLower(string(requestType)), EndpointID: hnsEndpointID, Policies: policies}
+	return withHooks(event, func() error {
+		// Keep track of the policies already there, to tell which of the
+		// new ones to roll back if the request fails halfway.
+		var existing []hcn.EndpointPolicy
+		rollback := requestType == hcn.RequestTypeAdd && len(endpointPolicies) > 1
+		if rollback {
+			var err error
+			if existing, err = listPolicies(hnsEndpointID); err != nil {
+				return err
+			}
+		}
+
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		err := applyRequest(hnsEndpointID, requestType, endpointPolicies)
+		if err != nil && requestType == hcn.RequestTypeAdd && ctx.Err() == nil {
+			// Replace the cryptic HNS error if the endpoint turns out not to
+			// support proxy policies at all.
+			if supported, checkErr := SupportsProxyPolicy(hnsEndpointID); checkErr == nil && !supported {
+				return ErrProxyNotSupported
+			}
+			if rollback {
+				return rollbackAdd(hnsEndpointID, existing, endpointPolicies, err)
+			}
+		}
 		return err
+	})
+}
+
+// RenderPolicy returns the HNS endpoint policy that AddPolicy would apply for
+// the given policy, without applying it. An error is returned if the policy
+// is invalid.
+func RenderPolicy(policy Policy) (hcn.EndpointPolicy, error) {
+	if err := validatePolicy(policy); err != nil {
+		return hcn.EndpointPolicy{}, err
+	}
+
+	if len(policy.UserSID) > 0 {
+		sid, err := resolveUserSID(policy.UserSID)
+		if err != nil {
+			return hcn.EndpointPolicy{}, err
+		}
+		policy.UserSID = sid
 	}
 
-	// TCP is the default protocol and is the only supported one anyway.
-	policy.Protocol = "6"
+	policy.LocalAddresses = trimAddresses(policy.LocalAddresses)
+	policy.RemoteAddresses = trimAddresses(policy.RemoteAddresses)
+
+	// TCP is the default protocol.
+	if len(policy.Protocol) == 0 {
+		policy.Protocol = "6"
+	} else {
+		policy.Protocol, _ = ParseProtocol(policy.Protocol)
+	}
 
 	policySetting := hcn.L4WfpProxyPolicySetting{
 		Port:    policy.ProxyPort,
@@ -78,29 +254,33 @@ func AddPolicy(hnsEndpointID string, policy Policy) error {
 
 	policyJSON, err := json.Marshal(policySetting)
 	if err != nil {
-		return err
+		return hcn.EndpointPolicy{}, err
 	}
 
-	endpointPolicy := hcn.EndpointPolicy{
+	return hcn.EndpointPolicy{
 		Type:     hcn.L4WFPPROXY,
 		Settings: policyJSON,
-	}
-
-	request := hcn.PolicyEndpointRequest{
-		Policies: []hcn.EndpointPolicy{endpointPolicy},
-	}
+	}, nil
+}
 
-	endpoint, err := hcn.GetEndpointByID(hnsEndpointID)
+// EffectivePolicy returns the policy as it is applied by AddPolicy, eg. with
+// its user SID shorthand resolved and its protocol set.
+func EffectivePolicy(policy Policy) (Policy, error) {
+	endpointPolicy, err := RenderPolicy(policy)
 	if err != nil {
-		return err
+		return Policy{}, err
 	}
-
-	return endpoint.ApplyPolicy(hcn.RequestTypeAdd, request)
+	return hcnPolicyToAPIPolicy(endpointPolicy), nil
 }
 
 // ListPolicies returns the proxy policies that are currently active on the
 // given endpoint.
 func ListPolicies(hnsEndpointID string) ([]Policy, error) {
+	return ListPoliciesContext(context.Background(), hnsEndpointID)
+}
+
+// listAPIPolicies is ListPolicies, without a context.
+func listAPIPolicies(hnsEndpointID string) ([]Policy, error) {
 	hcnPolicies, err := listPolicies(hnsEndpointID)
 	if err != nil {
 		return nil, err
@@ -114,31 +294,420 @@ func ListPolicies(hnsEndpointID string) ([]Policy, error) {
 	return policies, nil
 }
 
+// CountPolicies returns the number of proxy policies that are currently active
+// on the given endpoint, which will be zero if an error occurred.
+func CountPolicies(hnsEndpointID string) (int, error) {
+	hcnPolicies, err := listPolicies(hnsEndpointID)
+	if err != nil {
+		return 0, err
+	}
+	return len(hcnPolicies), nil
+}
+
 // ClearPolicies removes all the proxy policies from the specified endpoint.
 // It returns the number of policies that were removed, which will be zero
 // if an error occurred or if the endpoint did not have any active proxy policies.
 func ClearPolicies(hnsEndpointID string) (numRemoved int, err error) {
-	policies, err := listPolicies(hnsEndpointID)
+	return ClearPoliciesContext(context.Background(), hnsEndpointID)
+}
+
+// ClearPoliciesMatching removes the proxy policies selected by the filter from
+// the specified endpoint. It returns the number of policies that were removed,
+// following the same conventions as ClearPolicies.
+func ClearPoliciesMatching(hnsEndpointID string, filter PolicyFilter) (numRemoved int, err error) {
+	report, err := ClearPoliciesWithReport(hnsEndpointID, filter)
+	return report.Removed, err
+}
+
+// ClearReport breaks down the proxy policies removed by
+// ClearPoliciesWithReport. Policies with the same Key are duplicates: the
+// first one counts as unique and the others as duplicates.
+type ClearReport struct {
+	Removed    int
+	Unique     int
+	Duplicates int
+}
+
+// ClearPoliciesWithReport is like ClearPoliciesMatching, but also reports how
+// many of the removed policies were duplicates of each other. The report is
+// empty if an error occurred.
+func ClearPoliciesWithReport(hnsEndpointID string, filter PolicyFilter) (ClearReport, error) {
+	return clearPoliciesWithReport(context.Background(), hnsEndpointID, filter)
+}
+
+// clearPoliciesWithReport is ClearPoliciesWithReport, checking the context
+// between the HNS calls it makes.
+func clearPoliciesWithReport(ctx context.Context, hnsEndpointID string, filter PolicyFilter) (ClearReport, error) {
+	defer lockEndpoint(hnsEndpointID)()
+
+	hcnPolicies, err := listPolicies(hnsEndpointID)
+	if err != nil {
+		return ClearReport{}, err
+	}
+
+	var (
+		policies []hcn.EndpointPolicy
+		removed  []Policy
+		report   ClearReport
+	)
+	keys := make(map[string]bool)
+	for _, hcnPolicy := range hcnPolicies {
+		policy := hcnPolicyToAPIPolicy(hcnPolicy)
+		if !filter.Matches(policy) {
+			continue
+		}
+		policies = append(policies, hcnPolicy)
+		removed = append(removed, policy)
+
+		if key := policy.Key(); keys[key] {
+			report.Duplicates++
+		} else {
+			keys[key] = true
+			report.Unique++
+		}
+	}
+	if len(policies) == 0 {
+		return ClearReport{}, nil
+	}
+	if err := ctx.Err(); err != nil {
+		return ClearReport{}, err
+	}
+
+	event := HookEvent{Operation: "clear", EndpointID: hnsEndpointID, Policies: removed}
+	err = withHooks(event, func() error {
+		return removePolicies(hnsEndpointID, policies)
+	})
+	if err != nil {
+		return ClearReport{}, err
+	}
+
+	report.Removed = len(policies)
+	return report, nil
+}
+
+// UnmatchedKeysError is returned by RemovePoliciesByKeys when some of the
+// keys did not match any proxy policy of the endpoint.
+type UnmatchedKeysError struct {
+	Keys []string
+}
+
+func (e *UnmatchedKeysError) Error() string {
+	return "no policy matches keys " + strings.Join(e.Keys, ", ")
+}
+
+// RemovePoliciesByKeys removes the proxy policies of the endpoint whose key
+// (see Policy.Key) is one of the given keys, in a single request, and returns
+// the number of policies removed. If some of the keys did not match any
+// policy, the others are still removed and an *UnmatchedKeysError lists them.
+func RemovePoliciesByKeys(hnsEndpointID string, keys []string) (numRemoved int, err error) {
+	defer lockEndpoint(hnsEndpointID)()
+
+	hcnPolicies, err := listPolicies(hnsEndpointID)
 	if err != nil {
 		return 0, err
 	}
 
-	policyReq := hcn.PolicyEndpointRequest{
-		Policies: policies,
+	matched := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		matched[key] = false
 	}
 
-	policyJSON, err := json.Marshal(policyReq)
+	var (
+		policies []hcn.EndpointPolicy
+		removed  []Policy
+	)
+	for _, hcnPolicy := range hcnPolicies {
+		policy := hcnPolicyToAPIPolicy(hcnPolicy)
+		key := policy.Key()
+		if _, ok := matched[key]; ok {
+			matched[key] = true
+			policies = append(policies, hcnPolicy)
+			removed = append(removed, policy)
+		}
+	}
+
+	var unmatched []string
+	for _, key := range keys {
+		if !matched[key] {
+			unmatched = append(unmatched, key)
+			// Only report duplicated keys once.
+			matched[key] = true
+		}
+	}
+
+	if len(policies) > 0 {
+		event := HookEvent{Operation: "remove", EndpointID: hnsEndpointID, Policies: removed}
+		err := withHooks(event, func() error {
+			return removePolicies(hnsEndpointID, policies)
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if len(unmatched) > 0 {
+		return len(policies), &UnmatchedKeysError{Keys: unmatched}
+	}
+	return len(policies), nil
+}
+
+// ErrPolicyNotFound is returned by RemovePolicy when the endpoint has no proxy
+// policy matching the given one.
+var ErrPolicyNotFound = errors.New("no proxy policy of the endpoint matches the policy")
+
+// RemovePolicy removes the proxy policies of the endpoint that match the given
+// policy, ie. whose proxy port, user SID and filter tuple are those the policy
+// would be applied with by AddPolicy. If several policies match, which happens
+// when the same policy was added more than once, all of them are removed in a
+// single request. ErrPolicyNotFound is returned if no policy matches.
+func RemovePolicy(hnsEndpointID string, policy Policy) error {
+	effective, err := EffectivePolicy(policy)
+	if err != nil {
+		return err
+	}
+
+	defer lockEndpoint(hnsEndpointID)()
+
+	hcnPolicies, err := listPolicies(hnsEndpointID)
+	if err != nil {
+		return err
+	}
+
+	var (
+		policies []hcn.EndpointPolicy
+		removed  []Policy
+	)
+	for _, hcnPolicy := range hcnPolicies {
+		if matchesEndpointPolicy(effective, hcnPolicy) {
+			policies = append(policies, hcnPolicy)
+			removed = append(removed, hcnPolicyToAPIPolicy(hcnPolicy))
+		}
+	}
+	if len(policies) == 0 {
+		return ErrPolicyNotFound
+	}
+
+	event := HookEvent{Operation: "remove", EndpointID: hnsEndpointID, Policies: removed}
+	return withHooks(event, func() error {
+		return removePolicies(hnsEndpointID, policies)
+	})
+}
+
+// matchesEndpointPolicy returns true iff the HNS endpoint policy is a proxy
+// policy with the same settings as the effective policy (see EffectivePolicy).
+func matchesEndpointPolicy(effective Policy, hcnPolicy hcn.EndpointPolicy) bool {
+	if hcnPolicy.Type != hcn.L4WFPPROXY {
+		return false
+	}
+	return hcnPolicyToAPIPolicy(hcnPolicy) == effective
+}
+
+// UpdatePolicy replaces the proxy policies of the endpoint matching oldPolicy
+// (see RemovePolicy) by newPolicy. The new policy is validated before anything
+// is modified, and added before the old ones are removed, so that the traffic
+// remains intercepted throughout; if the old policies cannot be removed, the
+// new one is removed again. ErrPolicyNotFound is returned if no policy
+// matches oldPolicy.
+func UpdatePolicy(hnsEndpointID string, oldPolicy Policy, newPolicy Policy) error {
+	oldEffective, err := EffectivePolicy(oldPolicy)
+	if err != nil {
+		return err
+	}
+	newHCNPolicy, err := RenderPolicy(newPolicy)
+	if err != nil {
+		return fmt.Errorf("invalid new policy: %v", err)
+	}
+
+	defer lockEndpoint(hnsEndpointID)()
+
+	hcnPolicies, err := listPolicies(hnsEndpointID)
+	if err != nil {
+		return err
+	}
+	var oldHCNPolicies []hcn.EndpointPolicy
+	for _, hcnPolicy := range hcnPolicies {
+		if matchesEndpointPolicy(oldEffective, hcnPolicy) {
+			oldHCNPolicies = append(oldHCNPolicies, hcnPolicy)
+		}
+	}
+	if len(oldHCNPolicies) == 0 {
+		return ErrPolicyNotFound
+	}
+	if matchesEndpointPolicy(oldEffective, newHCNPolicy) {
+		return nil
+	}
+
+	event := HookEvent{Operation: "update", EndpointID: hnsEndpointID, Policies: []Policy{newPolicy}}
+	return withHooks(event, func() error {
+		newHCNPolicies := []hcn.EndpointPolicy{newHCNPolicy}
+		if err := applyRequest(hnsEndpointID, hcn.RequestTypeAdd, newHCNPolicies); err != nil {
+			return err
+		}
+		if err := removePolicies(hnsEndpointID, oldHCNPolicies); err != nil {
+			if rollbackErr := removePolicies(hnsEndpointID, newHCNPolicies); rollbackErr != nil {
+				return fmt.Errorf("%v (removing the new policy also failed: %v)", err, rollbackErr)
+			}
+			return err
+		}
+		return nil
+	})
+}
+
+// UpdatePoliciesMatching applies the mutate function to each proxy policy of
+// the endpoint selected by the filter, and replaces the policies that were
+// changed by their updated version. It returns the number of policies that
+// were replaced.
+//
+// All updated policies are validated, and checked not to collide (see
+// Policy.Key) with each other or with the policies left untouched, before
+// anything is modified. Policies left untouched are not checked against each
+// other, so that existing duplicates do not prevent updates. The policies are
+// then replaced by removing the old versions and adding the new ones; if
+// adding fails, the old versions are added back.
+func UpdatePoliciesMatching(hnsEndpointID string, filter PolicyFilter, mutate func(*Policy)) (numUpdated int, err error) {
+	defer lockEndpoint(hnsEndpointID)()
+
+	hcnPolicies, err := listPolicies(hnsEndpointID)
 	if err != nil {
 		return 0, err
 	}
 
-	modifyReq := &hcn.ModifyEndpointSettingRequest{
-		ResourceType: hcn.EndpointResourceTypePolicy,
-		RequestType:  hcn.RequestTypeRemove,
-		Settings:     policyJSON,
+	var (
+		oldPolicies     []hcn.EndpointPolicy
+		newPolicies     []hcn.EndpointPolicy
+		updatedPolicies []Policy
+		untouched       []Policy
+	)
+	for _, hcnPolicy := range hcnPolicies {
+		policy := hcnPolicyToAPIPolicy(hcnPolicy)
+		updated := policy
+		if filter.Matches(policy) {
+			mutate(&updated)
+		}
+		if updated == policy {
+			untouched = append(untouched, policy)
+			continue
+		}
+
+		newPolicy, err := RenderPolicy(updated)
+		if err != nil {
+			return 0, fmt.Errorf("invalid update of policy %+v: %v", policy, err)
+		}
+		oldPolicies = append(oldPolicies, hcnPolicy)
+		newPolicies = append(newPolicies, newPolicy)
+		updatedPolicies = append(updatedPolicies, updated)
+	}
+
+	if len(newPolicies) == 0 {
+		return 0, nil
+	}
+
+	updatedKeys := make(map[string]bool, len(newPolicies))
+	for _, newPolicy := range newPolicies {
+		policy := hcnPolicyToAPIPolicy(newPolicy)
+		key := policy.Key()
+		if updatedKeys[key] {
+			return 0, fmt.Errorf("update would result in duplicate policies %+v", policy)
+		}
+		updatedKeys[key] = true
+	}
+	for _, policy := range untouched {
+		if updatedKeys[policy.Key()] {
+			return 0, fmt.Errorf("update would duplicate the existing policy %+v", policy)
+		}
+	}
+
+	event := HookEvent{Operation: "update", EndpointID: hnsEndpointID, Policies: updatedPolicies}
+	err = withHooks(event, func() error {
+		if err := removePolicies(hnsEndpointID, oldPolicies); err != nil {
+			return err
+		}
+		if err := applyRequest(hnsEndpointID, hcn.RequestTypeAdd, newPolicies); err != nil {
+			if rollbackErr := applyRequest(hnsEndpointID, hcn.RequestTypeAdd, oldPolicies); rollbackErr != nil {
+				return fmt.Errorf("%v (restoring the original policies also failed: %v)", err, rollbackErr)
+			}
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return len(newPolicies), nil
+}
+
+// ListEndpointIDs returns the IDs of all the HNS endpoints of the host.
+func ListEndpointIDs() ([]string, error) {
+	endpoints, err := listEndpoints()
+	if err != nil {
+		return nil, err
 	}
 
-	return len(policies), hcn.ModifyEndpointSettings(hnsEndpointID, modifyReq)
+	endpointIDs := make([]string, len(endpoints))
+	for i, endpoint := range endpoints {
+		endpointIDs[i] = endpoint.Id
+	}
+	return endpointIDs, nil
+}
+
+// EndpointNotFoundError is returned by ResolveEndpointID when no endpoint has
+// the given ID or name.
+type EndpointNotFoundError struct {
+	EndpointIDOrName string
+}
+
+func (e *EndpointNotFoundError) Error() string {
+	return fmt.Sprintf("could not find an endpoint with ID or name %q", e.EndpointIDOrName)
+}
+
+// ResolveEndpointID returns the ID of the HNS endpoint designated by either
+// its ID or its name. IDs take precedence over names; an
+// *EndpointNotFoundError is returned if no endpoint has the given name, and
+// an error if several endpoints do.
+func ResolveEndpointID(endpointIDOrName string) (string, error) {
+	endpoints, err := listEndpoints()
+	if err != nil {
+		return "", err
+	}
+
+	var matches []string
+	for _, endpoint := range endpoints {
+		if strings.EqualFold(endpoint.Id, endpointIDOrName) {
+			return endpoint.Id, nil
+		}
+		if endpoint.Name == endpointIDOrName {
+			matches = append(matches, endpoint.Id)
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return "", &EndpointNotFoundError{EndpointIDOrName: endpointIDOrName}
+	case 1:
+		return matches[0], nil
+	}
+	return "", fmt.Errorf("several endpoints are named %q: %s", endpointIDOrName, strings.Join(matches, ", "))
+}
+
+var (
+	// ErrContainerNotFound is wrapped by the errors returned when the
+	// container to look up is unknown to the CRI runtime, or has no Windows
+	// network namespace.
+	ErrContainerNotFound = errors.New("could not find the container")
+
+	// ErrEndpointNotAttached is wrapped by the errors returned when the
+	// container to look up is not attached to any HNS endpoint.
+	ErrEndpointNotAttached = errors.New("could not find an endpoint attached to the container")
+)
+
+// LookupResult describes how a container is attached to HNS endpoints.
+type LookupResult struct {
+	ContainerID string   `json:"containerID"`
+	NamespaceID string   `json:"namespaceID"`
+	EndpointIDs []string `json:"endpointIDs"`
+	// Runtime is the CRI runtime endpoint the container was found through.
+	Runtime string `json:"runtime"`
 }
 
 // GetEndpointFromContainer takes a container ID as argument and returns
@@ -146,15 +715,54 @@ func ClearPolicies(hnsEndpointID string) (numRemoved int, err error) {
 // the specified container is not attached to any endpoint.
 // Note: there is no verification that the ID passed as argument belongs
 // to an actual container.
+// Containers attached to several endpoints have their IDs joined by commas;
+// use GetEndpointsFromContainer to get them as a slice.
 func GetEndpointFromContainer(containerID string, runtimeEndpoint string) (hnsEndpointID string, err error) {
-	params := cri.DefaultContainerdCriParameters()
-	if len(runtimeEndpoint) > 0 {
-		params.RuntimeEndpoint = runtimeEndpoint
-	}
-	containers, err := cri.ListContainers(params)
+	return GetEndpointFromContainerContext(context.Background(), containerID, runtimeEndpoint)
+}
+
+// GetEndpointFromContainerContext is like GetEndpointFromContainer, but stops
+// retrying to connect to CRI (see SetCRIRetry) when the context is done.
+func GetEndpointFromContainerContext(ctx context.Context, containerID string, runtimeEndpoint string) (hnsEndpointID string, err error) {
+	endpointIDs, err := GetEndpointsFromContainerContext(ctx, containerID, runtimeEndpoint)
 	if err != nil {
 		return "", err
 	}
+	return strings.Join(endpointIDs, ","), nil
+}
+
+// GetEndpointsFromContainer returns the IDs of the HNS endpoints to which the
+// container is attached, eg. one per network for containers attached to
+// several networks. It returns an error if the container is not attached to
+// any endpoint.
+func GetEndpointsFromContainer(containerID string, runtimeEndpoint string) ([]string, error) {
+	return GetEndpointsFromContainerContext(context.Background(), containerID, runtimeEndpoint)
+}
+
+// GetEndpointsFromContainerContext is like GetEndpointsFromContainer, but
+// stops retrying to connect to CRI (see SetCRIRetry) when the context is done.
+func GetEndpointsFromContainerContext(ctx context.Context, containerID string, runtimeEndpoint string) ([]string, error) {
+	result, err := GetContainerEndpointInfoContext(ctx, containerID, runtimeEndpoint)
+	if err != nil {
+		return nil, err
+	}
+	return result.EndpointIDs, nil
+}
+
+// GetContainerEndpointInfo is like GetEndpointFromContainer, but also returns
+// the network namespace of the container and the runtime it was found through.
+func GetContainerEndpointInfo(containerID string, runtimeEndpoint string) (*LookupResult, error) {
+	return GetContainerEndpointInfoContext(context.Background(), containerID, runtimeEndpoint)
+}
+
+// GetContainerEndpointInfoContext is like GetContainerEndpointInfo, but stops
+// retrying to connect to CRI (see SetCRIRetry) when the context is done.
+func GetContainerEndpointInfoContext(ctx context.Context, containerID string, runtimeEndpoint string) (*LookupResult, error) {
+	params := criParameters(runtimeEndpoint)
+	containers, err := listContainersRetrying(ctx, params)
+	if err != nil {
+		return nil, err
+	}
 	var namespaceID string
 	for _, container := range containers {
 		if container.ContainerId == containerID {
@@ -162,24 +770,292 @@ func GetEndpointFromContainer(containerID string, runtimeEndpoint string) (hnsEn
 		}
 	}
 	if len(namespaceID) == 0 {
-		return "", errors.New("could not find the container")
+		return nil, fmt.Errorf("%w %s", ErrContainerNotFound, containerID)
 	}
 
-	endpointIDs, err := hcn.GetNamespaceEndpointIds(namespaceID)
+	endpointIDs, err := GetEndpointsFromNamespace(namespaceID)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	if len(endpointIDs) == 0 {
-		return "", errors.New("could not find an endpoint attached to that container")
+		return nil, fmt.Errorf("%w %s", ErrEndpointNotAttached, containerID)
 	}
 
-	return strings.Join(endpointIDs, ","), nil
+	return &LookupResult{
+		ContainerID: containerID,
+		NamespaceID: namespaceID,
+		EndpointIDs: endpointIDs,
+		Runtime:     params.RuntimeEndpoint,
+	}, nil
+}
+
+// GetEndpointFromContainerName is like GetEndpointsFromContainer, but looks
+// the container up by the name in its metadata instead of its ID. It returns
+// an error listing the IDs of the matching containers if several containers
+// have that name, eg. containers of different pods.
+func GetEndpointFromContainerName(containerName string, runtimeEndpoint string) ([]string, error) {
+	containers, err := listContainers(runtimeEndpoint)
+	if err != nil {
+		return nil, err
+	}
+	var matches []cri.ContainerInfo
+	for _, container := range containers {
+		if container.Name == containerName {
+			matches = append(matches, container)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return nil, fmt.Errorf("%w named %q", ErrContainerNotFound, containerName)
+	case 1:
+	default:
+		containerIDs := make([]string, len(matches))
+		for i, match := range matches {
+			containerIDs[i] = match.ContainerId
+		}
+		return nil, fmt.Errorf("several containers are named %q: %s", containerName, strings.Join(containerIDs, ", "))
+	}
+
+	endpointIDs, err := GetEndpointsFromNamespace(matches[0].NamespaceId)
+	if err != nil {
+		return nil, err
+	}
+	if len(endpointIDs) == 0 {
+		return nil, fmt.Errorf("%w named %q", ErrEndpointNotAttached, containerName)
+	}
+	return endpointIDs, nil
+}
+
+// UnresolvedContainersError is returned by GetEndpointsForContainers when
+// some of the containers could not be found, or are not attached to any
+// endpoint.
+type UnresolvedContainersError struct {
+	ContainerIDs []string
+}
+
+func (e *UnresolvedContainersError) Error() string {
+	return "could not find an endpoint attached to containers " + strings.Join(e.ContainerIDs, ", ")
+}
+
+// GetEndpointsForContainers returns the IDs of the HNS endpoints to which
+// each of the given containers is attached, keyed by container ID. The
+// containers are listed only once for all of the IDs.
+// If some of the containers could not be resolved, the map holds the ones
+// that were and an *UnresolvedContainersError lists the others.
+func GetEndpointsForContainers(containerIDs []string, runtimeEndpoint string) (map[string][]string, error) {
+	containers, err := listContainers(runtimeEndpoint)
+	if err != nil {
+		return nil, err
+	}
+	namespaceIDs := make(map[string]string, len(containers))
+	for _, container := range containers {
+		namespaceIDs[container.ContainerId] = container.NamespaceId
+	}
+
+	endpoints := make(map[string][]string, len(containerIDs))
+	var unresolved []string
+	for _, containerID := range containerIDs {
+		namespaceID := namespaceIDs[containerID]
+		if len(namespaceID) == 0 {
+			unresolved = append(unresolved, containerID)
+			continue
+		}
+
+		endpointIDs, err := getNamespaceEndpointIDs(namespaceID)
+		if err != nil {
+			return nil, err
+		}
+		if len(endpointIDs) == 0 {
+			unresolved = append(unresolved, containerID)
+			continue
+		}
+		endpoints[containerID] = endpointIDs
+	}
+
+	if len(unresolved) > 0 {
+		return endpoints, &UnresolvedContainersError{ContainerIDs: unresolved}
+	}
+	return endpoints, nil
+}
+
+// LookupAllContainers returns how each container known to the CRI runtime is
+// attached to HNS endpoints, from a single listing of the containers. The
+// endpoints of the network namespaces are looked up with at most parallelism
+// HNS calls at once (one if parallelism is less than one). Containers without
+// a Windows network namespace are left out, as by ListContainers, and
+// containers whose namespace has no endpoint have no EndpointIDs.
+func LookupAllContainers(runtimeEndpoint string, parallelism int) ([]LookupResult, error) {
+	if parallelism < 1 {
+		parallelism = 1
+	}
+
+	params := criParameters(runtimeEndpoint)
+	containers, err := listContainersRetrying(context.Background(), params)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]LookupResult, len(containers))
+	indexes := make(chan int)
+	errs := make(chan error, parallelism)
+	var wg sync.WaitGroup
+	for i := 0; i < parallelism; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				endpointIDs, err := getNamespaceEndpointIDs(results[i].NamespaceID)
+				if err != nil {
+					select {
+					case errs <- fmt.Errorf("container %s: %v", results[i].ContainerID, err):
+					default:
+					}
+					continue
+				}
+				results[i].EndpointIDs = endpointIDs
+			}
+		}()
+	}
+
+	for i, container := range containers {
+		results[i] = LookupResult{
+			ContainerID: container.ContainerId,
+			NamespaceID: container.NamespaceId,
+			Runtime:     params.RuntimeEndpoint,
+		}
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	select {
+	case err := <-errs:
+		return nil, err
+	default:
+	}
+	return results, nil
+}
+
+// GetEndpointsFromPodIP returns the IDs of the HNS endpoints of the pod that
+// has the given IP address. The pod sandbox with that IP is looked up through
+// CRI, and its endpoints are those of its containers. If CRI does not know of
+// such a pod, the HNS endpoints are searched for one with that IP address.
+// Either IP of a dual-stack pod can be used.
+func GetEndpointsFromPodIP(podIP string, runtimeEndpoint string) ([]string, error) {
+	ip := net.ParseIP(podIP)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid pod IP %q", podIP)
+	}
+
+	endpointIDs, err := getEndpointsFromPodIPViaCRI(ip, runtimeEndpoint)
+	if err == nil && len(endpointIDs) > 0 {
+		return endpointIDs, nil
+	}
+
+	endpoints, err := listEndpoints()
+	if err != nil {
+		return nil, err
+	}
+	for _, endpoint := range endpoints {
+		for _, ipConfig := range endpoint.IpConfigurations {
+			if ip.Equal(net.ParseIP(ipConfig.IpAddress)) {
+				endpointIDs = append(endpointIDs, endpoint.Id)
+				break
+			}
+		}
+	}
+	if len(endpointIDs) == 0 {
+		return nil, errors.New("could not find an endpoint with that IP address")
+	}
+
+	return endpointIDs, nil
+}
+
+// getEndpointsFromPodIPViaCRI returns the IDs of the HNS endpoints of the
+// containers of the pod sandbox that has the given IP, according to CRI.
+func getEndpointsFromPodIPViaCRI(ip net.IP, runtimeEndpoint string) ([]string, error) {
+	params := criParameters(runtimeEndpoint)
+	sandboxes, err := cri.ListPodSandboxes(params)
+	if err != nil {
+		return nil, err
+	}
+
+	var sandboxID string
+	for _, sandbox := range sandboxes {
+		for _, sandboxIP := range sandbox.IPs {
+			if ip.Equal(net.ParseIP(sandboxIP)) {
+				sandboxID = sandbox.PodSandboxId
+			}
+		}
+	}
+	if len(sandboxID) == 0 {
+		return nil, nil
+	}
+
+	containers, err := listContainersRetrying(context.Background(), params)
+	if err != nil {
+		return nil, err
+	}
+	for _, container := range containers {
+		if container.PodSandboxId == sandboxID {
+			return getNamespaceEndpointIDs(container.NamespaceId)
+		}
+	}
+
+	return nil, nil
+}
+
+// GetRuntimeVersion returns the name and version of the container runtime
+// behind the CRI runtime endpoint, or the default one if runtimeEndpoint is
+// empty.
+func GetRuntimeVersion(runtimeEndpoint string) (name string, version string, err error) {
+	return cri.RuntimeVersion(criParameters(runtimeEndpoint))
+}
+
+// ListContainers returns the containers known to the CRI runtime endpoint, or
+// to the default one if runtimeEndpoint is empty. Containers without a Windows
+// network namespace are left out.
+func ListContainers(runtimeEndpoint string) ([]cri.ContainerInfo, error) {
+	return listContainers(runtimeEndpoint)
+}
+
+// GetEndpointsFromNamespace returns the IDs of the HNS endpoints attached to
+// a network namespace, as found in the ContainerInfo of a container.
+func GetEndpointsFromNamespace(namespaceID string) ([]string, error) {
+	return getNamespaceEndpointIDs(namespaceID)
+}
+
+// listContainers lists the containers known to the CRI runtime endpoint, or
+// to the default one if runtimeEndpoint is empty.
+func listContainers(runtimeEndpoint string) ([]cri.ContainerInfo, error) {
+	return listContainersRetrying(context.Background(), criParameters(runtimeEndpoint))
+}
+
+// defaultCRIParameters are the parameters used to connect to CRI when no
+// runtime endpoint is specified, see SetDefaultCRIParameters.
+var defaultCRIParameters = cri.DefaultContainerdCriParameters()
+
+// SetDefaultCRIParameters sets the parameters used to connect to CRI. Its
+// runtime endpoint is used whenever no runtime endpoint is specified, and its
+// timeout always.
+func SetDefaultCRIParameters(params cri.CriParameters) {
+	defaultCRIParameters = params
+}
+
+// criParameters returns the parameters to connect to the CRI runtime
+// endpoint, or to the default one if runtimeEndpoint is empty.
+func criParameters(runtimeEndpoint string) cri.CriParameters {
+	params := defaultCRIParameters
+	if len(runtimeEndpoint) > 0 {
+		params.RuntimeEndpoint = runtimeEndpoint
+	}
+	return params
 }
 
 // listPolicies returns the HCN *proxy* policies that are currently active on the
 // given endpoint.
 func listPolicies(hnsEndpointID string) ([]hcn.EndpointPolicy, error) {
-	endpoint, err := hcn.GetEndpointByID(hnsEndpointID)
+	endpoint, err := getEndpointByID(hnsEndpointID)
 	if err != nil {
 		return nil, err
 	}
@@ -194,6 +1070,41 @@ func listPolicies(hnsEndpointID string) ([]hcn.EndpointPolicy, error) {
 	return policies, nil
 }
 
+// removePolicies removes the given HCN policies from the endpoint.
+func removePolicies(hnsEndpointID string, policies []hcn.EndpointPolicy) error {
+	return applyRequest(hnsEndpointID, hcn.RequestTypeRemove, policies)
+}
+
+// applyRequest issues a request of the given type for the HCN policies.
+func applyRequest(hnsEndpointID string, requestType hcn.RequestType, policies []hcn.EndpointPolicy) error {
+	policyReq := hcn.PolicyEndpointRequest{
+		Policies: policies,
+	}
+
+	policyJSON, err := json.Marshal(policyReq)
+	if err != nil {
+		return err
+	}
+
+	modifyReq := &hcn.ModifyEndpointSettingRequest{
+		ResourceType: hcn.EndpointResourceTypePolicy,
+		RequestType:  requestType,
+		Settings:     policyJSON,
+	}
+
+	start := time.Now()
+	err = modifyEndpointSettings(hnsEndpointID, modifyReq)
+	requestJSON, _ := json.Marshal(modifyReq)
+	notifyRequest(RequestResult{
+		EndpointID:  hnsEndpointID,
+		RequestType: requestType,
+		Request:     requestJSON,
+		Err:         err,
+		Duration:    time.Since(start),
+	})
+	return err
+}
+
 // hcnPolicyToAPIPolicy converts an L4 proxy policy as defined by hcsshim
 // to our own API.
 func hcnPolicyToAPIPolicy(hcnPolicy hcn.EndpointPolicy) Policy {
@@ -217,15 +1128,60 @@ func hcnPolicyToAPIPolicy(hcnPolicy hcn.EndpointPolicy) Policy {
 	}
 }
 
-// validatePolicy returns nil iff the provided policy is valid.
-// For now it only checks that the port number is nonzero.
+// validatePolicy returns nil iff the provided policy is valid, and otherwise
+// a *ValidationError listing every problem found.
+// For now it only checks that the ports are between 1 and 65535, that the
+// user SID is well-formed, that the addresses are IPs or CIDRs, all of the
+// same IP family, and that the protocol is supported.
 func validatePolicy(policy Policy) error {
+	var problems []error
 	if len(policy.ProxyPort) == 0 {
-		return errors.New("policy missing proxy port")
+		problems = append(problems, fmt.Errorf("%w: the policy has none", ErrInvalidProxyPort))
+	} else if err := validatePort(policy.ProxyPort); err != nil {
+		problems = append(problems, fmt.Errorf("%w: %v", ErrInvalidProxyPort, err))
+	}
+	for _, field := range []struct{ name, value string }{
+		{"local ports", policy.LocalPorts},
+		{"remote ports", policy.RemotePorts},
+	} {
+		if len(field.value) == 0 {
+			continue
+		}
+		if err := validatePortRange(field.value); err != nil {
+			problems = append(problems, fmt.Errorf("invalid %s: %v", field.name, err))
+		}
+	}
+	if len(policy.UserSID) > 0 {
+		if err := validateUserSID(policy.UserSID); err != nil {
+			problems = append(problems, err)
+		}
+	}
+	for _, field := range []struct{ name, value string }{
+		{"local addresses", policy.LocalAddresses},
+		{"remote addresses", policy.RemoteAddresses},
+	} {
+		if len(field.value) == 0 {
+			continue
+		}
+		if err := validateAddresses(field.value); err != nil {
+			problems = append(problems, fmt.Errorf("invalid %s: %v", field.name, err))
+		}
+	}
+	if len(policy.Protocol) > 0 {
+		if number, err := ParseProtocol(policy.Protocol); err != nil {
+			problems = append(problems, err)
+		} else if !isSupportedProtocol(number) {
+			problems = append(problems, fmt.Errorf("unsupported protocol %q: HNS only supports proxy policies for %s", policy.Protocol, supportedProtocolList()))
+		}
+	}
+	if len(policy.ImagePath) > 0 {
+		problems = append(problems, ErrImagePathUnsupported)
+	}
+	if err := validateAddressFamilies(policy); err != nil {
+		problems = append(problems, err)
 	}
-	port, _ := strconv.Atoi(policy.ProxyPort)
-	if port == 0 {
-		return errors.New("policy has invalid proxy port value: 0")
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
 	}
 	return nil
 }
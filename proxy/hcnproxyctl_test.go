@@ -0,0 +1,405 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package hcnproxyctrl
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/Microsoft/hcsshim/hcn"
+)
+
+func TestRenderPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  Policy
+		setting hcn.L4WfpProxyPolicySetting
+	}{
+		{
+			name:   "defaults",
+			policy: Policy{ProxyPort: "15001"},
+			setting: hcn.L4WfpProxyPolicySetting{
+				Port:        "15001",
+				FilterTuple: hcn.FiveTuple{Protocols: "6"},
+			},
+		},
+		{
+			name: "all fields",
+			policy: Policy{
+				ProxyPort:       "15001",
+				UserSID:         "S-1-5-21-1-2-3-1000",
+				LocalAddresses:  "10.0.0.1",
+				RemoteAddresses: "10.1.0.0/16, 10.2.0.1",
+				LocalPorts:      "1000-2000",
+				RemotePorts:     "80",
+				Priority:        100,
+				Protocol:        "17",
+			},
+			setting: hcn.L4WfpProxyPolicySetting{
+				Port:    "15001",
+				UserSID: "S-1-5-21-1-2-3-1000",
+				FilterTuple: hcn.FiveTuple{
+					LocalAddresses:  "10.0.0.1",
+					RemoteAddresses: "10.1.0.0/16,10.2.0.1",
+					LocalPorts:      "1000-2000",
+					RemotePorts:     "80",
+					Protocols:       "17",
+					Priority:        100,
+				},
+			},
+		},
+		{
+			name:   "shorthands",
+			policy: Policy{ProxyPort: "15001", UserSID: "System", Protocol: "udp"},
+			setting: hcn.L4WfpProxyPolicySetting{
+				Port:        "15001",
+				UserSID:     LocalSystemSID,
+				FilterTuple: hcn.FiveTuple{Protocols: "17"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rendered := mustRenderPolicy(t, test.policy)
+			if rendered.Type != hcn.L4WFPPROXY {
+				t.Errorf("rendered policy type %s, want %s", rendered.Type, hcn.L4WFPPROXY)
+			}
+			var setting hcn.L4WfpProxyPolicySetting
+			if err := json.Unmarshal(rendered.Settings, &setting); err != nil {
+				t.Fatal(err)
+			}
+			if setting != test.setting {
+				t.Errorf("rendered setting %+v, want %+v", setting, test.setting)
+			}
+
+			// The render command is only useful if it shows what AddPolicy
+			// sends to HNS.
+			fake := newFakeHNS(t, hcn.HostComputeEndpoint{Id: "ep"})
+			if err := AddPolicy("ep", test.policy); err != nil {
+				t.Fatalf("AddPolicy: %v", err)
+			}
+			if len(fake.requests) != 1 || len(fake.requests[0].Policies) != 1 {
+				t.Fatalf("AddPolicy made requests %+v, want a single request for one policy", fake.requests)
+			}
+			request := fake.requests[0]
+			if request.RequestType != hcn.RequestTypeAdd {
+				t.Errorf("AddPolicy made a %s request, want %s", request.RequestType, hcn.RequestTypeAdd)
+			}
+			if applied := request.Policies[0]; applied.Type != rendered.Type || string(applied.Settings) != string(rendered.Settings) {
+				t.Errorf("AddPolicy applied %s %s, RenderPolicy rendered %s %s", applied.Type, applied.Settings, rendered.Type, rendered.Settings)
+			}
+		})
+	}
+}
+
+func TestRenderPolicyInvalid(t *testing.T) {
+	for _, policy := range []Policy{
+		{},
+		{ProxyPort: "0"},
+		{ProxyPort: "15001", UserSID: "nobody"},
+		{ProxyPort: "15001", RemotePorts: "90-80"},
+		{ProxyPort: "15001", Protocol: "icmp"},
+		{ProxyPort: "15001", LocalAddresses: "10.0.0.1", RemoteAddresses: "::1"},
+	} {
+		if _, err := RenderPolicy(policy); err == nil {
+			t.Errorf("RenderPolicy(%+v) succeeded, want an error", policy)
+		}
+	}
+}
+
+func TestValidationError(t *testing.T) {
+	err := validatePolicy(Policy{ProxyPort: "70000", RemotePorts: "90-80", ImagePath: `C:\envoy.exe`})
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("validatePolicy error %v, want a *ValidationError", err)
+	}
+	if len(validationErr.Problems) != 3 {
+		t.Errorf("problems %q, want one per invalid field", validationErr.Problems)
+	}
+	if !strings.HasPrefix(err.Error(), "invalid policy: invalid proxy port") || strings.Count(err.Error(), "; ") != 2 {
+		t.Errorf("error %q, want every problem", err)
+	}
+	for _, target := range []error{ErrInvalidProxyPort, ErrImagePathUnsupported} {
+		if !errors.Is(err, target) {
+			t.Errorf("errors.Is(%v, %v) = false, want true", err, target)
+		}
+	}
+	if errors.Is(err, ErrPolicyNotFound) {
+		t.Errorf("errors.Is(%v, ErrPolicyNotFound) = true, want false", err)
+	}
+
+	// A single problem is reported as is.
+	err = validatePolicy(Policy{})
+	if err == nil || err.Error() != "invalid proxy port: the policy has none" || !errors.Is(err, ErrInvalidProxyPort) {
+		t.Errorf("validatePolicy error %v, want the missing proxy port alone", err)
+	}
+
+	// The problems of several policies are each a *PolicyError.
+	_, err = renderPolicies([]Policy{{ProxyPort: "15001"}, {ProxyPort: "0"}, {ProxyPort: "15001", ImagePath: `C:\envoy.exe`}})
+	if !errors.As(err, &validationErr) || len(validationErr.Problems) != 2 {
+		t.Fatalf("renderPolicies error %v, want a problem per invalid policy", err)
+	}
+	var policyErr *PolicyError
+	if !errors.As(validationErr.Problems[1], &policyErr) || policyErr.Index != 2 {
+		t.Errorf("problem %v, want the error of policy 2", validationErr.Problems[1])
+	}
+	for _, target := range []error{ErrInvalidProxyPort, ErrImagePathUnsupported} {
+		if !errors.Is(err, target) {
+			t.Errorf("errors.Is(%v, %v) = false, want true", err, target)
+		}
+	}
+}
+
+func TestUpdatePoliciesMatching(t *testing.T) {
+	setPort := func(port string) func(*Policy) {
+		return func(policy *Policy) { policy.ProxyPort = port }
+	}
+
+	tests := []struct {
+		name     string
+		policies []Policy
+		filter   PolicyFilter
+		mutate   func(*Policy)
+		updated  int
+		err      string
+		want     []Policy
+	}{
+		{
+			name: "updates the selected policies only",
+			policies: []Policy{
+				{ProxyPort: "15001", RemotePorts: "80"},
+				{ProxyPort: "15001", RemotePorts: "443"},
+				{ProxyPort: "15002", RemotePorts: "8080"},
+			},
+			filter:  PolicyFilter{ProxyPort: "15001"},
+			mutate:  setPort("16001"),
+			updated: 2,
+			want: []Policy{
+				{ProxyPort: "15002", RemotePorts: "8080", Protocol: "6"},
+				{ProxyPort: "16001", RemotePorts: "443", Protocol: "6"},
+				{ProxyPort: "16001", RemotePorts: "80", Protocol: "6"},
+			},
+		},
+		{
+			name: "existing duplicates do not prevent updates",
+			policies: []Policy{
+				{ProxyPort: "15001", RemotePorts: "80"},
+				{ProxyPort: "15002", RemotePorts: "8080"},
+				{ProxyPort: "15002", RemotePorts: "8080"},
+			},
+			filter:  PolicyFilter{ProxyPort: "15001"},
+			mutate:  setPort("16001"),
+			updated: 1,
+			want: []Policy{
+				{ProxyPort: "15002", RemotePorts: "8080", Protocol: "6"},
+				{ProxyPort: "15002", RemotePorts: "8080", Protocol: "6"},
+				{ProxyPort: "16001", RemotePorts: "80", Protocol: "6"},
+			},
+		},
+		{
+			name: "updated policies colliding with each other",
+			policies: []Policy{
+				{ProxyPort: "15001", RemotePorts: "80"},
+				{ProxyPort: "15002", RemotePorts: "80"},
+			},
+			mutate: setPort("16001"),
+			err:    "update would result in duplicate policies",
+		},
+		{
+			name: "updated policy equivalent to an untouched one",
+			policies: []Policy{
+				{ProxyPort: "15001", RemoteAddresses: "10.0.0.2,10.0.0.1"},
+				{ProxyPort: "16001", RemoteAddresses: "10.0.0.1,10.0.0.2"},
+			},
+			filter: PolicyFilter{ProxyPort: "15001"},
+			mutate: setPort("16001"),
+			err:    "update would duplicate the existing policy",
+		},
+		{
+			name:     "invalid update",
+			policies: []Policy{{ProxyPort: "15001"}},
+			mutate:   setPort("0"),
+			err:      "invalid update of policy",
+		},
+		{
+			name:     "nothing to update",
+			policies: []Policy{{ProxyPort: "15001"}},
+			filter:   PolicyFilter{ProxyPort: "15002"},
+			mutate:   setPort("16001"),
+			want:     []Policy{{ProxyPort: "15001", Protocol: "6"}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake := newFakeHNS(t, proxyEndpoint(t, "ep", test.policies...))
+			updated, err := UpdatePoliciesMatching("ep", test.filter, test.mutate)
+			if len(test.err) > 0 {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("UpdatePoliciesMatching = %d, %v, want error containing %q", updated, err, test.err)
+				}
+				if len(fake.requests) > 0 {
+					t.Errorf("UpdatePoliciesMatching made requests %+v despite failing validation", fake.requests)
+				}
+				return
+			}
+			if err != nil || updated != test.updated {
+				t.Fatalf("UpdatePoliciesMatching = %d, %v, want %d", updated, err, test.updated)
+			}
+			if got := sortedPolicies(mustListPolicies(t, "ep")); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("policies after update %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestUpdatePoliciesMatchingRollback(t *testing.T) {
+	fake := newFakeHNS(t, proxyEndpoint(t, "ep", Policy{ProxyPort: "15001"}))
+	var adds int
+	fake.fail = func(request fakeRequest) error {
+		if request.RequestType == hcn.RequestTypeAdd {
+			if adds++; adds == 1 {
+				return errors.New("add failed")
+			}
+		}
+		return nil
+	}
+
+	_, err := UpdatePoliciesMatching("ep", PolicyFilter{}, func(policy *Policy) { policy.ProxyPort = "16001" })
+	if err == nil || err.Error() != "add failed" {
+		t.Fatalf("UpdatePoliciesMatching error %v, want add failed", err)
+	}
+
+	var requestTypes []hcn.RequestType
+	for _, request := range fake.requests {
+		requestTypes = append(requestTypes, request.RequestType)
+	}
+	wantTypes := []hcn.RequestType{hcn.RequestTypeRemove, hcn.RequestTypeAdd, hcn.RequestTypeAdd}
+	if !reflect.DeepEqual(requestTypes, wantTypes) {
+		t.Errorf("requests %v, want %v", requestTypes, wantTypes)
+	}
+	want := []Policy{{ProxyPort: "15001", Protocol: "6"}}
+	if got := mustListPolicies(t, "ep"); !reflect.DeepEqual(got, want) {
+		t.Errorf("policies after rollback %+v, want %+v", got, want)
+	}
+}
+
+// sortedPolicies sorts the policies by proxy port, then remote ports, as
+// strings.
+func sortedPolicies(policies []Policy) []Policy {
+	sort.SliceStable(policies, func(i, j int) bool {
+		if policies[i].ProxyPort != policies[j].ProxyPort {
+			return policies[i].ProxyPort < policies[j].ProxyPort
+		}
+		return policies[i].RemotePorts < policies[j].RemotePorts
+	})
+	return policies
+}
+
+func TestClearPoliciesWithReport(t *testing.T) {
+	http := Policy{ProxyPort: "15001", RemotePorts: "80"}
+	https := Policy{ProxyPort: "15001", RemotePorts: "443"}
+	dns := Policy{ProxyPort: "15053", RemotePorts: "53", Protocol: "17"}
+
+	tests := []struct {
+		name      string
+		policies  []Policy
+		filter    PolicyFilter
+		report    ClearReport
+		remaining int
+	}{
+		{
+			name:     "no policy",
+			policies: nil,
+			report:   ClearReport{},
+		},
+		{
+			name:     "no duplicates",
+			policies: []Policy{http, https},
+			report:   ClearReport{Removed: 2, Unique: 2},
+		},
+		{
+			name:     "duplicates",
+			policies: []Policy{http, https, http, http, https, dns},
+			report:   ClearReport{Removed: 6, Unique: 3, Duplicates: 3},
+		},
+		{
+			name:      "duplicates matching the filter",
+			policies:  []Policy{http, dns, http, dns, https},
+			filter:    PolicyFilter{ProxyPort: "15001"},
+			report:    ClearReport{Removed: 3, Unique: 2, Duplicates: 1},
+			remaining: 2,
+		},
+	}
+	for _, test := range tests {
+		newFakeHNS(t, proxyEndpoint(t, "ep", test.policies...))
+		report, err := ClearPoliciesWithReport("ep", test.filter)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if report != test.report {
+			t.Errorf("%s: ClearPoliciesWithReport = %+v, want %+v", test.name, report, test.report)
+		}
+		if remaining := mustListPolicies(t, "ep"); len(remaining) != test.remaining {
+			t.Errorf("%s: %d policies left, want %d", test.name, len(remaining), test.remaining)
+		}
+	}
+}
+
+func TestClearPoliciesWithReportFailure(t *testing.T) {
+	policy := Policy{ProxyPort: "15001"}
+	fake := newFakeHNS(t, proxyEndpoint(t, "ep", policy, policy))
+	fake.fail = func(request fakeRequest) error { return errors.New("HNS failure") }
+
+	report, err := ClearPoliciesWithReport("ep", PolicyFilter{})
+	if err == nil || report != (ClearReport{}) {
+		t.Errorf("ClearPoliciesWithReport = %+v, %v, want an empty report and the HNS failure", report, err)
+	}
+}
+
+func TestRemovePolicy(t *testing.T) {
+	http := Policy{ProxyPort: "15001", RemotePorts: "80"}
+	https := Policy{ProxyPort: "15001", RemotePorts: "443"}
+	httpPrioritized := Policy{ProxyPort: "15001", RemotePorts: "80", Priority: 100}
+	httpOtherPort := Policy{ProxyPort: "15002", RemotePorts: "80"}
+	endpoint := proxyEndpoint(t, "ep", http, https, httpPrioritized, http, httpOtherPort)
+	acl, err := json.Marshal(hcn.AclPolicySetting{Action: hcn.ActionTypeBlock, Direction: hcn.DirectionTypeOut, Priority: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	endpoint.Policies = append(endpoint.Policies, hcn.EndpointPolicy{Type: hcn.ACL, Settings: acl})
+	fake := newFakeHNS(t, endpoint)
+
+	// An equivalent policy removes both copies of http, in a single request.
+	if err := RemovePolicy("ep", Policy{ProxyPort: "15001", RemotePorts: "80", Protocol: "tcp"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.requests) != 1 || fake.requests[0].RequestType != hcn.RequestTypeRemove || len(fake.requests[0].Policies) != 2 {
+		t.Errorf("requests %+v, want a single request removing the 2 copies", fake.requests)
+	}
+
+	want := []Policy{https, httpPrioritized, httpOtherPort}
+	for i := range want {
+		want[i], _ = EffectivePolicy(want[i])
+	}
+	if remaining := mustListPolicies(t, "ep"); !reflect.DeepEqual(sortedPolicies(remaining), sortedPolicies(want)) {
+		t.Errorf("remaining policies %+v, want %+v", remaining, want)
+	}
+	if endpoint, _ := getEndpointByID("ep"); len(endpoint.Policies) != len(want)+1 {
+		t.Errorf("endpoint policies %+v, want the ACL policy kept", endpoint.Policies)
+	}
+
+	// Nothing matches anymore.
+	if err := RemovePolicy("ep", http); err != ErrPolicyNotFound {
+		t.Errorf("RemovePolicy error %v, want %v", err, ErrPolicyNotFound)
+	}
+	if len(fake.requests) != 1 {
+		t.Errorf("requests %+v, want none for a policy matching nothing", fake.requests[1:])
+	}
+}
@@ -0,0 +1,18 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package hcnproxyctrl
+
+import "github.com/Microsoft/hcsshim/hcn"
+
+// The HNS calls made by this package. They are variables so that HNS can be
+// replaced where it is not available, eg. by a fake in tests.
+var (
+	getEndpointByID           = hcn.GetEndpointByID
+	listEndpoints             = hcn.ListEndpoints
+	getNamespaceEndpointIDs   = hcn.GetNamespaceEndpointIds
+	modifyEndpointSettings    = hcn.ModifyEndpointSettings
+	listNetworks              = hcn.ListNetworks
+	getNetworkByID            = hcn.GetNetworkByID
+	l4WfpProxyPolicySupported = hcn.L4WfpProxyPolicySupported
+)
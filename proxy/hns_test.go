@@ -0,0 +1,220 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package hcnproxyctrl
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/Microsoft/hcsshim/hcn"
+)
+
+// fakeHNS stands in for HNS in tests. It holds endpoints and networks, and
+// applies the policy requests made to the endpoints.
+type fakeHNS struct {
+	mutex     sync.Mutex
+	endpoints map[string]*hcn.HostComputeEndpoint
+	networks  map[string]*hcn.HostComputeNetwork
+
+	// requests are the ModifyEndpointSettings requests made, in order.
+	requests []fakeRequest
+
+	// fail, if set, is called before each request is applied; the request
+	// fails with its error, if any, without being applied.
+	fail func(request fakeRequest) error
+}
+
+// fakeRequest is a policy request made to the fake HNS.
+type fakeRequest struct {
+	EndpointID  string
+	RequestType hcn.RequestType
+	Policies    []hcn.EndpointPolicy
+}
+
+// newFakeHNS replaces HNS by a fake holding the given endpoints for the
+// duration of the test.
+func newFakeHNS(t *testing.T, endpoints ...hcn.HostComputeEndpoint) *fakeHNS {
+	t.Helper()
+	fake := &fakeHNS{
+		endpoints: make(map[string]*hcn.HostComputeEndpoint),
+		networks:  make(map[string]*hcn.HostComputeNetwork),
+	}
+	for i := range endpoints {
+		fake.endpoints[endpoints[i].Id] = &endpoints[i]
+	}
+
+	oldGetEndpointByID, oldListEndpoints, oldGetNamespaceEndpointIDs := getEndpointByID, listEndpoints, getNamespaceEndpointIDs
+	oldModifyEndpointSettings, oldListNetworks, oldGetNetworkByID := modifyEndpointSettings, listNetworks, getNetworkByID
+	oldL4WfpProxyPolicySupported := l4WfpProxyPolicySupported
+	t.Cleanup(func() {
+		getEndpointByID, listEndpoints, getNamespaceEndpointIDs = oldGetEndpointByID, oldListEndpoints, oldGetNamespaceEndpointIDs
+		modifyEndpointSettings, listNetworks, getNetworkByID = oldModifyEndpointSettings, oldListNetworks, oldGetNetworkByID
+		l4WfpProxyPolicySupported = oldL4WfpProxyPolicySupported
+	})
+
+	getEndpointByID = fake.getEndpointByID
+	listEndpoints = fake.listEndpoints
+	getNamespaceEndpointIDs = fake.getNamespaceEndpointIDs
+	modifyEndpointSettings = fake.modifyEndpointSettings
+	listNetworks = fake.listNetworks
+	getNetworkByID = fake.getNetworkByID
+	l4WfpProxyPolicySupported = func() error { return nil }
+	return fake
+}
+
+// copyEndpoint returns a copy of the endpoint that does not share its policies.
+func copyEndpoint(endpoint *hcn.HostComputeEndpoint) *hcn.HostComputeEndpoint {
+	copied := *endpoint
+	copied.Policies = append([]hcn.EndpointPolicy(nil), endpoint.Policies...)
+	copied.IpConfigurations = append([]hcn.IpConfig(nil), endpoint.IpConfigurations...)
+	return &copied
+}
+
+func (fake *fakeHNS) getEndpointByID(endpointID string) (*hcn.HostComputeEndpoint, error) {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+
+	endpoint, ok := fake.endpoints[endpointID]
+	if !ok {
+		return nil, hcn.EndpointNotFoundError{EndpointID: endpointID}
+	}
+	return copyEndpoint(endpoint), nil
+}
+
+func (fake *fakeHNS) listEndpoints() ([]hcn.HostComputeEndpoint, error) {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+
+	var endpoints []hcn.HostComputeEndpoint
+	for _, endpoint := range fake.endpoints {
+		endpoints = append(endpoints, *copyEndpoint(endpoint))
+	}
+	return endpoints, nil
+}
+
+func (fake *fakeHNS) getNamespaceEndpointIDs(namespaceID string) ([]string, error) {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+
+	var endpointIDs []string
+	for _, endpoint := range fake.endpoints {
+		if endpoint.HostComputeNamespace == namespaceID {
+			endpointIDs = append(endpointIDs, endpoint.Id)
+		}
+	}
+	return endpointIDs, nil
+}
+
+func (fake *fakeHNS) listNetworks() ([]hcn.HostComputeNetwork, error) {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+
+	var networks []hcn.HostComputeNetwork
+	for _, network := range fake.networks {
+		networks = append(networks, *network)
+	}
+	return networks, nil
+}
+
+func (fake *fakeHNS) getNetworkByID(networkID string) (*hcn.HostComputeNetwork, error) {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+
+	network, ok := fake.networks[networkID]
+	if !ok {
+		return nil, hcn.NetworkNotFoundError{NetworkID: networkID}
+	}
+	copied := *network
+	return &copied, nil
+}
+
+func (fake *fakeHNS) modifyEndpointSettings(endpointID string, request *hcn.ModifyEndpointSettingRequest) error {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+
+	endpoint, ok := fake.endpoints[endpointID]
+	if !ok {
+		return hcn.EndpointNotFoundError{EndpointID: endpointID}
+	}
+	var policyRequest hcn.PolicyEndpointRequest
+	if err := json.Unmarshal(request.Settings, &policyRequest); err != nil {
+		return err
+	}
+	recorded := fakeRequest{EndpointID: endpointID, RequestType: request.RequestType, Policies: policyRequest.Policies}
+	fake.requests = append(fake.requests, recorded)
+	if fake.fail != nil {
+		if err := fake.fail(recorded); err != nil {
+			return err
+		}
+	}
+
+	switch request.RequestType {
+	case hcn.RequestTypeAdd:
+		endpoint.Policies = append(endpoint.Policies, policyRequest.Policies...)
+	case hcn.RequestTypeRemove:
+		for _, policy := range policyRequest.Policies {
+			i := indexOfPolicy(endpoint.Policies, policy)
+			if i < 0 {
+				return fmt.Errorf("endpoint %s has no policy %s", endpointID, policy.Settings)
+			}
+			endpoint.Policies = append(endpoint.Policies[:i:i], endpoint.Policies[i+1:]...)
+		}
+	case hcn.RequestTypeUpdate:
+		var kept []hcn.EndpointPolicy
+		for _, policy := range endpoint.Policies {
+			if policy.Type != hcn.L4WFPPROXY {
+				kept = append(kept, policy)
+			}
+		}
+		endpoint.Policies = append(kept, policyRequest.Policies...)
+	default:
+		return fmt.Errorf("unsupported request type %s", request.RequestType)
+	}
+	return nil
+}
+
+// indexOfPolicy returns the index of the first of the policies with the type
+// and settings of policy, or -1 if there is none.
+func indexOfPolicy(policies []hcn.EndpointPolicy, policy hcn.EndpointPolicy) int {
+	for i, p := range policies {
+		if p.Type == policy.Type && bytes.Equal(p.Settings, policy.Settings) {
+			return i
+		}
+	}
+	return -1
+}
+
+// proxyEndpoint returns an endpoint with the given proxy policies.
+func proxyEndpoint(t *testing.T, endpointID string, policies ...Policy) hcn.HostComputeEndpoint {
+	t.Helper()
+	endpoint := hcn.HostComputeEndpoint{Id: endpointID}
+	for _, policy := range policies {
+		endpoint.Policies = append(endpoint.Policies, mustRenderPolicy(t, policy))
+	}
+	return endpoint
+}
+
+// mustRenderPolicy renders the policy, failing the test if it is invalid.
+func mustRenderPolicy(t *testing.T, policy Policy) hcn.EndpointPolicy {
+	t.Helper()
+	endpointPolicy, err := RenderPolicy(policy)
+	if err != nil {
+		t.Fatalf("RenderPolicy(%+v): %v", policy, err)
+	}
+	return endpointPolicy
+}
+
+// mustListPolicies lists the proxy policies of the endpoint, failing the test
+// if they cannot be listed.
+func mustListPolicies(t *testing.T, endpointID string) []Policy {
+	t.Helper()
+	policies, err := ListPolicies(endpointID)
+	if err != nil {
+		t.Fatalf("ListPolicies(%s): %v", endpointID, err)
+	}
+	return policies
+}
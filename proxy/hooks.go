@@ -0,0 +1,99 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package hcnproxyctrl
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Microsoft/hcsshim/hcn"
+)
+
+// HookEvent describes a change made to the proxy policies of an endpoint.
+type HookEvent struct {
+	// "add", "remove", "clear", "update" or "reconcile", or the request type
+	// in lowercase (eg. "refresh") for ApplyPolicyRequest. Normalizing
+	// policies is an "update".
+	Operation  string
+	EndpointID string
+
+	// The policies being added, removed or, for updates, their new version.
+	// For reconciliations, the desired policies.
+	Policies []Policy
+}
+
+// Hooks are functions called around every change made to proxy policies by
+// this package. Either of them can be nil.
+type Hooks struct {
+	// PreApply is called before the change is made. If it returns an error,
+	// the change is not made and the error is returned by the operation.
+	PreApply func(event HookEvent) error
+
+	// PostApply is called after the change was attempted, with its result.
+	PostApply func(event HookEvent, err error)
+
+	// PostRequest is called after every request made to HNS to modify the
+	// policies of an endpoint, with its outcome, eg. to include it in bug
+	// reports. A change can take several requests.
+	PostRequest func(result RequestResult)
+}
+
+// RequestResult is the outcome of a request made to HNS to modify the
+// policies of an endpoint. hcsshim does not return the result document of
+// HNS on success; on failure, it is part of the error message.
+type RequestResult struct {
+	EndpointID  string
+	RequestType hcn.RequestType
+
+	// The ModifyEndpointSettingRequest sent to HNS, as JSON.
+	Request  json.RawMessage
+	Err      error
+	Duration time.Duration
+}
+
+var (
+	hooksMutex sync.RWMutex
+	hooks      Hooks
+)
+
+// SetHooks sets the hooks called around every change made to proxy policies,
+// replacing the previous ones.
+func SetHooks(h Hooks) {
+	hooksMutex.Lock()
+	defer hooksMutex.Unlock()
+	hooks = h
+}
+
+// withHooks calls apply, which makes the change described by the event,
+// between the hooks.
+func withHooks(event HookEvent, apply func() error) error {
+	hooksMutex.RLock()
+	h := hooks
+	hooksMutex.RUnlock()
+
+	if h.PreApply != nil {
+		if err := h.PreApply(event); err != nil {
+			return fmt.Errorf("%s aborted by the pre-apply hook: %v", event.Operation, err)
+		}
+	}
+	err := apply()
+	if h.PostApply != nil {
+		h.PostApply(event, err)
+	}
+	return err
+}
+
+// notifyRequest calls the PostRequest hook, if any, with the outcome of an
+// HNS request.
+func notifyRequest(result RequestResult) {
+	hooksMutex.RLock()
+	postRequest := hooks.PostRequest
+	hooksMutex.RUnlock()
+
+	if postRequest != nil {
+		postRequest(result)
+	}
+}
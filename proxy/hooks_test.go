@@ -0,0 +1,169 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package hcnproxyctrl
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Microsoft/hcsshim/hcn"
+)
+
+// recordHooks sets hooks recording the calls made to them for the duration of
+// the test. preApplyErr, if not nil, is returned by the pre-apply hook.
+func recordHooks(t *testing.T, preApplyErr error) *[]string {
+	var calls []string
+	SetHooks(Hooks{
+		PreApply: func(event HookEvent) error {
+			calls = append(calls, fmt.Sprintf("pre %s %s %d", event.Operation, event.EndpointID, len(event.Policies)))
+			return preApplyErr
+		},
+		PostApply: func(event HookEvent, err error) {
+			calls = append(calls, fmt.Sprintf("post %s %s %v", event.Operation, event.EndpointID, err))
+		},
+		PostRequest: func(result RequestResult) {
+			calls = append(calls, fmt.Sprintf("request %s %s %v", result.RequestType, result.EndpointID, result.Err))
+		},
+	})
+	t.Cleanup(func() { SetHooks(Hooks{}) })
+	return &calls
+}
+
+func TestHooksOrder(t *testing.T) {
+	fake := newFakeHNS(t, proxyEndpoint(t, "ep", Policy{ProxyPort: "15001"}))
+	calls := recordHooks(t, nil)
+
+	if err := AddPolicy("ep", Policy{ProxyPort: "15002"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ClearPolicies("ep"); err != nil {
+		t.Fatal(err)
+	}
+	fake.fail = func(request fakeRequest) error { return errors.New("HNS failure") }
+	if err := AddPolicy("ep", Policy{ProxyPort: "15003"}); err == nil {
+		t.Fatal("AddPolicy succeeded despite HNS failing")
+	}
+
+	want := []string{
+		"pre add ep 1",
+		"request Add ep <nil>",
+		"post add ep <nil>",
+		"pre clear ep 2",
+		"request Remove ep <nil>",
+		"post clear ep <nil>",
+		"pre add ep 1",
+		"request Add ep HNS failure",
+		"post add ep HNS failure",
+	}
+	if !reflect.DeepEqual(*calls, want) {
+		t.Errorf("hook calls:\n%s\nwant:\n%s", strings.Join(*calls, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestRequestResult(t *testing.T) {
+	oldModifyEndpointSettings := modifyEndpointSettings
+	t.Cleanup(func() { modifyEndpointSettings = oldModifyEndpointSettings })
+	var results []RequestResult
+	SetHooks(Hooks{PostRequest: func(result RequestResult) { results = append(results, result) }})
+	t.Cleanup(func() { SetHooks(Hooks{}) })
+
+	policies := []hcn.EndpointPolicy{mustRenderPolicy(t, Policy{ProxyPort: "15001"})}
+	failure := errors.New("HNS failure")
+	for _, modifyErr := range []error{nil, failure} {
+		var sent *hcn.ModifyEndpointSettingRequest
+		modifyEndpointSettings = func(endpointID string, request *hcn.ModifyEndpointSettingRequest) error {
+			sent = request
+			return modifyErr
+		}
+		results = nil
+
+		if err := applyRequest("ep", hcn.RequestTypeAdd, policies); err != modifyErr {
+			t.Errorf("applyRequest = %v, want %v", err, modifyErr)
+		}
+		if len(results) != 1 {
+			t.Fatalf("PostRequest called %d times, want once", len(results))
+		}
+		result := results[0]
+		if result.EndpointID != "ep" || result.RequestType != hcn.RequestTypeAdd || result.Err != modifyErr {
+			t.Errorf("RequestResult = {%s %s %v}, want {ep Add %v}", result.EndpointID, result.RequestType, result.Err, modifyErr)
+		}
+		want, _ := json.Marshal(sent)
+		if string(result.Request) != string(want) {
+			t.Errorf("RequestResult.Request = %s, want the request sent to HNS %s", result.Request, want)
+		}
+	}
+}
+
+func TestReconcileHooks(t *testing.T) {
+	newFakeHNS(t, proxyEndpoint(t, "ep", Policy{ProxyPort: "15001"}, Policy{ProxyPort: "15002"}))
+	calls := recordHooks(t, nil)
+
+	desired := []Policy{{ProxyPort: "15001"}}
+	if _, err := ReconcilePolicies("ep", desired, ReconcileOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	// In sync: no change is made, so the hooks are not called.
+	if _, err := ReconcilePolicies("ep", desired, ReconcileOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"pre reconcile ep 1",
+		"request Remove ep <nil>",
+		"post reconcile ep <nil>",
+	}
+	if !reflect.DeepEqual(*calls, want) {
+		t.Errorf("hook calls:\n%s\nwant:\n%s", strings.Join(*calls, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestPreApplyHookAborts(t *testing.T) {
+	tests := []struct {
+		operation string
+		change    func() error
+	}{
+		{"add", func() error { return AddPolicy("ep", Policy{ProxyPort: "15002"}) }},
+		{"clear", func() error {
+			_, err := ClearPolicies("ep")
+			return err
+		}},
+		{"update", func() error {
+			_, err := UpdatePoliciesMatching("ep", PolicyFilter{}, func(policy *Policy) { policy.ProxyPort = "16001" })
+			return err
+		}},
+		{"reconcile", func() error {
+			_, err := ReconcilePolicies("ep", nil, ReconcileOptions{})
+			return err
+		}},
+		{"reconcile", func() error {
+			results := ReconcileAll(map[string][]Policy{"ep": nil}, 1)
+			return results["ep"].Err
+		}},
+	}
+
+	for _, test := range tests {
+		fake := newFakeHNS(t, proxyEndpoint(t, "ep", Policy{ProxyPort: "15001"}))
+		calls := recordHooks(t, errors.New("change freeze"))
+
+		err := test.change()
+		wantErr := test.operation + " aborted by the pre-apply hook: change freeze"
+		if err == nil || err.Error() != wantErr {
+			t.Errorf("%s error %v, want %q", test.operation, err, wantErr)
+		}
+		if len(fake.requests) > 0 {
+			t.Errorf("%s made requests %+v despite being aborted", test.operation, fake.requests)
+		}
+		if len(*calls) != 1 || !strings.HasPrefix((*calls)[0], "pre "+test.operation) {
+			t.Errorf("%s called the hooks %q, want only the pre-apply hook", test.operation, *calls)
+		}
+		want := []Policy{{ProxyPort: "15001", Protocol: "6"}}
+		if got := mustListPolicies(t, "ep"); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s left the policies %+v, want %+v", test.operation, got, want)
+		}
+	}
+}
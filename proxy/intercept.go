@@ -0,0 +1,94 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package hcnproxyctrl
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// InterceptMarker is the line a test listener sends on every connection it
+// accepts, followed by its port, for VerifyIntercept to tell that the
+// connection was redirected to it. See ServeInterceptMarker.
+const InterceptMarker = "HCNPROXY-INTERCEPTED"
+
+// Connector opens a TCP connection to a "host:port" address.
+type Connector func(address string) (net.Conn, error)
+
+// DialTCP is the default Connector. It gives up after five seconds.
+func DialTCP(address string) (net.Conn, error) {
+	return net.DialTimeout("tcp", address, 5*time.Second)
+}
+
+// InterceptResult is the outcome of VerifyIntercept.
+type InterceptResult struct {
+	Intercepted bool
+	// ProxyPort is the port of the test listener the connection landed on,
+	// if Intercepted.
+	ProxyPort string
+	// Detail explains the outcome.
+	Detail string
+}
+
+// VerifyIntercept connects to the remote address and reports whether the
+// connection was redirected to a test listener, ie. whether it received the
+// InterceptMarker. For this to work, a test listener must be serving on the
+// proxy port in place of the proxy.
+func VerifyIntercept(remote string, connect Connector) InterceptResult {
+	conn, err := connect(remote)
+	if err != nil {
+		return interpretProbe("", err)
+	}
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil && len(line) == 0 {
+		return InterceptResult{Detail: fmt.Sprintf("connected to %s, which sent no marker (%v)", remote, err)}
+	}
+	return interpretProbe(line, nil)
+}
+
+// interpretProbe interprets the first line received on a probe connection, or
+// the error that prevented connecting.
+func interpretProbe(line string, connectErr error) InterceptResult {
+	if connectErr != nil {
+		return InterceptResult{Detail: fmt.Sprintf("could not connect: %v", connectErr)}
+	}
+
+	fields := strings.Fields(line)
+	if len(fields) != 2 || fields[0] != InterceptMarker {
+		return InterceptResult{Detail: fmt.Sprintf("connected, but to something other than a test listener (it sent %q)", strings.TrimSpace(line))}
+	}
+	return InterceptResult{
+		Intercepted: true,
+		ProxyPort:   fields[1],
+		Detail:      "landed on the test listener on port " + fields[1],
+	}
+}
+
+// ServeInterceptMarker accepts connections on the listener, sending each of
+// them the InterceptMarker followed by the port of the listener, then closing
+// them. It returns when the listener fails, eg. when it is closed.
+func ServeInterceptMarker(listener net.Listener) error {
+	_, port, err := net.SplitHostPort(listener.Addr().String())
+	if err != nil {
+		return err
+	}
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			defer conn.Close()
+			conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+			fmt.Fprintf(conn, "%s %s\n", InterceptMarker, port)
+		}()
+	}
+}
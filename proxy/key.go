@@ -0,0 +1,30 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package hcnproxyctrl
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+)
+
+// Key returns a short string identifying the policy. Policies that are
+// equivalent, ie. that have the same canonical form (see NormalizePolicy),
+// have the same key.
+func (policy Policy) Key() string {
+	policy = NormalizePolicy(policy)
+	fields := []string{
+		policy.ProxyPort,
+		policy.UserSID,
+		policy.LocalAddresses,
+		policy.RemoteAddresses,
+		policy.LocalPorts,
+		policy.RemotePorts,
+		strconv.Itoa(int(policy.Priority)),
+		policy.Protocol,
+	}
+	sum := sha256.Sum256([]byte(strings.Join(fields, "|")))
+	return hex.EncodeToString(sum[:8])
+}
@@ -0,0 +1,90 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package hcnproxyctrl
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Microsoft/hcsshim/hcn"
+)
+
+func TestKey(t *testing.T) {
+	policy := Policy{ProxyPort: "15001", UserSID: "S-1-5-18", RemoteAddresses: "10.0.0.1,10.0.0.2", RemotePorts: "80", Protocol: "6"}
+
+	equivalent := []Policy{
+		{ProxyPort: "15001", UserSID: "s-1-5-18", RemoteAddresses: "10.0.0.2, 10.0.0.1", RemotePorts: "80"},
+		{ProxyPort: "15001", UserSID: "S-1-5-18", RemoteAddresses: "10.0.0.1,10.0.0.2,10.0.0.1", RemotePorts: "80", Protocol: "tcp"},
+	}
+	for _, other := range equivalent {
+		if other.Key() != policy.Key() {
+			t.Errorf("%+v and %+v are equivalent but have different keys", other, policy)
+		}
+	}
+
+	different := []Policy{
+		{ProxyPort: "15002", UserSID: "S-1-5-18", RemoteAddresses: "10.0.0.1,10.0.0.2", RemotePorts: "80"},
+		{ProxyPort: "15001", RemoteAddresses: "10.0.0.1,10.0.0.2", RemotePorts: "80"},
+		{ProxyPort: "15001", UserSID: "S-1-5-18", RemoteAddresses: "10.0.0.1", RemotePorts: "80"},
+		{ProxyPort: "15001", UserSID: "S-1-5-18", RemoteAddresses: "10.0.0.1,10.0.0.2", RemotePorts: "443"},
+		{ProxyPort: "15001", UserSID: "S-1-5-18", RemoteAddresses: "10.0.0.1,10.0.0.2", RemotePorts: "80", Priority: 1},
+		{ProxyPort: "15001", UserSID: "S-1-5-18", RemoteAddresses: "10.0.0.1,10.0.0.2", RemotePorts: "80", Protocol: "udp"},
+		// Fields are not allowed to run into each other.
+		{ProxyPort: "15001", UserSID: "S-1-5-18", LocalAddresses: "10.0.0.1,10.0.0.2", RemotePorts: "80"},
+	}
+	for _, other := range different {
+		if other.Key() == policy.Key() {
+			t.Errorf("%+v and %+v have the same key", other, policy)
+		}
+	}
+}
+
+func TestRemovePoliciesByKeys(t *testing.T) {
+	web := Policy{ProxyPort: "15001", RemotePorts: "80"}
+	tls := Policy{ProxyPort: "15001", RemotePorts: "443"}
+	db := Policy{ProxyPort: "15002", RemotePorts: "5432"}
+	fake := newFakeHNS(t, proxyEndpoint(t, "ep", web, tls, db, web))
+
+	// Keys are compared in canonical form.
+	webKey := Policy{ProxyPort: "15001", RemotePorts: "80", Protocol: "tcp"}.Key()
+	unknownKey := Policy{ProxyPort: "16001"}.Key()
+
+	removed, err := RemovePoliciesByKeys("ep", []string{webKey, db.Key(), unknownKey, unknownKey})
+	var unmatched *UnmatchedKeysError
+	if !errors.As(err, &unmatched) || !reflect.DeepEqual(unmatched.Keys, []string{unknownKey}) {
+		t.Errorf("RemovePoliciesByKeys error %v, want an *UnmatchedKeysError listing %s once", err, unknownKey)
+	}
+	if removed != 3 {
+		t.Errorf("RemovePoliciesByKeys removed %d policies, want both copies of the web policy and the db one", removed)
+	}
+	if len(fake.requests) != 1 || fake.requests[0].RequestType != hcn.RequestTypeRemove || len(fake.requests[0].Policies) != 3 {
+		t.Errorf("RemovePoliciesByKeys made requests %+v, want a single request removing 3 policies", fake.requests)
+	}
+	want := []Policy{{ProxyPort: "15001", RemotePorts: "443", Protocol: "6"}}
+	if got := mustListPolicies(t, "ep"); !reflect.DeepEqual(got, want) {
+		t.Errorf("policies after removal %+v, want %+v", got, want)
+	}
+
+	fake.requests = nil
+	removed, err = RemovePoliciesByKeys("ep", []string{unknownKey})
+	if !errors.As(err, &unmatched) || removed != 0 || len(fake.requests) > 0 {
+		t.Errorf("RemovePoliciesByKeys of an unknown key = %d, %v with requests %+v, want an *UnmatchedKeysError and no request", removed, err, fake.requests)
+	}
+}
+
+func TestRemovePoliciesByKeysFailure(t *testing.T) {
+	policy := Policy{ProxyPort: "15001"}
+	fake := newFakeHNS(t, proxyEndpoint(t, "ep", policy))
+	fake.fail = func(request fakeRequest) error { return errors.New("HNS failure") }
+
+	removed, err := RemovePoliciesByKeys("ep", []string{policy.Key(), Policy{ProxyPort: "16001"}.Key()})
+	if err == nil || !strings.Contains(err.Error(), "HNS failure") || removed != 0 {
+		t.Errorf("RemovePoliciesByKeys = %d, %v, want 0 and the HNS error", removed, err)
+	}
+	if got := mustListPolicies(t, "ep"); len(got) != 1 {
+		t.Errorf("policies after a failed removal %+v, want the policy kept", got)
+	}
+}
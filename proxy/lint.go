@@ -0,0 +1,175 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package hcnproxyctrl
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Severity is the severity of a lint violation.
+type Severity string
+
+const (
+	// SeverityError marks violations that must be fixed.
+	SeverityError Severity = "error"
+	// SeverityWarning marks violations that should be looked at.
+	SeverityWarning Severity = "warning"
+)
+
+// LintRules are the conventions of an organization that Lint checks policies
+// against, on top of their validity.
+type LintRules struct {
+	// Fields of the policies that must be set (eg. "UserSID").
+	RequiredFields []FieldRule
+
+	// Fields of the policies that must not match any value, either by being
+	// left blank or by covering everything (eg. "0.0.0.0/0" or "1-65535").
+	// Only the address and port fields can be checked.
+	DisallowedWildcards []FieldRule
+
+	// Band the priority of the policies must be in, eg. "1000-2000".
+	PriorityBand *PriorityBandRule
+}
+
+// FieldRule is a lint rule about a field of the policies.
+type FieldRule struct {
+	Field string
+	// Defaults to SeverityError if left blank.
+	Severity Severity
+}
+
+// PriorityBandRule is a lint rule about the priority of the policies.
+type PriorityBandRule struct {
+	Band string
+	// Defaults to SeverityError if left blank.
+	Severity Severity
+}
+
+// LintViolation is a policy not following a lint rule.
+type LintViolation struct {
+	// Index of the policy in the linted list.
+	Index    int
+	Rule     string
+	Severity Severity
+	Message  string
+}
+
+// lintFields returns the value of the fields of the policy that lint rules
+// can refer to, blank if unset.
+func lintFields(policy Policy) map[string]string {
+	priority := ""
+	if policy.Priority != 0 {
+		priority = strconv.Itoa(int(policy.Priority))
+	}
+	return map[string]string{
+		"ProxyPort":       policy.ProxyPort,
+		"UserSID":         policy.UserSID,
+		"LocalAddresses":  policy.LocalAddresses,
+		"RemoteAddresses": policy.RemoteAddresses,
+		"LocalPorts":      policy.LocalPorts,
+		"RemotePorts":     policy.RemotePorts,
+		"Priority":        priority,
+		"Protocol":        policy.Protocol,
+	}
+}
+
+// wildcards are the values of the address and port fields that match any
+// address or port, besides the blank value.
+var wildcards = map[string][]string{
+	"LocalAddresses":  {"*", "0.0.0.0/0", "::/0"},
+	"RemoteAddresses": {"*", "0.0.0.0/0", "::/0"},
+	"LocalPorts":      {"*", "0-65535", "1-65535"},
+	"RemotePorts":     {"*", "0-65535", "1-65535"},
+}
+
+// Lint checks the policies against the rules, and returns the violations
+// found, in the order of the policies. Invalid policies are reported as
+// errors of the "valid" rule. An error is returned if the rules themselves
+// are invalid.
+func Lint(policies []Policy, rules LintRules) ([]LintViolation, error) {
+	for _, rule := range rules.RequiredFields {
+		if _, ok := lintFields(Policy{})[rule.Field]; !ok {
+			return nil, fmt.Errorf("unknown policy field %q in required fields", rule.Field)
+		}
+		if err := checkSeverity(rule.Severity); err != nil {
+			return nil, err
+		}
+	}
+	for _, rule := range rules.DisallowedWildcards {
+		if _, ok := wildcards[rule.Field]; !ok {
+			return nil, fmt.Errorf("wildcards cannot be checked for the policy field %q", rule.Field)
+		}
+		if err := checkSeverity(rule.Severity); err != nil {
+			return nil, err
+		}
+	}
+	var band PriorityBand
+	if rules.PriorityBand != nil {
+		var err error
+		if band, err = ParsePriorityBand(rules.PriorityBand.Band); err != nil {
+			return nil, err
+		}
+		if err := checkSeverity(rules.PriorityBand.Severity); err != nil {
+			return nil, err
+		}
+	}
+
+	var violations []LintViolation
+	report := func(index int, rule string, severity Severity, format string, args ...interface{}) {
+		if len(severity) == 0 {
+			severity = SeverityError
+		}
+		violations = append(violations, LintViolation{
+			Index:    index,
+			Rule:     rule,
+			Severity: severity,
+			Message:  fmt.Sprintf(format, args...),
+		})
+	}
+
+	for i, policy := range policies {
+		if err := validatePolicy(policy); err != nil {
+			problems := []error{err}
+			if validationErr, ok := err.(*ValidationError); ok {
+				problems = validationErr.Problems
+			}
+			for _, problem := range problems {
+				report(i, "valid", SeverityError, "%v", problem)
+			}
+		}
+
+		fields := lintFields(policy)
+		for _, rule := range rules.RequiredFields {
+			if len(fields[rule.Field]) == 0 {
+				report(i, "required-field", rule.Severity, "%s must be set", rule.Field)
+			}
+		}
+		for _, rule := range rules.DisallowedWildcards {
+			value := fields[rule.Field]
+			if len(value) == 0 {
+				report(i, "disallowed-wildcard", rule.Severity, "%s must be set to restrict the intercepted traffic", rule.Field)
+				continue
+			}
+			for _, wildcard := range wildcards[rule.Field] {
+				if value == wildcard {
+					report(i, "disallowed-wildcard", rule.Severity, "%s must not be %s", rule.Field, value)
+				}
+			}
+		}
+		if rules.PriorityBand != nil && (policy.Priority < band.Low || policy.Priority > band.High) {
+			report(i, "priority-band", rules.PriorityBand.Severity, "priority %d is not in the band %s", policy.Priority, rules.PriorityBand.Band)
+		}
+	}
+	return violations, nil
+}
+
+// checkSeverity returns an error if the severity of a rule is unknown.
+func checkSeverity(severity Severity) error {
+	switch severity {
+	case "", SeverityError, SeverityWarning:
+		return nil
+	}
+	return fmt.Errorf("unknown severity %q", severity)
+}
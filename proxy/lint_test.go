@@ -0,0 +1,143 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package hcnproxyctrl
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// lintTest is a case of a lint rule: the violations of the policies, each
+// formatted as "<index> <rule> <severity>: <message>".
+type lintTest struct {
+	name       string
+	policies   []Policy
+	rules      LintRules
+	violations []string
+}
+
+func runLintTests(t *testing.T, tests []lintTest) {
+	t.Helper()
+	for _, test := range tests {
+		violations, err := Lint(test.policies, test.rules)
+		if err != nil {
+			t.Errorf("%s: Lint error %v", test.name, err)
+			continue
+		}
+		var got []string
+		for _, violation := range violations {
+			got = append(got, fmt.Sprintf("%d %s %s: %s", violation.Index, violation.Rule, violation.Severity, violation.Message))
+		}
+		if !reflect.DeepEqual(got, test.violations) {
+			t.Errorf("%s: violations\n%s\nwant\n%s", test.name, strings.Join(got, "\n"), strings.Join(test.violations, "\n"))
+		}
+	}
+}
+
+func TestLintValid(t *testing.T) {
+	runLintTests(t, []lintTest{
+		{
+			name:     "valid policies",
+			policies: []Policy{{ProxyPort: "15001"}, {ProxyPort: "15002", RemotePorts: "80"}},
+		},
+		{
+			name:     "invalid policy",
+			policies: []Policy{{ProxyPort: "15001"}, {ProxyPort: "0", RemotePorts: "90-80"}},
+			violations: []string{
+				"1 valid error: invalid proxy port: port 0 is out of the range 1-65535",
+				`1 valid error: invalid remote ports: invalid port range "90-80": 90 is greater than 80`,
+			},
+		},
+	})
+}
+
+func TestLintRequiredFields(t *testing.T) {
+	rules := LintRules{RequiredFields: []FieldRule{{Field: "UserSID"}, {Field: "Priority", Severity: SeverityWarning}}}
+	runLintTests(t, []lintTest{
+		{
+			name:     "set",
+			policies: []Policy{{ProxyPort: "15001", UserSID: "S-1-5-18", Priority: 100}},
+			rules:    rules,
+		},
+		{
+			name:     "missing",
+			policies: []Policy{{ProxyPort: "15001", UserSID: "S-1-5-18"}, {ProxyPort: "15001"}},
+			rules:    rules,
+			violations: []string{
+				"0 required-field warning: Priority must be set",
+				"1 required-field error: UserSID must be set",
+				"1 required-field warning: Priority must be set",
+			},
+		},
+	})
+}
+
+func TestLintDisallowedWildcards(t *testing.T) {
+	rules := LintRules{DisallowedWildcards: []FieldRule{{Field: "RemoteAddresses", Severity: SeverityWarning}, {Field: "RemotePorts"}}}
+	runLintTests(t, []lintTest{
+		{
+			name:     "restricted",
+			policies: []Policy{{ProxyPort: "15001", RemoteAddresses: "10.0.0.0/8", RemotePorts: "80-443"}},
+			rules:    rules,
+		},
+		{
+			name:     "blank",
+			policies: []Policy{{ProxyPort: "15001", RemotePorts: "80"}},
+			rules:    rules,
+			violations: []string{
+				"0 disallowed-wildcard warning: RemoteAddresses must be set to restrict the intercepted traffic",
+			},
+		},
+		{
+			name:     "covering everything",
+			policies: []Policy{{ProxyPort: "15001", RemoteAddresses: "0.0.0.0/0", RemotePorts: "1-65535"}},
+			rules:    rules,
+			violations: []string{
+				"0 disallowed-wildcard warning: RemoteAddresses must not be 0.0.0.0/0",
+				"0 disallowed-wildcard error: RemotePorts must not be 1-65535",
+			},
+		},
+	})
+}
+
+func TestLintPriorityBand(t *testing.T) {
+	rules := LintRules{PriorityBand: &PriorityBandRule{Band: "1000-2000"}}
+	runLintTests(t, []lintTest{
+		{
+			name:     "in the band",
+			policies: []Policy{{ProxyPort: "15001", Priority: 1000}, {ProxyPort: "15001", Priority: 2000}},
+			rules:    rules,
+		},
+		{
+			name:     "out of the band",
+			policies: []Policy{{ProxyPort: "15001", Priority: 999}, {ProxyPort: "15001"}, {ProxyPort: "15001", Priority: 2001}},
+			rules:    rules,
+			violations: []string{
+				"0 priority-band error: priority 999 is not in the band 1000-2000",
+				"1 priority-band error: priority 0 is not in the band 1000-2000",
+				"2 priority-band error: priority 2001 is not in the band 1000-2000",
+			},
+		},
+	})
+}
+
+func TestLintInvalidRules(t *testing.T) {
+	tests := []struct {
+		rules LintRules
+		err   string
+	}{
+		{LintRules{RequiredFields: []FieldRule{{Field: "Usersid"}}}, `unknown policy field "Usersid" in required fields`},
+		{LintRules{RequiredFields: []FieldRule{{Field: "UserSID", Severity: "fatal"}}}, `unknown severity "fatal"`},
+		{LintRules{DisallowedWildcards: []FieldRule{{Field: "ProxyPort"}}}, `wildcards cannot be checked for the policy field "ProxyPort"`},
+		{LintRules{PriorityBand: &PriorityBandRule{Band: "2000-1000"}}, `invalid priority band "2000-1000"`},
+		{LintRules{PriorityBand: &PriorityBandRule{Band: "1000-2000", Severity: "info"}}, `unknown severity "info"`},
+	}
+	for _, test := range tests {
+		if _, err := Lint([]Policy{{ProxyPort: "15001"}}, test.rules); err == nil || err.Error() != test.err {
+			t.Errorf("Lint(%+v) error %v, want %q", test.rules, err, test.err)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package hcnproxyctrl
+
+import (
+	"strings"
+	"sync"
+)
+
+var (
+	endpointLocksMutex sync.Mutex
+	endpointLocks      = make(map[string]*sync.Mutex)
+)
+
+// lockEndpoint serializes the changes made to the proxy policies of an
+// endpoint within the process, so that concurrent read-modify-write sequences
+// do not interleave. It returns the function releasing the lock.
+func lockEndpoint(hnsEndpointID string) (unlock func()) {
+	id := strings.ToLower(hnsEndpointID)
+
+	endpointLocksMutex.Lock()
+	lock, ok := endpointLocks[id]
+	if !ok {
+		lock = &sync.Mutex{}
+		endpointLocks[id] = lock
+	}
+	endpointLocksMutex.Unlock()
+
+	lock.Lock()
+	return lock.Unlock
+}
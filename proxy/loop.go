@@ -0,0 +1,25 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package hcnproxyctrl
+
+import "strconv"
+
+// LoopRisk returns true if the policy risks redirecting the proxy's own
+// traffic back to the proxy: it has no UserSID exclusion, so that the
+// connections the proxy makes are intercepted too, and its proxy port is
+// among the intercepted remote ports (all ports if RemotePorts is empty).
+func (policy Policy) LoopRisk() bool {
+	if len(policy.UserSID) > 0 {
+		return false
+	}
+	if len(policy.RemotePorts) == 0 {
+		return true
+	}
+	port, err := strconv.Atoi(policy.ProxyPort)
+	if err != nil {
+		return false
+	}
+	interval, err := parsePortRange(policy.RemotePorts)
+	return err == nil && interval.low <= port && port <= interval.high
+}
@@ -0,0 +1,30 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package hcnproxyctrl
+
+import "testing"
+
+func TestLoopRisk(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy Policy
+		risky  bool
+	}{
+		{name: "all ports", policy: Policy{ProxyPort: "15001"}, risky: true},
+		{name: "proxy port", policy: Policy{ProxyPort: "15001", RemotePorts: "15001"}, risky: true},
+		{name: "range covering the proxy port", policy: Policy{ProxyPort: "15001", RemotePorts: "15000-15010"}, risky: true},
+		{name: "range bounded by the proxy port", policy: Policy{ProxyPort: "15001", RemotePorts: "80-15001"}, risky: true},
+		{name: "other port", policy: Policy{ProxyPort: "15001", RemotePorts: "80"}},
+		{name: "range below the proxy port", policy: Policy{ProxyPort: "15001", RemotePorts: "80-15000"}},
+		{name: "range above the proxy port", policy: Policy{ProxyPort: "15001", RemotePorts: "15002-16000"}},
+		{name: "user SID exclusion", policy: Policy{ProxyPort: "15001", UserSID: "S-1-5-18"}},
+		{name: "user SID exclusion on the proxy port", policy: Policy{ProxyPort: "15001", UserSID: "system", RemotePorts: "15001"}},
+		{name: "invalid remote ports", policy: Policy{ProxyPort: "15001", RemotePorts: "http"}},
+	}
+	for _, test := range tests {
+		if risky := test.policy.LoopRisk(); risky != test.risky {
+			t.Errorf("%s: LoopRisk() = %v, want %v", test.name, risky, test.risky)
+		}
+	}
+}
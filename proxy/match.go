@@ -0,0 +1,109 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package hcnproxyctrl
+
+import (
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Tuple describes a connection, as seen from the endpoint, to be evaluated
+// against proxy policies.
+type Tuple struct {
+	// IANA protocol number or name, eg. "6" or "tcp".
+	Protocol string
+
+	LocalAddress  string
+	LocalPort     string
+	RemoteAddress string
+	RemotePort    string
+
+	// SID of the user owning the process making the connection. (Optional)
+	UserSID string
+}
+
+// Matches returns true iff the policy would intercept the connection, ie. if
+// the connection matches all of its filters and does not originate from its
+// user SID. Empty filters match any value. Protocols may be given by name or
+// number, and the user SID of the policy may be a shorthand such as "system".
+func (policy Policy) Matches(tuple Tuple) bool {
+	protocol := policy.Protocol
+	if len(protocol) == 0 {
+		protocol = "6"
+	}
+	policyProtocol, err := ParseProtocol(protocol)
+	if err != nil {
+		return false
+	}
+	tupleProtocol, err := ParseProtocol(tuple.Protocol)
+	if err != nil || policyProtocol != tupleProtocol {
+		return false
+	}
+	if len(policy.UserSID) > 0 {
+		userSID := policy.UserSID
+		if sid, err := resolveUserSID(userSID); err == nil {
+			userSID = sid
+		}
+		if strings.EqualFold(tuple.UserSID, userSID) {
+			return false
+		}
+	}
+	return matchAddresses(policy.LocalAddresses, tuple.LocalAddress) &&
+		matchAddresses(policy.RemoteAddresses, tuple.RemoteAddress) &&
+		matchPorts(policy.LocalPorts, tuple.LocalPort) &&
+		matchPorts(policy.RemotePorts, tuple.RemotePort)
+}
+
+// MatchingPolicy returns the policy that would intercept the connection, ie.
+// the first policy matching it in order of decreasing priority, and false if
+// there is none.
+func MatchingPolicy(policies []Policy, tuple Tuple) (Policy, bool) {
+	sorted := append([]Policy(nil), policies...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Priority > sorted[j].Priority })
+	for _, policy := range sorted {
+		if policy.Matches(tuple) {
+			return policy, true
+		}
+	}
+	return Policy{}, false
+}
+
+// matchAddresses returns true if the comma-separated list of IPs and CIDRs is
+// empty or contains the address.
+func matchAddresses(list string, address string) bool {
+	if len(list) == 0 {
+		return true
+	}
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return false
+	}
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entryIP := net.ParseIP(entry); entryIP != nil {
+			if entryIP.Equal(ip) {
+				return true
+			}
+		} else if _, network, err := net.ParseCIDR(entry); err == nil && network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchPorts returns true if the port or port range is empty or contains the
+// port.
+func matchPorts(portRange string, port string) bool {
+	if len(portRange) == 0 {
+		return true
+	}
+	p, err := strconv.Atoi(port)
+	if err != nil {
+		return false
+	}
+	interval, err := parsePortRange(portRange)
+	return err == nil && interval.low <= p && p <= interval.high
+}
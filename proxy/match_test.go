@@ -0,0 +1,132 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package hcnproxyctrl
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMatches(t *testing.T) {
+	tuple := Tuple{
+		Protocol:      "6",
+		LocalAddress:  "10.0.0.5",
+		LocalPort:     "50000",
+		RemoteAddress: "10.1.2.3",
+		RemotePort:    "443",
+		UserSID:       "S-1-5-21-1-2-3-1000",
+	}
+	withTuple := func(change func(*Tuple)) Tuple {
+		changed := tuple
+		change(&changed)
+		return changed
+	}
+
+	tests := []struct {
+		name    string
+		policy  Policy
+		tuple   Tuple
+		matches bool
+	}{
+		{"empty filters", Policy{ProxyPort: "15001"}, tuple, true},
+		{"matching filters", Policy{ProxyPort: "15001", LocalAddresses: "10.0.0.0/24", RemoteAddresses: "10.2.0.0/16, 10.1.2.3", LocalPorts: "49152-65535", RemotePorts: "443"}, tuple, true},
+		{"remote address", Policy{ProxyPort: "15001", RemoteAddresses: "10.2.0.0/16"}, tuple, false},
+		{"local address", Policy{ProxyPort: "15001", LocalAddresses: "10.0.0.6"}, tuple, false},
+		{"remote port", Policy{ProxyPort: "15001", RemotePorts: "80"}, tuple, false},
+		{"local port range", Policy{ProxyPort: "15001", LocalPorts: "1-1024"}, tuple, false},
+		{"protocol by name in the policy", Policy{ProxyPort: "15001", Protocol: "tcp"}, tuple, true},
+		{"protocol by name in the tuple", Policy{ProxyPort: "15001", Protocol: "6"}, withTuple(func(t *Tuple) { t.Protocol = "TCP" }), true},
+		{"default protocol with a protocol name", Policy{ProxyPort: "15001"}, withTuple(func(t *Tuple) { t.Protocol = "tcp" }), true},
+		{"other protocol", Policy{ProxyPort: "15001", Protocol: "udp"}, tuple, false},
+		{"invalid tuple protocol", Policy{ProxyPort: "15001"}, withTuple(func(t *Tuple) { t.Protocol = "icmp" }), false},
+		{"exempted user", Policy{ProxyPort: "15001", UserSID: "S-1-5-21-1-2-3-1000"}, tuple, false},
+		{"exempted user in lowercase", Policy{ProxyPort: "15001", UserSID: "s-1-5-21-1-2-3-1000"}, tuple, false},
+		{"exempted user shorthand", Policy{ProxyPort: "15001", UserSID: "system"}, withTuple(func(t *Tuple) { t.UserSID = LocalSystemSID }), false},
+		// A SID is not exempted because the one of the policy is a prefix of it.
+		{"user SID prefix", Policy{ProxyPort: "15001", UserSID: "S-1-5-21-1-2-3-100"}, tuple, true},
+		{"other user", Policy{ProxyPort: "15001", UserSID: "S-1-5-18"}, tuple, true},
+		{"unknown user", Policy{ProxyPort: "15001", UserSID: "S-1-5-18"}, withTuple(func(t *Tuple) { t.UserSID = "" }), true},
+	}
+	for _, test := range tests {
+		if matches := test.policy.Matches(test.tuple); matches != test.matches {
+			t.Errorf("%s: %+v.Matches(%+v) = %v, want %v", test.name, test.policy, test.tuple, matches, test.matches)
+		}
+	}
+}
+
+func TestMatchingPolicy(t *testing.T) {
+	policies := []Policy{
+		{ProxyPort: "15001", Priority: 1},
+		{ProxyPort: "15002", RemotePorts: "443", Priority: 10},
+		{ProxyPort: "15003", RemotePorts: "443", Priority: 10},
+	}
+
+	policy, ok := MatchingPolicy(policies, Tuple{Protocol: "tcp", RemoteAddress: "10.0.0.1", RemotePort: "443"})
+	if !ok || policy.ProxyPort != "15002" {
+		t.Errorf("MatchingPolicy = %+v, %v, want the first policy of the highest priority", policy, ok)
+	}
+	policy, ok = MatchingPolicy(policies, Tuple{Protocol: "tcp", RemoteAddress: "10.0.0.1", RemotePort: "80"})
+	if !ok || policy.ProxyPort != "15001" {
+		t.Errorf("MatchingPolicy = %+v, %v, want the catch-all policy", policy, ok)
+	}
+	if policy, ok := MatchingPolicy(policies, Tuple{Protocol: "udp", RemoteAddress: "10.0.0.1", RemotePort: "80"}); ok {
+		t.Errorf("MatchingPolicy = %+v for a UDP connection, want none", policy)
+	}
+}
+
+func TestSimulate(t *testing.T) {
+	policies := []Policy{
+		{ProxyPort: "15001", RemotePorts: "1-1000", Protocol: "tcp"},
+		{ProxyPort: "15002", RemoteAddresses: "10.0.0.0/25", RemotePorts: "443", Priority: 10},
+		{ProxyPort: "15003", UserSID: "system", Priority: 20},
+	}
+
+	interceptions, err := Simulate(policies, TupleRange{
+		Protocol:        "tcp",
+		LocalAddresses:  "192.168.0.1",
+		LocalPorts:      "50000",
+		RemoteAddresses: "10.0.0.0/24",
+		RemotePorts:     "1-2000",
+		UserSID:         LocalSystemSID,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type interception struct {
+		proxyPort       string
+		remoteAddresses string
+		remotePorts     string
+	}
+	var got []interception
+	for _, i := range interceptions {
+		if i.LocalAddresses != "192.168.0.1" || i.LocalPorts != "50000" {
+			t.Errorf("interception %+v is not within the local addresses and ports", i)
+		}
+		proxyPort := "none"
+		if i.Intercepted {
+			proxyPort = i.Policy.ProxyPort
+		}
+		got = append(got, interception{proxyPort, i.RemoteAddresses, i.RemotePorts})
+	}
+	// The policy exempting the local system is skipped, and the one with the
+	// higher priority takes precedence where filters overlap.
+	want := []interception{
+		{"15001", "10.0.0.0/25", "1-442"},
+		{"15002", "10.0.0.0/25", "443"},
+		{"15001", "10.0.0.0/25", "444-1000"},
+		{"none", "10.0.0.0/24", "1001-2000"},
+		{"15001", "10.0.0.128/25", "1-1000"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Simulate = %+v, want %+v", got, want)
+	}
+
+	if _, err := Simulate(policies, TupleRange{Protocol: "tcp", RemoteAddresses: "fd00::/8"}); err == nil {
+		t.Error("Simulate accepted an IPv6 range")
+	}
+	if _, err := Simulate(policies, TupleRange{Protocol: "tcp", RemotePorts: "2000-1000"}); err == nil {
+		t.Error("Simulate accepted an inverted port range")
+	}
+}
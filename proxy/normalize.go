@@ -0,0 +1,68 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package hcnproxyctrl
+
+import (
+	"sort"
+	"strings"
+)
+
+// NormalizePolicy returns the canonical form of a policy, in which:
+//  - address lists are trimmed, deduplicated and sorted,
+//  - the protocol defaults to TCP, and is given by number rather than name,
+//  - the user SID shorthands are resolved, and SIDs are uppercase.
+// Policies that only differ in these respects are equivalent to HNS.
+func NormalizePolicy(policy Policy) Policy {
+	policy.LocalAddresses = normalizeAddresses(policy.LocalAddresses)
+	policy.RemoteAddresses = normalizeAddresses(policy.RemoteAddresses)
+	if len(policy.Protocol) == 0 {
+		policy.Protocol = "6"
+	} else if number, err := ParseProtocol(policy.Protocol); err == nil {
+		policy.Protocol = number
+	}
+	if sid, ok := sidShorthands[strings.ToLower(policy.UserSID)]; ok {
+		policy.UserSID = sid
+	} else {
+		policy.UserSID = strings.ToUpper(policy.UserSID)
+	}
+	return policy
+}
+
+// NormalizePolicies rewrites the proxy policies of the endpoint that are not
+// in canonical form (see NormalizePolicy), and returns how many were. If
+// dryRun is true, the policies are only counted.
+func NormalizePolicies(hnsEndpointID string, dryRun bool) (numChanged int, err error) {
+	if !dryRun {
+		return UpdatePoliciesMatching(hnsEndpointID, PolicyFilter{}, func(policy *Policy) {
+			*policy = NormalizePolicy(*policy)
+		})
+	}
+
+	policies, err := ListPolicies(hnsEndpointID)
+	if err != nil {
+		return 0, err
+	}
+	for _, policy := range policies {
+		if NormalizePolicy(policy) != policy {
+			numChanged++
+		}
+	}
+	return numChanged, nil
+}
+
+// normalizeAddresses trims, deduplicates and sorts a comma-separated list of
+// addresses.
+func normalizeAddresses(list string) string {
+	seen := make(map[string]bool)
+	var addresses []string
+	for _, address := range strings.Split(list, ",") {
+		address = strings.TrimSpace(address)
+		if len(address) > 0 && !seen[address] {
+			seen[address] = true
+			addresses = append(addresses, address)
+		}
+	}
+	sort.Strings(addresses)
+	return strings.Join(addresses, ",")
+}
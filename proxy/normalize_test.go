@@ -0,0 +1,78 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package hcnproxyctrl
+
+import "testing"
+
+func TestNormalizePolicy(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy Policy
+		want   Policy
+	}{
+		{
+			name: "addresses, protocol and SID",
+			policy: Policy{
+				ProxyPort:       "15001",
+				UserSID:         "s-1-5-18",
+				LocalAddresses:  " 10.0.0.2, 10.0.0.1,10.0.0.2",
+				RemoteAddresses: "fd00::/8, 10.1.0.0/16",
+				RemotePorts:     "80",
+				Protocol:        "UDP",
+			},
+			want: Policy{
+				ProxyPort:       "15001",
+				UserSID:         "S-1-5-18",
+				LocalAddresses:  "10.0.0.1,10.0.0.2",
+				RemoteAddresses: "10.1.0.0/16,fd00::/8",
+				RemotePorts:     "80",
+				Protocol:        "17",
+			},
+		},
+		{
+			name:   "default protocol",
+			policy: Policy{ProxyPort: "15001"},
+			want:   Policy{ProxyPort: "15001", Protocol: "6"},
+		},
+		{
+			name:   "SID shorthand",
+			policy: Policy{ProxyPort: "15001", UserSID: "System", Protocol: "6"},
+			want:   Policy{ProxyPort: "15001", UserSID: LocalSystemSID, Protocol: "6"},
+		},
+		{
+			name:   "network service shorthand",
+			policy: Policy{ProxyPort: "15001", UserSID: "networkservice", Protocol: "6"},
+			want:   Policy{ProxyPort: "15001", UserSID: NetworkServiceSID, Protocol: "6"},
+		},
+		{
+			name: "canonical",
+			policy: Policy{
+				ProxyPort:       "15001",
+				UserSID:         "S-1-5-21-1688553208-1784504425-564974220-1000",
+				LocalAddresses:  "10.0.0.1",
+				RemoteAddresses: "10.1.0.0/16,fd00::/8",
+				RemotePorts:     "80-90",
+				Protocol:        "6",
+			},
+			want: Policy{
+				ProxyPort:       "15001",
+				UserSID:         "S-1-5-21-1688553208-1784504425-564974220-1000",
+				LocalAddresses:  "10.0.0.1",
+				RemoteAddresses: "10.1.0.0/16,fd00::/8",
+				RemotePorts:     "80-90",
+				Protocol:        "6",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		normalized := NormalizePolicy(test.policy)
+		if normalized != test.want {
+			t.Errorf("%s: NormalizePolicy = %+v, want %+v", test.name, normalized, test.want)
+		}
+		if again := NormalizePolicy(normalized); again != normalized {
+			t.Errorf("%s: NormalizePolicy of a normalized policy = %+v, want it unchanged", test.name, again)
+		}
+	}
+}
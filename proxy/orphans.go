@@ -0,0 +1,124 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package hcnproxyctrl
+
+import (
+	"net"
+	"time"
+
+	"github.com/Microsoft/hcsshim/hcn"
+)
+
+// PortProber reports whether something is listening at the given
+// "host:port" address.
+type PortProber func(address string) bool
+
+// ProbeTCPPort is the default PortProber. It reports whether a TCP connection
+// to the address can be established within a second.
+func ProbeTCPPort(address string) bool {
+	conn, err := net.DialTimeout("tcp", address, time.Second)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// ProxyStatus tells whether the proxy of a policy appears to be running.
+type ProxyStatus string
+
+const (
+	// ProxyHealthy means that something is listening on the proxy port.
+	ProxyHealthy ProxyStatus = "healthy"
+	// ProxyStale means that nothing is listening on the proxy port.
+	ProxyStale ProxyStatus = "stale"
+	// ProxyUnknown means that the proxy port could not be probed, because
+	// the endpoint has no IP address.
+	ProxyUnknown ProxyStatus = "unknown"
+)
+
+// PolicyStatus is a proxy policy along with the status of its proxy.
+type PolicyStatus struct {
+	Policy Policy
+	Status ProxyStatus
+}
+
+// CheckPolicies returns the proxy policies of the given endpoint along with
+// the status of their proxy, as determined by probing the proxy port on the
+// IP addresses of the endpoint. The status is ProxyUnknown if the endpoint has
+// no IP address.
+func CheckPolicies(hnsEndpointID string, probe PortProber) ([]PolicyStatus, error) {
+	endpoint, err := getEndpointByID(hnsEndpointID)
+	if err != nil {
+		return nil, err
+	}
+	return checkEndpointPolicies(*endpoint, probe), nil
+}
+
+// OrphanedPolicy is a proxy policy whose proxy does not appear to be running.
+type OrphanedPolicy struct {
+	EndpointID string
+	Policy     Policy
+}
+
+// FindOrphanedPolicies returns the proxy policies, across all the HNS
+// endpoints, whose proxy port has no listener on any of the IP addresses of
+// the endpoint, as reported by probe. This typically happens when the proxy
+// container crashed without its policies being cleared. The policies of
+// endpoints without IP addresses cannot be checked, and are not reported.
+func FindOrphanedPolicies(probe PortProber) ([]OrphanedPolicy, error) {
+	endpoints, err := listEndpoints()
+	if err != nil {
+		return nil, err
+	}
+
+	var orphans []OrphanedPolicy
+	for _, endpoint := range endpoints {
+		for _, status := range checkEndpointPolicies(endpoint, probe) {
+			if status.Status == ProxyStale {
+				orphans = append(orphans, OrphanedPolicy{
+					EndpointID: endpoint.Id,
+					Policy:     status.Policy,
+				})
+			}
+		}
+	}
+
+	return orphans, nil
+}
+
+// checkEndpointPolicies returns the status of each proxy policy of the
+// endpoint. Each distinct proxy port is only probed once.
+func checkEndpointPolicies(endpoint hcn.HostComputeEndpoint, probe PortProber) []PolicyStatus {
+	var statuses []PolicyStatus
+	portStatuses := make(map[string]ProxyStatus)
+	for _, hcnPolicy := range endpoint.Policies {
+		if hcnPolicy.Type != hcn.L4WFPPROXY {
+			continue
+		}
+		policy := hcnPolicyToAPIPolicy(hcnPolicy)
+		status, ok := portStatuses[policy.ProxyPort]
+		if !ok {
+			status = proxyStatus(endpoint, policy.ProxyPort, probe)
+			portStatuses[policy.ProxyPort] = status
+		}
+		statuses = append(statuses, PolicyStatus{Policy: policy, Status: status})
+	}
+	return statuses
+}
+
+// proxyStatus probes the proxy port at each of the IP addresses of the
+// endpoint, and returns ProxyHealthy if any of them has a listener, or
+// ProxyUnknown if the endpoint has no IP address.
+func proxyStatus(endpoint hcn.HostComputeEndpoint, proxyPort string, probe PortProber) ProxyStatus {
+	if len(endpoint.IpConfigurations) == 0 {
+		return ProxyUnknown
+	}
+	for _, ipConfig := range endpoint.IpConfigurations {
+		if probe(net.JoinHostPort(ipConfig.IpAddress, proxyPort)) {
+			return ProxyHealthy
+		}
+	}
+	return ProxyStale
+}
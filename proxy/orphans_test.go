@@ -0,0 +1,115 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package hcnproxyctrl
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/Microsoft/hcsshim/hcn"
+)
+
+// fakeProber reports listeners at the given addresses, and counts the probes
+// of each address.
+type fakeProber struct {
+	listening map[string]bool
+	probes    map[string]int
+}
+
+func newFakeProber(listening ...string) *fakeProber {
+	prober := &fakeProber{listening: make(map[string]bool), probes: make(map[string]int)}
+	for _, address := range listening {
+		prober.listening[address] = true
+	}
+	return prober
+}
+
+func (prober *fakeProber) probe(address string) bool {
+	prober.probes[address]++
+	return prober.listening[address]
+}
+
+func TestCheckPolicies(t *testing.T) {
+	policies := []Policy{
+		{ProxyPort: "15001", RemotePorts: "80"},
+		{ProxyPort: "15001", RemotePorts: "443"},
+		{ProxyPort: "15002"},
+	}
+
+	tests := []struct {
+		name      string
+		addresses []string
+		listening []string
+		want      []ProxyStatus
+		probes    map[string]int
+	}{
+		{
+			name:      "healthy and stale",
+			addresses: []string{"10.0.0.5"},
+			listening: []string{"10.0.0.5:15001"},
+			want:      []ProxyStatus{ProxyHealthy, ProxyHealthy, ProxyStale},
+			probes:    map[string]int{"10.0.0.5:15001": 1, "10.0.0.5:15002": 1},
+		},
+		{
+			name:      "listening on any address",
+			addresses: []string{"10.0.0.5", "fd00::5"},
+			listening: []string{"[fd00::5]:15002"},
+			want:      []ProxyStatus{ProxyStale, ProxyStale, ProxyHealthy},
+			probes:    map[string]int{"10.0.0.5:15001": 1, "[fd00::5]:15001": 1, "10.0.0.5:15002": 1, "[fd00::5]:15002": 1},
+		},
+		{
+			name:   "no address",
+			want:   []ProxyStatus{ProxyUnknown, ProxyUnknown, ProxyUnknown},
+			probes: map[string]int{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			endpoint := proxyEndpoint(t, "ep", policies...)
+			for _, address := range test.addresses {
+				endpoint.IpConfigurations = append(endpoint.IpConfigurations, hcn.IpConfig{IpAddress: address})
+			}
+			newFakeHNS(t, endpoint)
+			prober := newFakeProber(test.listening...)
+
+			statuses, err := CheckPolicies("ep", prober.probe)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []ProxyStatus
+			for _, status := range statuses {
+				got = append(got, status.Status)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("statuses %v, want %v", got, test.want)
+			}
+			if !reflect.DeepEqual(prober.probes, test.probes) {
+				t.Errorf("probes %v, want %v", prober.probes, test.probes)
+			}
+		})
+	}
+}
+
+func TestFindOrphanedPolicies(t *testing.T) {
+	withAddress := func(endpoint hcn.HostComputeEndpoint, address string) hcn.HostComputeEndpoint {
+		endpoint.IpConfigurations = []hcn.IpConfig{{IpAddress: address}}
+		return endpoint
+	}
+	newFakeHNS(t,
+		withAddress(proxyEndpoint(t, "healthy", Policy{ProxyPort: "15001"}), "10.0.0.1"),
+		withAddress(proxyEndpoint(t, "stale", Policy{ProxyPort: "15001"}), "10.0.0.2"),
+		proxyEndpoint(t, "unknown", Policy{ProxyPort: "15001"}),
+	)
+	prober := newFakeProber("10.0.0.1:15001")
+
+	orphans, err := FindOrphanedPolicies(prober.probe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []OrphanedPolicy{{EndpointID: "stale", Policy: Policy{ProxyPort: "15001", Protocol: "6"}}}
+	if !reflect.DeepEqual(orphans, want) {
+		t.Errorf("orphans %+v, want %+v", orphans, want)
+	}
+}
@@ -0,0 +1,137 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package hcnproxyctrl
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// portInterval is an inclusive range of ports.
+type portInterval struct {
+	low, high int
+}
+
+func (r portInterval) String() string {
+	if r.low == r.high {
+		return strconv.Itoa(r.low)
+	}
+	return fmt.Sprintf("%d-%d", r.low, r.high)
+}
+
+// parsePortRange parses a single port (eg. "80") or a port range
+// (eg. "8000-9000").
+func parsePortRange(s string) (portInterval, error) {
+	lowStr, highStr := s, s
+	if i := strings.Index(s, "-"); i >= 0 {
+		lowStr, highStr = s[:i], s[i+1:]
+	}
+
+	low, err := parsePort(lowStr)
+	if err != nil {
+		return portInterval{}, fmt.Errorf("invalid port range %q", s)
+	}
+	high, err := parsePort(highStr)
+	if err != nil {
+		return portInterval{}, fmt.Errorf("invalid port range %q", s)
+	}
+	return portInterval{low, high}, nil
+}
+
+// parsePort parses a port number made of digits only: unlike strconv.Atoi,
+// it rejects signs such as in "+80".
+func parsePort(s string) (int, error) {
+	if len(s) == 0 || strings.TrimLeft(s, "0123456789") != "" {
+		return 0, fmt.Errorf("%q is not a port number", s)
+	}
+	return strconv.Atoi(s)
+}
+
+// validatePort returns an error naming the value if it is not a port number
+// between 1 and 65535.
+func validatePort(s string) error {
+	port, err := parsePort(s)
+	if err != nil {
+		return fmt.Errorf("%q is not a port number", s)
+	}
+	if port < 1 || port > 65535 {
+		return fmt.Errorf("port %d is out of the range 1-65535", port)
+	}
+	return nil
+}
+
+// validatePortRange returns an error describing the value if it is neither a
+// single port nor a port range "low-high", whose ends are between 1 and 65535
+// and with low <= high.
+func validatePortRange(s string) error {
+	if !strings.Contains(s, "-") {
+		return validatePort(s)
+	}
+	r, err := parsePortRange(s)
+	if err != nil {
+		return fmt.Errorf("invalid port range %q: expected a port or a range such as 8000-9000", s)
+	}
+	if r.low < 1 || r.high > 65535 {
+		return fmt.Errorf("invalid port range %q: ports must be between 1 and 65535", s)
+	}
+	if r.low > r.high {
+		return fmt.Errorf("invalid port range %q: %d is greater than %d", s, r.low, r.high)
+	}
+	return nil
+}
+
+// ExcludePorts returns the minimal set of non-overlapping port ranges that
+// cover portRange, except for the excluded ports or port ranges. An empty
+// portRange stands for all ports (1-65535). portRange and the exclusions must
+// be valid ports or port ranges.
+// For instance, excluding 22 and 3389 from 1-65535 yields 1-21, 23-3388 and
+// 3390-65535. Since HNS port filters cannot express exclusions, a policy must
+// be added for each of the returned ranges.
+func ExcludePorts(portRange string, excluded []string) ([]string, error) {
+	if len(portRange) == 0 {
+		portRange = "1-65535"
+	}
+	if err := validatePortRange(portRange); err != nil {
+		return nil, err
+	}
+	intercepted, err := parsePortRange(portRange)
+	if err != nil {
+		return nil, err
+	}
+
+	var exclusions []portInterval
+	for _, s := range excluded {
+		if err := validatePortRange(s); err != nil {
+			return nil, fmt.Errorf("invalid excluded port: %v", err)
+		}
+		exclusion, err := parsePortRange(s)
+		if err != nil {
+			return nil, err
+		}
+		exclusions = append(exclusions, exclusion)
+	}
+	sort.Slice(exclusions, func(i, j int) bool { return exclusions[i].low < exclusions[j].low })
+
+	var ranges []string
+	next := intercepted.low
+	for _, exclusion := range exclusions {
+		if exclusion.low > next && next <= intercepted.high {
+			end := exclusion.low - 1
+			if end > intercepted.high {
+				end = intercepted.high
+			}
+			ranges = append(ranges, portInterval{next, end}.String())
+		}
+		if exclusion.high+1 > next {
+			next = exclusion.high + 1
+		}
+	}
+	if next <= intercepted.high {
+		ranges = append(ranges, portInterval{next, intercepted.high}.String())
+	}
+
+	return ranges, nil
+}
@@ -0,0 +1,117 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package hcnproxyctrl
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExcludePorts(t *testing.T) {
+	tests := []struct {
+		portRange string
+		excluded  []string
+		want      []string
+	}{
+		{"", []string{"22", "3389"}, []string{"1-21", "23-3388", "3390-65535"}},
+		{"", []string{"3389", "22"}, []string{"1-21", "23-3388", "3390-65535"}},
+		{"1000-2000", []string{"1500"}, []string{"1000-1499", "1501-2000"}},
+		{"1000-2000", []string{"1-1000", "2000-3000"}, []string{"1001-1999"}},
+		{"1000-2000", []string{"1200-1300", "1250-1400"}, []string{"1000-1199", "1401-2000"}},
+		{"1000-2000", []string{"3000"}, []string{"1000-2000"}},
+		{"80", []string{"80"}, nil},
+		{"", []string{"1-65535"}, nil},
+	}
+	for _, test := range tests {
+		got, err := ExcludePorts(test.portRange, test.excluded)
+		if err != nil || !reflect.DeepEqual(got, test.want) {
+			t.Errorf("ExcludePorts(%q, %q) = %q, %v, want %q", test.portRange, test.excluded, got, err, test.want)
+		}
+	}
+}
+
+func TestExcludePortsInvalid(t *testing.T) {
+	tests := []struct {
+		portRange string
+		excluded  []string
+	}{
+		{"", []string{"22", "3389-22"}},
+		{"", []string{"0"}},
+		{"", []string{"70000"}},
+		{"", []string{"ssh"}},
+		{"2000-1000", []string{"1500"}},
+		{"0-100", []string{"22"}},
+		{"1-70000", []string{"22"}},
+	}
+	for _, test := range tests {
+		if got, err := ExcludePorts(test.portRange, test.excluded); err == nil {
+			t.Errorf("ExcludePorts(%q, %q) = %q, want an error", test.portRange, test.excluded, got)
+		}
+	}
+}
+
+func TestValidatePortRange(t *testing.T) {
+	tests := []struct {
+		portRange string
+		// The error is expected to contain wantErr, or no error if empty.
+		wantErr string
+	}{
+		{"1", ""},
+		{"65535", ""},
+		{"80-80", ""},
+		{"8000-9000", ""},
+		{"1-65535", ""},
+		{"0", "out of the range"},
+		{"65536", "out of the range"},
+		{"+80", "not a port number"},
+		{"", "not a port number"},
+		{"ssh", "not a port number"},
+		{"8000-", `"8000-"`},
+		{"-8000", `"-8000"`},
+		{"+80-90", `"+80-90"`},
+		{"80-+90", `"80-+90"`},
+		{"9000-8000", "9000 is greater than 8000"},
+		{"0-80", "between 1 and 65535"},
+		{"80-65536", "between 1 and 65535"},
+		{"80-90-100", `"80-90-100"`},
+	}
+	for _, test := range tests {
+		err := validatePortRange(test.portRange)
+		if len(test.wantErr) == 0 {
+			if err != nil {
+				t.Errorf("validatePortRange(%q) = %v, want no error", test.portRange, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+			t.Errorf("validatePortRange(%q) = %v, want an error containing %q", test.portRange, err, test.wantErr)
+		}
+	}
+}
+
+func TestParsePortRange(t *testing.T) {
+	tests := []struct {
+		portRange string
+		want      portInterval
+		wantErr   bool
+	}{
+		{"0", portInterval{0, 0}, false},
+		{"1", portInterval{1, 1}, false},
+		{"65535", portInterval{65535, 65535}, false},
+		{"65536", portInterval{65536, 65536}, false},
+		{"80-80", portInterval{80, 80}, false},
+		{"9000-8000", portInterval{9000, 8000}, false},
+		{"8000-", portInterval{}, true},
+		{"-8000", portInterval{}, true},
+		{"+80", portInterval{}, true},
+		{"80-+90", portInterval{}, true},
+		{" 80", portInterval{}, true},
+		{"", portInterval{}, true},
+	}
+	for _, test := range tests {
+		got, err := parsePortRange(test.portRange)
+		if (err != nil) != test.wantErr || got != test.want {
+			t.Errorf("parsePortRange(%q) = %v, %v, want %v, error %v", test.portRange, got, err, test.want, test.wantErr)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package hcnproxyctrl
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// PriorityBand is an inclusive range of policy priorities.
+type PriorityBand struct {
+	Low, High uint16
+}
+
+// ParsePriorityBand parses a priority band such as "1000-2000".
+func ParsePriorityBand(s string) (PriorityBand, error) {
+	parts := strings.Split(s, "-")
+	if len(parts) != 2 {
+		return PriorityBand{}, fmt.Errorf("invalid priority band %q", s)
+	}
+	low, err := strconv.ParseUint(parts[0], 10, 16)
+	if err != nil {
+		return PriorityBand{}, fmt.Errorf("invalid priority band %q", s)
+	}
+	high, err := strconv.ParseUint(parts[1], 10, 16)
+	if err != nil || high < low {
+		return PriorityBand{}, fmt.Errorf("invalid priority band %q", s)
+	}
+	return PriorityBand{Low: uint16(low), High: uint16(high)}, nil
+}
+
+// DistributePriorities returns a copy of the policies with distinct
+// priorities spread evenly across the band, in the order of the policies.
+// An error is returned if the band is too narrow to hold a distinct priority
+// for each policy.
+func DistributePriorities(policies []Policy, band PriorityBand) ([]Policy, error) {
+	width := int(band.High) - int(band.Low)
+	if len(policies) > width+1 {
+		return nil, fmt.Errorf("priority band %d-%d cannot hold %d distinct priorities", band.Low, band.High, len(policies))
+	}
+
+	step := 0
+	if len(policies) > 1 {
+		step = width / (len(policies) - 1)
+	}
+
+	distributed := make([]Policy, len(policies))
+	for i, policy := range policies {
+		policy.Priority = band.Low + uint16(i*step)
+		distributed[i] = policy
+	}
+	return distributed, nil
+}
@@ -0,0 +1,81 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package hcnproxyctrl
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParsePriorityBand(t *testing.T) {
+	tests := []struct {
+		s     string
+		band  PriorityBand
+		valid bool
+	}{
+		{"1000-2000", PriorityBand{1000, 2000}, true},
+		{"0-65535", PriorityBand{0, 65535}, true},
+		{"100-100", PriorityBand{100, 100}, true},
+		{"2000-1000", PriorityBand{}, false},
+		{"1000", PriorityBand{}, false},
+		{"0-65536", PriorityBand{}, false},
+		{"a-b", PriorityBand{}, false},
+	}
+	for _, test := range tests {
+		band, err := ParsePriorityBand(test.s)
+		if (err == nil) != test.valid || band != test.band {
+			t.Errorf("ParsePriorityBand(%q) = %+v, %v, want %+v (valid = %v)", test.s, band, err, test.band, test.valid)
+		}
+	}
+}
+
+func TestDistributePriorities(t *testing.T) {
+	policies := func(n int) []Policy {
+		policies := make([]Policy, n)
+		for i := range policies {
+			policies[i] = Policy{ProxyPort: "15001", Priority: 7}
+		}
+		return policies
+	}
+	priorities := func(policies []Policy) []uint16 {
+		var priorities []uint16
+		for _, policy := range policies {
+			priorities = append(priorities, policy.Priority)
+		}
+		return priorities
+	}
+
+	tests := []struct {
+		n    int
+		band PriorityBand
+		want []uint16
+	}{
+		{1, PriorityBand{1000, 2000}, []uint16{1000}},
+		{2, PriorityBand{1000, 2000}, []uint16{1000, 2000}},
+		{5, PriorityBand{1000, 2000}, []uint16{1000, 1250, 1500, 1750, 2000}},
+		{3, PriorityBand{1000, 1003}, []uint16{1000, 1001, 1002}},
+		{4, PriorityBand{1000, 1003}, []uint16{1000, 1001, 1002, 1003}},
+		{1, PriorityBand{100, 100}, []uint16{100}},
+	}
+	for _, test := range tests {
+		original := policies(test.n)
+		distributed, err := DistributePriorities(original, test.band)
+		if err != nil || !reflect.DeepEqual(priorities(distributed), test.want) {
+			t.Errorf("DistributePriorities(%d policies, %+v) = %v, %v, want %v", test.n, test.band, priorities(distributed), err, test.want)
+		}
+		if original[0].Priority != 7 {
+			t.Errorf("DistributePriorities modified the policies passed in")
+		}
+	}
+}
+
+func TestDistributePrioritiesOverflow(t *testing.T) {
+	policies := make([]Policy, 5)
+	if _, err := DistributePriorities(policies, PriorityBand{1000, 1003}); err == nil {
+		t.Error("DistributePriorities spread 5 policies across a band of 4 priorities")
+	}
+	if _, err := DistributePriorities(policies[:2], PriorityBand{100, 100}); err == nil {
+		t.Error("DistributePriorities spread 2 policies across a single priority")
+	}
+}
@@ -0,0 +1,56 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package hcnproxyctrl
+
+import (
+	"context"
+	"fmt"
+
+	cri "github.com/microsoft/hcnproxyctrl/cri"
+)
+
+// GetEndpointsFromPID returns the IDs of the HNS endpoints of the container
+// running the process with the given ID, ie. the container whose init process,
+// as reported by CRI, is the process or one of its ancestors.
+// This is best effort: not all runtimes report the init process of their
+// containers, the processes of Hyper-V isolated containers are not visible
+// from the host, and the parent of a process is only known while it runs.
+func GetEndpointsFromPID(pid int, runtimeEndpoint string) ([]string, error) {
+	ancestors, err := processAncestors(pid)
+	if err != nil {
+		return nil, err
+	}
+
+	containers, err := listContainersRetrying(context.Background(), criParameters(runtimeEndpoint))
+	if err != nil {
+		return nil, err
+	}
+	container, ok := containerOfProcess(containers, ancestors)
+	if !ok {
+		return nil, fmt.Errorf("%w of the process", ErrContainerNotFound)
+	}
+
+	endpointIDs, err := getNamespaceEndpointIDs(container.NamespaceId)
+	if err != nil {
+		return nil, err
+	}
+	if len(endpointIDs) == 0 {
+		return nil, fmt.Errorf("%w of the process", ErrEndpointNotAttached)
+	}
+	return endpointIDs, nil
+}
+
+// containerOfProcess returns the container whose init process is the closest
+// to the process, given the IDs of the process and of its ancestors, from the
+// process up.
+func containerOfProcess(containers []cri.ContainerInfo, ancestors []int) (cri.ContainerInfo, bool) {
+	for _, pid := range ancestors {
+		for _, container := range containers {
+			if container.Pid != 0 && container.Pid == pid {
+				return container, true
+			}
+		}
+	}
+	return cri.ContainerInfo{}, false
+}
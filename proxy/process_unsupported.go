@@ -0,0 +1,13 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+//go:build !windows
+// +build !windows
+
+package hcnproxyctrl
+
+import "errors"
+
+func processAncestors(pid int) ([]int, error) {
+	return nil, errors.New("process lookup is only supported on Windows")
+}
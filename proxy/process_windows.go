@@ -0,0 +1,49 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package hcnproxyctrl
+
+import (
+	"fmt"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// processAncestors returns the ID of the process followed by the IDs of its
+// ancestors, from its parent up, as far as they are still running.
+func processAncestors(pid int) ([]int, error) {
+	snapshot, err := windows.CreateToolhelp32Snapshot(windows.TH32CS_SNAPPROCESS, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer windows.CloseHandle(snapshot)
+
+	parents := make(map[int]int)
+	var entry windows.ProcessEntry32
+	entry.Size = uint32(unsafe.Sizeof(entry))
+	for err = windows.Process32First(snapshot, &entry); err == nil; err = windows.Process32Next(snapshot, &entry) {
+		parents[int(entry.ProcessID)] = int(entry.ParentProcessID)
+	}
+	if err != windows.ERROR_NO_MORE_FILES {
+		return nil, err
+	}
+
+	if _, ok := parents[pid]; !ok {
+		return nil, fmt.Errorf("could not find a process with ID %d", pid)
+	}
+
+	// Parent IDs can refer to processes that exited and whose IDs were
+	// reused, which may create cycles.
+	ancestors := []int{pid}
+	seen := map[int]bool{pid: true}
+	for {
+		parent, ok := parents[pid]
+		if !ok || parent == 0 || seen[parent] {
+			return ancestors, nil
+		}
+		ancestors = append(ancestors, parent)
+		seen[parent] = true
+		pid = parent
+	}
+}
@@ -0,0 +1,81 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package hcnproxyctrl
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ProtocolInfo describes a protocol whose traffic proxy policies can
+// intercept.
+type ProtocolInfo struct {
+	// Lowercase name, eg. "tcp"
+	Name string
+	// IANA protocol number, as used in the Protocol field of a Policy
+	Number string
+}
+
+// supportedProtocols are the protocols HNS accepts in proxy policies.
+var supportedProtocols = []ProtocolInfo{
+	{Name: "tcp", Number: "6"},
+	{Name: "udp", Number: "17"},
+}
+
+// SupportedProtocols returns the protocols whose traffic proxy policies can
+// intercept.
+func SupportedProtocols() []ProtocolInfo {
+	return append([]ProtocolInfo(nil), supportedProtocols...)
+}
+
+// ParseProtocol returns the IANA number of a protocol given either by its
+// name, case-insensitively (eg. "tcp" or "UDP"), or by its number, which is
+// returned unchanged if it is a valid protocol number (0-255). Whether HNS
+// supports the protocol is checked when the policy is validated.
+func ParseProtocol(s string) (string, error) {
+	for _, protocol := range supportedProtocols {
+		if strings.EqualFold(s, protocol.Name) {
+			return protocol.Number, nil
+		}
+	}
+	if n, err := strconv.Atoi(s); err == nil {
+		if n < 0 || n > 255 {
+			return "", fmt.Errorf("invalid protocol number %s: must be between 0 and 255", s)
+		}
+		return s, nil
+	}
+	return "", fmt.Errorf("unknown protocol %q: expected one of %s", s, supportedProtocolList())
+}
+
+// isSupportedProtocol returns true iff HNS accepts proxy policies for the
+// protocol with the given IANA number.
+func isSupportedProtocol(number string) bool {
+	for _, protocol := range supportedProtocols {
+		if protocol.Number == number {
+			return true
+		}
+	}
+	return false
+}
+
+// supportedProtocolList returns the supported protocols for error messages,
+// eg. "tcp (6), udp (17)".
+func supportedProtocolList() string {
+	var protocols []string
+	for _, protocol := range supportedProtocols {
+		protocols = append(protocols, fmt.Sprintf("%s (%s)", protocol.Name, protocol.Number))
+	}
+	return strings.Join(protocols, ", ")
+}
+
+// WellKnownSIDs returns the shorthands accepted in place of a SID in the
+// UserSID field of a Policy, mapped to the SID they stand for.
+func WellKnownSIDs() map[string]string {
+	sids := make(map[string]string, len(sidShorthands))
+	for shorthand, sid := range sidShorthands {
+		sids[shorthand] = sid
+	}
+	return sids
+}
@@ -0,0 +1,78 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package hcnproxyctrl
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Receipt records that a policy was applied to an endpoint. It can later be
+// checked against the endpoint with VerifyReceipt.
+type Receipt struct {
+	EndpointID  string    `json:"endpointId"`
+	PolicyKey   string    `json:"policyKey"`
+	ToolVersion string    `json:"toolVersion"`
+	Timestamp   time.Time `json:"timestamp"`
+
+	// Digest is a SHA-256 hash of the other fields, or an HMAC-SHA256 of
+	// them if the receipt was issued with a key.
+	Digest string `json:"digest"`
+}
+
+// NewReceipt returns a receipt for the policy applied to the endpoint. If key
+// is not empty, the receipt is signed with it, and can only be verified with
+// the same key; otherwise the digest only detects accidental modifications.
+func NewReceipt(hnsEndpointID string, policy Policy, toolVersion string, key []byte) Receipt {
+	// Key the policy as it is applied, eg. with its user SID shorthand resolved.
+	if effective, err := EffectivePolicy(policy); err == nil {
+		policy = effective
+	}
+
+	receipt := Receipt{
+		EndpointID:  hnsEndpointID,
+		PolicyKey:   policy.Key(),
+		ToolVersion: toolVersion,
+		Timestamp:   time.Now().UTC(),
+	}
+	receipt.Digest = receipt.digest(key)
+	return receipt
+}
+
+// VerifyReceipt returns nil iff the receipt has not been tampered with and
+// the policy it records is currently applied to the endpoint.
+func VerifyReceipt(receipt Receipt, key []byte) error {
+	if !hmac.Equal([]byte(receipt.Digest), []byte(receipt.digest(key))) {
+		return errors.New("receipt digest does not match its content")
+	}
+
+	policies, err := ListPolicies(receipt.EndpointID)
+	if err != nil {
+		return err
+	}
+	for _, policy := range policies {
+		if policy.Key() == receipt.PolicyKey {
+			return nil
+		}
+	}
+	return fmt.Errorf("policy %s is not applied to endpoint %s", receipt.PolicyKey, receipt.EndpointID)
+}
+
+// digest computes the digest of the receipt fields, keyed if key is not empty.
+func (receipt Receipt) digest(key []byte) string {
+	content := fmt.Sprintf("%s\n%s\n%s\n%s", receipt.EndpointID, receipt.PolicyKey,
+		receipt.ToolVersion, receipt.Timestamp.UTC().Format(time.RFC3339Nano))
+
+	if len(key) == 0 {
+		sum := sha256.Sum256([]byte(content))
+		return hex.EncodeToString(sum[:])
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(content))
+	return hex.EncodeToString(mac.Sum(nil))
+}
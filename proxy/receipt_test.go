@@ -0,0 +1,81 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package hcnproxyctrl
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestVerifyReceipt(t *testing.T) {
+	applied := Policy{ProxyPort: "15001", UserSID: "system", RemotePorts: "80"}
+	newFakeHNS(t, proxyEndpoint(t, "ep", applied), proxyEndpoint(t, "other"))
+	key := []byte("secret")
+
+	tamper := func(change func(*Receipt)) Receipt {
+		receipt := NewReceipt("ep", applied, "1.0", key)
+		change(&receipt)
+		return receipt
+	}
+
+	tests := []struct {
+		name    string
+		receipt Receipt
+		key     []byte
+		err     string
+	}{
+		{
+			name:    "signed receipt",
+			receipt: NewReceipt("ep", applied, "1.0", key),
+			key:     key,
+		},
+		{
+			name:    "unsigned receipt",
+			receipt: NewReceipt("ep", applied, "1.0", nil),
+		},
+		{
+			name:    "wrong key",
+			receipt: NewReceipt("ep", applied, "1.0", key),
+			key:     []byte("other secret"),
+			err:     "receipt digest does not match its content",
+		},
+		{
+			name:    "signed receipt verified without the key",
+			receipt: NewReceipt("ep", applied, "1.0", key),
+			err:     "receipt digest does not match its content",
+		},
+		{
+			name:    "tampered endpoint",
+			receipt: tamper(func(receipt *Receipt) { receipt.EndpointID = "other" }),
+			key:     key,
+			err:     "receipt digest does not match its content",
+		},
+		{
+			name: "tampered policy",
+			receipt: tamper(func(receipt *Receipt) {
+				receipt.PolicyKey = Policy{ProxyPort: "15002"}.Key()
+			}),
+			key: key,
+			err: "receipt digest does not match its content",
+		},
+		{
+			name:    "policy not applied",
+			receipt: NewReceipt("other", applied, "1.0", key),
+			key:     key,
+			err:     "is not applied to endpoint other",
+		},
+	}
+	for _, test := range tests {
+		err := VerifyReceipt(test.receipt, test.key)
+		if len(test.err) == 0 {
+			if err != nil {
+				t.Errorf("%s: VerifyReceipt = %v", test.name, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: VerifyReceipt = %v, want error containing %q", test.name, err, test.err)
+		}
+	}
+}
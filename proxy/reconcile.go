@@ -0,0 +1,259 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package hcnproxyctrl
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/Microsoft/hcsshim/hcn"
+)
+
+// ReconcileOptions tunes the behavior of ReconcilePolicies.
+type ReconcileOptions struct {
+	// Transactional makes ReconcilePolicies restore the proxy policies the
+	// endpoint had before the call if any of its steps fails. The rollback is
+	// best effort: it is made of further HNS calls, which can fail too, and
+	// other actors may have modified the endpoint in the meantime.
+	Transactional bool
+}
+
+// ReconcileResult counts the proxy policies added and removed by
+// ReconcilePolicies.
+type ReconcileResult struct {
+	Added   int
+	Removed int
+
+	// Err is the error that interrupted the reconciliation of the endpoint,
+	// as reported by ReconcileAll.
+	Err error
+}
+
+// ReconcilePlan lists the changes needed for the proxy policies of an
+// endpoint to match the desired ones.
+type ReconcilePlan struct {
+	ToAdd    []Policy
+	ToRemove []Policy
+}
+
+// InSync returns true iff no change is needed.
+func (plan ReconcilePlan) InSync() bool {
+	return len(plan.ToAdd) == 0 && len(plan.ToRemove) == 0
+}
+
+// PlanReconcile returns the changes that ReconcilePolicies would make to the
+// endpoint, without making them.
+func PlanReconcile(hnsEndpointID string, desired []Policy) (ReconcilePlan, error) {
+	desiredPolicies, desiredKeys, err := renderDesiredPolicies(desired)
+	if err != nil {
+		return ReconcilePlan{}, err
+	}
+
+	endpoint, err := getEndpointByID(hnsEndpointID)
+	if err != nil {
+		return ReconcilePlan{}, err
+	}
+
+	var plan ReconcilePlan
+	current := make(map[string]bool)
+	for _, hcnPolicy := range endpoint.Policies {
+		if hcnPolicy.Type != hcn.L4WFPPROXY {
+			continue
+		}
+		policy := hcnPolicyToAPIPolicy(hcnPolicy)
+		key := policy.Key()
+		current[key] = true
+		if _, ok := desiredPolicies[key]; !ok {
+			plan.ToRemove = append(plan.ToRemove, policy)
+		}
+	}
+	for _, key := range desiredKeys {
+		if !current[key] {
+			plan.ToAdd = append(plan.ToAdd, hcnPolicyToAPIPolicy(desiredPolicies[key]))
+		}
+	}
+
+	return plan, nil
+}
+
+// ReconcilePolicies makes the proxy policies of the endpoint match the desired
+// ones: policies that are not desired are removed, and desired policies that
+// are missing are added. Policies are compared by Key. All the desired
+// policies are validated before the endpoint is modified. The changes are made
+// between the hooks, which are not called if there is no change to make.
+func ReconcilePolicies(hnsEndpointID string, desired []Policy, options ReconcileOptions) (ReconcileResult, error) {
+	defer lockEndpoint(hnsEndpointID)()
+
+	desiredPolicies, desiredKeys, err := renderDesiredPolicies(desired)
+	if err != nil {
+		return ReconcileResult{}, err
+	}
+
+	endpoint, err := getEndpointByID(hnsEndpointID)
+	if err != nil {
+		return ReconcileResult{}, err
+	}
+
+	var toRemove, toAdd []hcn.EndpointPolicy
+	current := make(map[string]bool)
+	for _, hcnPolicy := range endpoint.Policies {
+		if hcnPolicy.Type != hcn.L4WFPPROXY {
+			continue
+		}
+		key := hcnPolicyToAPIPolicy(hcnPolicy).Key()
+		current[key] = true
+		if _, ok := desiredPolicies[key]; !ok {
+			toRemove = append(toRemove, hcnPolicy)
+		}
+	}
+	for _, key := range desiredKeys {
+		if !current[key] {
+			toAdd = append(toAdd, desiredPolicies[key])
+		}
+	}
+	if len(toRemove) == 0 && len(toAdd) == 0 {
+		return ReconcileResult{}, nil
+	}
+
+	var result ReconcileResult
+	event := HookEvent{Operation: "reconcile", EndpointID: hnsEndpointID, Policies: desired}
+	err = withHooks(event, func() error {
+		tx := transaction{endpoint: endpoint}
+		for _, hcnPolicy := range toRemove {
+			if err := tx.remove(hcnPolicy); err != nil {
+				result, err = tx.abort(err, options)
+				return err
+			}
+		}
+		for _, hcnPolicy := range toAdd {
+			if err := tx.add(hcnPolicy); err != nil {
+				result, err = tx.abort(err, options)
+				return err
+			}
+		}
+		result = tx.result()
+		return nil
+	})
+	return result, err
+}
+
+// ReconcileAll reconciles the proxy policies of many endpoints, keyed by
+// endpoint ID, like ReconcilePolicies does for one with the default options.
+// At most parallelism endpoints are reconciled at once (one if parallelism is
+// less than one); the changes to each endpoint are still made one at a time.
+// The result of each endpoint, including its error if any, is returned keyed
+// by endpoint ID.
+func ReconcileAll(desired map[string][]Policy, parallelism int) map[string]ReconcileResult {
+	if parallelism < 1 {
+		parallelism = 1
+	}
+
+	endpointIDs := make(chan string)
+	go func() {
+		for hnsEndpointID := range desired {
+			endpointIDs <- hnsEndpointID
+		}
+		close(endpointIDs)
+	}()
+
+	var (
+		wg      sync.WaitGroup
+		mutex   sync.Mutex
+		results = make(map[string]ReconcileResult, len(desired))
+	)
+	for i := 0; i < parallelism; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for hnsEndpointID := range endpointIDs {
+				result, err := ReconcilePolicies(hnsEndpointID, desired[hnsEndpointID], ReconcileOptions{})
+				result.Err = err
+
+				mutex.Lock()
+				results[hnsEndpointID] = result
+				mutex.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	return results
+}
+
+// renderDesiredPolicies renders the desired policies, deduplicated by key.
+// It returns them keyed by Key, along with their keys in order.
+func renderDesiredPolicies(desired []Policy) (map[string]hcn.EndpointPolicy, []string, error) {
+	desiredPolicies := make(map[string]hcn.EndpointPolicy)
+	var desiredKeys []string
+	for _, policy := range desired {
+		endpointPolicy, err := RenderPolicy(policy)
+		if err != nil {
+			return nil, nil, err
+		}
+		key := hcnPolicyToAPIPolicy(endpointPolicy).Key()
+		if _, ok := desiredPolicies[key]; !ok {
+			desiredPolicies[key] = endpointPolicy
+			desiredKeys = append(desiredKeys, key)
+		}
+	}
+	return desiredPolicies, desiredKeys, nil
+}
+
+// transaction applies changes to the proxy policies of an endpoint one at a
+// time, recording them so that they can be rolled back.
+type transaction struct {
+	endpoint *hcn.HostComputeEndpoint
+	added    []hcn.EndpointPolicy
+	removed  []hcn.EndpointPolicy
+}
+
+func (tx *transaction) add(policy hcn.EndpointPolicy) error {
+	if err := applyRequest(tx.endpoint.Id, hcn.RequestTypeAdd, []hcn.EndpointPolicy{policy}); err != nil {
+		return err
+	}
+	tx.added = append(tx.added, policy)
+	return nil
+}
+
+func (tx *transaction) remove(policy hcn.EndpointPolicy) error {
+	if err := removePolicies(tx.endpoint.Id, []hcn.EndpointPolicy{policy}); err != nil {
+		return err
+	}
+	tx.removed = append(tx.removed, policy)
+	return nil
+}
+
+func (tx *transaction) result() ReconcileResult {
+	return ReconcileResult{Added: len(tx.added), Removed: len(tx.removed)}
+}
+
+// abort returns the error that interrupted the transaction, after rolling it
+// back if the options require it.
+func (tx *transaction) abort(err error, options ReconcileOptions) (ReconcileResult, error) {
+	if !options.Transactional {
+		return tx.result(), err
+	}
+	if rollbackErr := tx.rollback(); rollbackErr != nil {
+		return tx.result(), fmt.Errorf("%v (rolling back also failed: %v)", err, rollbackErr)
+	}
+	return ReconcileResult{}, err
+}
+
+// rollback removes the policies that were added and adds back the ones that
+// were removed.
+func (tx *transaction) rollback() error {
+	if len(tx.added) > 0 {
+		if err := removePolicies(tx.endpoint.Id, tx.added); err != nil {
+			return err
+		}
+		tx.added = nil
+	}
+	if len(tx.removed) > 0 {
+		if err := applyRequest(tx.endpoint.Id, hcn.RequestTypeAdd, tx.removed); err != nil {
+			return err
+		}
+		tx.removed = nil
+	}
+	return nil
+}
@@ -0,0 +1,186 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package hcnproxyctrl
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Microsoft/hcsshim/hcn"
+)
+
+// recordRequests sets a PostRequest hook recording the types of the requests
+// made to HNS for the duration of the test.
+func recordRequests(t *testing.T) *[]hcn.RequestType {
+	var requestTypes []hcn.RequestType
+	SetHooks(Hooks{
+		PostRequest: func(result RequestResult) {
+			requestTypes = append(requestTypes, result.RequestType)
+		},
+	})
+	t.Cleanup(func() { SetHooks(Hooks{}) })
+	return &requestTypes
+}
+
+func TestReconcilePolicies(t *testing.T) {
+	newFakeHNS(t, proxyEndpoint(t, "ep", Policy{ProxyPort: "15001", RemotePorts: "80"}, Policy{ProxyPort: "15002"}))
+	requestTypes := recordRequests(t)
+
+	desired := []Policy{
+		{ProxyPort: "15001", RemotePorts: "80", Protocol: "tcp"},
+		{ProxyPort: "15003"},
+		{ProxyPort: "15003", Protocol: "6"},
+	}
+	plan, err := PlanReconcile("ep", desired)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantPlan := ReconcilePlan{
+		ToAdd:    []Policy{{ProxyPort: "15003", Protocol: "6"}},
+		ToRemove: []Policy{{ProxyPort: "15002", Protocol: "6"}},
+	}
+	if !reflect.DeepEqual(plan, wantPlan) {
+		t.Errorf("PlanReconcile = %+v, want %+v", plan, wantPlan)
+	}
+
+	result, err := ReconcilePolicies("ep", desired, ReconcileOptions{})
+	if err != nil || result.Added != 1 || result.Removed != 1 {
+		t.Fatalf("ReconcilePolicies = %+v, %v, want 1 policy added and 1 removed", result, err)
+	}
+	want := []Policy{
+		{ProxyPort: "15001", RemotePorts: "80", Protocol: "6"},
+		{ProxyPort: "15003", Protocol: "6"},
+	}
+	if got := sortedPolicies(mustListPolicies(t, "ep")); !reflect.DeepEqual(got, want) {
+		t.Errorf("policies after reconciliation %+v, want %+v", got, want)
+	}
+	// The requests of the reconciliation are reported like any other.
+	wantTypes := []hcn.RequestType{hcn.RequestTypeRemove, hcn.RequestTypeAdd}
+	if !reflect.DeepEqual(*requestTypes, wantTypes) {
+		t.Errorf("reported requests %v, want %v", *requestTypes, wantTypes)
+	}
+
+	plan, err = PlanReconcile("ep", desired)
+	if err != nil || !plan.InSync() {
+		t.Errorf("PlanReconcile after reconciliation = %+v, %v, want in sync", plan, err)
+	}
+}
+
+func TestReconcilePoliciesRollback(t *testing.T) {
+	original := []Policy{{ProxyPort: "15001", Protocol: "6"}}
+	desired := []Policy{{ProxyPort: "15002"}, {ProxyPort: "15003"}}
+
+	tests := []struct {
+		name          string
+		transactional bool
+		result        ReconcileResult
+		requestTypes  []hcn.RequestType
+		policies      []Policy
+	}{
+		{
+			name:          "transactional",
+			transactional: true,
+			requestTypes: []hcn.RequestType{
+				hcn.RequestTypeRemove, hcn.RequestTypeAdd, hcn.RequestTypeAdd,
+				// Rollback
+				hcn.RequestTypeRemove, hcn.RequestTypeAdd,
+			},
+			policies: original,
+		},
+		{
+			name:         "not transactional",
+			result:       ReconcileResult{Added: 1, Removed: 1},
+			requestTypes: []hcn.RequestType{hcn.RequestTypeRemove, hcn.RequestTypeAdd, hcn.RequestTypeAdd},
+			policies:     []Policy{{ProxyPort: "15002", Protocol: "6"}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake := newFakeHNS(t, proxyEndpoint(t, "ep", original...))
+			var adds int
+			fake.fail = func(request fakeRequest) error {
+				if request.RequestType == hcn.RequestTypeAdd {
+					if adds++; adds == 2 {
+						return errors.New("add failed")
+					}
+				}
+				return nil
+			}
+			requestTypes := recordRequests(t)
+
+			result, err := ReconcilePolicies("ep", desired, ReconcileOptions{Transactional: test.transactional})
+			if err == nil || err.Error() != "add failed" || result != test.result {
+				t.Fatalf("ReconcilePolicies = %+v, %v, want %+v and add failed", result, err, test.result)
+			}
+			if !reflect.DeepEqual(*requestTypes, test.requestTypes) {
+				t.Errorf("reported requests %v, want %v", *requestTypes, test.requestTypes)
+			}
+			if got := mustListPolicies(t, "ep"); !reflect.DeepEqual(got, test.policies) {
+				t.Errorf("policies %+v, want %+v", got, test.policies)
+			}
+		})
+	}
+}
+
+func TestReconcileAll(t *testing.T) {
+	const numEndpoints = 40
+	var endpoints []hcn.HostComputeEndpoint
+	desired := make(map[string][]Policy)
+	for i := 0; i < numEndpoints; i++ {
+		endpointID := fmt.Sprintf("ep%d", i)
+		endpoints = append(endpoints, proxyEndpoint(t, endpointID, Policy{ProxyPort: "15001"}))
+		desired[endpointID] = []Policy{{ProxyPort: "15002"}, {ProxyPort: "15003", RemotePorts: "80"}}
+	}
+	fake := newFakeHNS(t, endpoints...)
+	// Every third endpoint fails to add policies, and one does not exist.
+	fake.fail = func(request fakeRequest) error {
+		var n int
+		fmt.Sscanf(request.EndpointID, "ep%d", &n)
+		if request.RequestType == hcn.RequestTypeAdd && n%3 == 0 {
+			return errors.New("HNS failure on " + request.EndpointID)
+		}
+		return nil
+	}
+	desired["missing"] = []Policy{{ProxyPort: "15002"}}
+
+	for _, parallelism := range []int{0, 1, 8, 2 * numEndpoints} {
+		// Start from the same policies for each run.
+		for i := 0; i < numEndpoints; i++ {
+			endpointID := fmt.Sprintf("ep%d", i)
+			fake.endpoints[endpointID].Policies = []hcn.EndpointPolicy{mustRenderPolicy(t, Policy{ProxyPort: "15001"})}
+		}
+
+		results := ReconcileAll(desired, parallelism)
+		if len(results) != len(desired) {
+			t.Errorf("parallelism %d: %d results, want one per endpoint (%d)", parallelism, len(results), len(desired))
+		}
+		for endpointID := range desired {
+			result, ok := results[endpointID]
+			var n int
+			fmt.Sscanf(endpointID, "ep%d", &n)
+			switch {
+			case !ok:
+				t.Errorf("parallelism %d: no result for %s", parallelism, endpointID)
+			case endpointID == "missing":
+				if !hcn.IsNotFoundError(result.Err) {
+					t.Errorf("parallelism %d: %s error %v, want not found", parallelism, endpointID, result.Err)
+				}
+			case n%3 == 0:
+				if result.Err == nil || !strings.Contains(result.Err.Error(), "HNS failure on "+endpointID) {
+					t.Errorf("parallelism %d: %s error %v, want its own HNS failure", parallelism, endpointID, result.Err)
+				}
+			default:
+				if result.Err != nil || result.Added != 2 || result.Removed != 1 {
+					t.Errorf("parallelism %d: %s result %+v, want 2 added and 1 removed", parallelism, endpointID, result)
+				}
+				if policies := sortedPolicies(mustListPolicies(t, endpointID)); len(policies) != 2 {
+					t.Errorf("parallelism %d: %s has policies %+v, want the desired ones", parallelism, endpointID, policies)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,69 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package hcnproxyctrl
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/Microsoft/hcsshim/hcn"
+)
+
+func TestResolveEndpointID(t *testing.T) {
+	const (
+		id1 = "93f86a7f-e361-4362-b8a4-81bbb6a622dd"
+		id2 = "4c2b3b1a-7d51-4c8e-9a57-0f4e8d6c2a10"
+		id3 = "b1d1a7c2-3e7e-4f0a-8a5c-5f3e2d1c0b9a"
+	)
+	newFakeHNS(t,
+		hcn.HostComputeEndpoint{Id: id1, Name: "web"},
+		// An endpoint named after the ID of another one
+		hcn.HostComputeEndpoint{Id: id2, Name: id1},
+		hcn.HostComputeEndpoint{Id: id3, Name: "db"},
+		hcn.HostComputeEndpoint{Id: "c3e4f5a6-0000-4000-8000-000000000001", Name: "db"},
+	)
+
+	tests := []struct {
+		name             string
+		endpointIDOrName string
+		id               string
+		err              string
+	}{
+		{name: "ID", endpointIDOrName: id3, id: id3},
+		{name: "ID in uppercase", endpointIDOrName: strings.ToUpper(id3), id: id3},
+		{name: "name", endpointIDOrName: "web", id: id1},
+		{name: "ID taking precedence over a name", endpointIDOrName: id1, id: id1},
+		{name: "name of another endpoint's ID in uppercase", endpointIDOrName: strings.ToUpper(id1), id: id1},
+		{name: "names are case-sensitive", endpointIDOrName: "WEB", err: `could not find an endpoint with ID or name "WEB"`},
+		{name: "ambiguous name", endpointIDOrName: "db", err: `several endpoints are named "db"`},
+		{name: "unknown", endpointIDOrName: "cache", err: `could not find an endpoint with ID or name "cache"`},
+	}
+	for _, test := range tests {
+		// The fake lists the endpoints in random order, so resolve a few
+		// times to make sure the precedence does not depend on the order.
+		for i := 0; i < 10; i++ {
+			id, err := ResolveEndpointID(test.endpointIDOrName)
+			if len(test.err) > 0 {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("%s: ResolveEndpointID(%q) = %q, %v, want error containing %q", test.name, test.endpointIDOrName, id, err, test.err)
+				}
+				continue
+			}
+			if err != nil || id != test.id {
+				t.Fatalf("%s: ResolveEndpointID(%q) = %q, %v, want %q", test.name, test.endpointIDOrName, id, err, test.id)
+			}
+		}
+	}
+}
+
+func TestResolveEndpointIDNotFoundError(t *testing.T) {
+	newFakeHNS(t, hcn.HostComputeEndpoint{Id: "ep", Name: "web"})
+
+	_, err := ResolveEndpointID("db")
+	var notFound *EndpointNotFoundError
+	if !errors.As(err, &notFound) || notFound.EndpointIDOrName != "db" {
+		t.Errorf("ResolveEndpointID error %v, want an *EndpointNotFoundError for db", err)
+	}
+}
@@ -0,0 +1,77 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package hcnproxyctrl
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	cri "github.com/microsoft/hcnproxyctrl/cri"
+)
+
+// RetryPolicy tells how many times, and how often, to try connecting to CRI
+// when the runtime is not accepting connections, eg. during node startup.
+type RetryPolicy struct {
+	// Attempts is the total number of attempts; values below 2 disable
+	// retrying.
+	Attempts int
+	// Interval is the wait before the first retry, doubled before each of
+	// the next ones.
+	Interval time.Duration
+}
+
+var (
+	criRetryMutex sync.RWMutex
+	criRetry      RetryPolicy
+)
+
+// criListContainers lists the containers through CRI. It is a variable so that
+// CRI can be replaced where it is not available, eg. by a fake in tests.
+var criListContainers = cri.ListContainers
+
+// SetCRIRetry sets how container lookups retry connecting to CRI. By default,
+// they do not retry.
+func SetCRIRetry(retry RetryPolicy) {
+	criRetryMutex.Lock()
+	defer criRetryMutex.Unlock()
+	criRetry = retry
+}
+
+// listContainersRetrying lists the containers, retrying as set by SetCRIRetry
+// as long as the connection to CRI fails. The last error is returned once the
+// attempts are exhausted, or the error of the context if it is done first.
+func listContainersRetrying(ctx context.Context, params cri.CriParameters) ([]cri.ContainerInfo, error) {
+	var containers []cri.ContainerInfo
+	err := retryConnect(ctx, func() (err error) {
+		containers, err = criListContainers(params)
+		return err
+	})
+	return containers, err
+}
+
+// retryConnect calls fn until it succeeds, fails with another error than a
+// *cri.ConnectError, or the attempts set by SetCRIRetry are exhausted.
+func retryConnect(ctx context.Context, fn func() error) error {
+	criRetryMutex.RLock()
+	retry := criRetry
+	criRetryMutex.RUnlock()
+
+	interval := retry.Interval
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if _, ok := err.(*cri.ConnectError); !ok || attempt >= retry.Attempts {
+			return err
+		}
+
+		timer := time.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+		interval *= 2
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package hcnproxyctrl
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	cri "github.com/microsoft/hcnproxyctrl/cri"
+)
+
+// setCRIRetry sets how CRI connections are retried for the duration of the
+// test.
+func setCRIRetry(t *testing.T, retry RetryPolicy) {
+	SetCRIRetry(retry)
+	t.Cleanup(func() { SetCRIRetry(RetryPolicy{}) })
+}
+
+// failingCRI replaces CRI by a fake failing to list containers with the
+// errors, in order, then succeeding, for the duration of the test. It returns
+// the number of attempts made.
+func failingCRI(t *testing.T, errs ...error) *int {
+	var attempts int
+	previous := criListContainers
+	criListContainers = func(cri.CriParameters) ([]cri.ContainerInfo, error) {
+		attempts++
+		if attempts <= len(errs) {
+			return nil, errs[attempts-1]
+		}
+		return []cri.ContainerInfo{{ContainerId: "c1", NamespaceId: "ns1"}}, nil
+	}
+	t.Cleanup(func() { criListContainers = previous })
+	return &attempts
+}
+
+func TestListContainersRetrying(t *testing.T) {
+	connectErr := &cri.ConnectError{Err: errors.New("connection refused")}
+	callErr := errors.New("rpc error: code = InvalidArgument")
+
+	tests := []struct {
+		name     string
+		retry    RetryPolicy
+		errs     []error
+		attempts int
+		err      error
+	}{
+		{
+			name:     "no retry by default",
+			errs:     []error{connectErr},
+			attempts: 1,
+			err:      connectErr,
+		},
+		{
+			name:     "retried connection failures",
+			retry:    RetryPolicy{Attempts: 3, Interval: time.Millisecond},
+			errs:     []error{connectErr, connectErr},
+			attempts: 3,
+		},
+		{
+			name:     "exhausted attempts",
+			retry:    RetryPolicy{Attempts: 3, Interval: time.Millisecond},
+			errs:     []error{connectErr, connectErr, connectErr, connectErr},
+			attempts: 3,
+			err:      connectErr,
+		},
+		{
+			name:     "failed calls are not retried",
+			retry:    RetryPolicy{Attempts: 3, Interval: time.Millisecond},
+			errs:     []error{callErr},
+			attempts: 1,
+			err:      callErr,
+		},
+		{
+			name:     "failed call after a retry",
+			retry:    RetryPolicy{Attempts: 3, Interval: time.Millisecond},
+			errs:     []error{connectErr, callErr},
+			attempts: 2,
+			err:      callErr,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setCRIRetry(t, test.retry)
+			attempts := failingCRI(t, test.errs...)
+
+			containers, err := listContainersRetrying(context.Background(), cri.CriParameters{})
+			if err != test.err {
+				t.Errorf("listContainersRetrying error %v, want %v", err, test.err)
+			}
+			if err == nil && len(containers) != 1 {
+				t.Errorf("listContainersRetrying = %+v, want the containers", containers)
+			}
+			if *attempts != test.attempts {
+				t.Errorf("%d attempts, want %d", *attempts, test.attempts)
+			}
+		})
+	}
+}
+
+func TestListContainersRetryingContext(t *testing.T) {
+	setCRIRetry(t, RetryPolicy{Attempts: 10, Interval: time.Hour})
+	attempts := failingCRI(t, &cri.ConnectError{Err: errors.New("connection refused")})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := listContainersRetrying(ctx, cri.CriParameters{})
+	if err != context.DeadlineExceeded {
+		t.Errorf("listContainersRetrying error %v, want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("listContainersRetrying returned after %v, want as soon as the context is done", elapsed)
+	}
+	if *attempts != 1 {
+		t.Errorf("%d attempts, want 1 before the context is done", *attempts)
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package hcnproxyctrl
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// SIDs of the built-in service accounts, besides LocalSystemSID.
+const (
+	LocalServiceSID   = "S-1-5-19"
+	NetworkServiceSID = "S-1-5-20"
+)
+
+// sidShorthands maps the lowercase shorthands accepted in place of a UserSID
+// to the SID they stand for.
+var sidShorthands = map[string]string{
+	"system":         LocalSystemSID,
+	"localsystem":    LocalSystemSID,
+	"localservice":   LocalServiceSID,
+	"networkservice": NetworkServiceSID,
+}
+
+// sidPattern matches the string form of a Windows security identifier,
+// e.g. "S-1-5-18" or "S-1-5-21-1688553208-1784504425-564974220-1000".
+var sidPattern = regexp.MustCompile(`^S-1-[0-9]+(-[0-9]+)+$`)
+
+// lookupAccountSID resolves an account name to the string form of its SID.
+// It is a variable so that the resolution can be replaced where the Windows
+// account database is not available.
+var lookupAccountSID = lookupAccountName
+
+// ValidateSID returns nil iff the provided string is a well-formed SID.
+func ValidateSID(sid string) error {
+	if !sidPattern.MatchString(sid) {
+		return fmt.Errorf("invalid SID %q", sid)
+	}
+	return nil
+}
+
+// resolveUserSID returns the SID designated by a UserSID value, which is either
+// a SID, in any case, or one of the case-insensitive shorthands "system",
+// "localsystem", "localservice" and "networkservice". The SID is returned in
+// uppercase, as Windows formats them.
+func resolveUserSID(userSID string) (string, error) {
+	if sid, ok := sidShorthands[strings.ToLower(userSID)]; ok {
+		return sid, nil
+	}
+	sid := strings.ToUpper(userSID)
+	if err := ValidateSID(sid); err != nil {
+		return "", err
+	}
+	return sid, nil
+}
+
+// validateUserSID returns an error echoing the UserSID value if it is neither
+// a well-formed SID nor one of the shorthands.
+func validateUserSID(userSID string) error {
+	if _, err := resolveUserSID(userSID); err != nil {
+		var shorthands []string
+		for shorthand := range sidShorthands {
+			shorthands = append(shorthands, shorthand)
+		}
+		sort.Strings(shorthands)
+		return fmt.Errorf("invalid user SID %q: expected a SID such as S-1-5-18, or one of %s", userSID, strings.Join(shorthands, ", "))
+	}
+	return nil
+}
+
+// LookupAccountSID returns the SID of the specified Windows account
+// (eg. "NT SERVICE\envoy" or "CONTOSO\proxy-svc"). It is meant to be used to
+// fill the UserSID field of a Policy when the proxy runs under a dedicated
+// account instead of Local System.
+func LookupAccountSID(account string) (string, error) {
+	sid, err := lookupAccountSID(account)
+	if err != nil {
+		return "", fmt.Errorf("could not resolve the SID of account %q: %v", account, err)
+	}
+	sid = strings.ToUpper(sid)
+	if err := ValidateSID(sid); err != nil {
+		return "", fmt.Errorf("account %q resolved to an %v", account, err)
+	}
+	return sid, nil
+}
@@ -0,0 +1,133 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package hcnproxyctrl
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// setLookupAccountSID replaces the account resolution for the duration of the
+// test.
+func setLookupAccountSID(t *testing.T, lookup func(account string) (string, error)) {
+	previous := lookupAccountSID
+	lookupAccountSID = lookup
+	t.Cleanup(func() { lookupAccountSID = previous })
+}
+
+func TestValidateSID(t *testing.T) {
+	tests := []struct {
+		sid   string
+		valid bool
+	}{
+		{"S-1-5-18", true},
+		{"S-1-5-21-1688553208-1784504425-564974220-1000", true},
+		{"S-1-5", false},
+		{"S-1-5-", false},
+		{"S-2-5-18", false},
+		{"system", false},
+		{"", false},
+	}
+	for _, test := range tests {
+		if err := ValidateSID(test.sid); (err == nil) != test.valid {
+			t.Errorf("ValidateSID(%q) = %v, want valid = %v", test.sid, err, test.valid)
+		}
+	}
+}
+
+func TestLookupAccountSID(t *testing.T) {
+	accounts := map[string]string{
+		`NT SERVICE\envoy`:   "S-1-5-80-1234-5678",
+		`CONTOSO\proxy-svc`:  "S-1-5-21-1688553208-1784504425-564974220-1000",
+		`CONTOSO\broken-svc`: "not a SID",
+	}
+	setLookupAccountSID(t, func(account string) (string, error) {
+		sid, ok := accounts[account]
+		if !ok {
+			return "", errors.New("no mapping between account names and security IDs was done")
+		}
+		return sid, nil
+	})
+
+	tests := []struct {
+		account string
+		sid     string
+		err     string
+	}{
+		{account: `NT SERVICE\envoy`, sid: "S-1-5-80-1234-5678"},
+		{account: `CONTOSO\proxy-svc`, sid: "S-1-5-21-1688553208-1784504425-564974220-1000"},
+		{account: `CONTOSO\unknown`, err: `could not resolve the SID of account "CONTOSO\\unknown"`},
+		{account: `CONTOSO\broken-svc`, err: `account "CONTOSO\\broken-svc" resolved to an invalid SID`},
+	}
+	for _, test := range tests {
+		sid, err := LookupAccountSID(test.account)
+		if len(test.err) > 0 {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("LookupAccountSID(%q) = %q, %v, want error containing %q", test.account, sid, err, test.err)
+			}
+			continue
+		}
+		if err != nil || sid != test.sid {
+			t.Errorf("LookupAccountSID(%q) = %q, %v, want %q", test.account, sid, err, test.sid)
+		}
+	}
+}
+
+func TestResolveUserSID(t *testing.T) {
+	tests := []struct {
+		userSID string
+		sid     string
+		valid   bool
+	}{
+		{"S-1-5-18", "S-1-5-18", true},
+		{"s-1-5-18", "S-1-5-18", true},
+		{"s-1-5-21-1688553208-1784504425-564974220-1000", "S-1-5-21-1688553208-1784504425-564974220-1000", true},
+		{"system", LocalSystemSID, true},
+		{"SYSTEM", LocalSystemSID, true},
+		{"LocalSystem", LocalSystemSID, true},
+		{"localservice", LocalServiceSID, true},
+		{"NetworkService", NetworkServiceSID, true},
+		{"nobody", "", false},
+		{"s-1-5", "", false},
+	}
+	for _, test := range tests {
+		sid, err := resolveUserSID(test.userSID)
+		if (err == nil) != test.valid || sid != test.sid {
+			t.Errorf("resolveUserSID(%q) = %q, %v, want %q (valid = %v)", test.userSID, sid, err, test.sid, test.valid)
+		}
+		if err := validateUserSID(test.userSID); (err == nil) != test.valid {
+			t.Errorf("validateUserSID(%q) = %v, want valid = %v", test.userSID, err, test.valid)
+		}
+	}
+}
+
+func TestValidateUserSIDListsShorthands(t *testing.T) {
+	err := validateUserSID("nobody")
+	if err == nil {
+		t.Fatal("validateUserSID accepted an invalid SID")
+	}
+	for shorthand := range sidShorthands {
+		if !strings.Contains(err.Error(), shorthand) {
+			t.Errorf("error %q does not list the shorthand %q", err, shorthand)
+		}
+	}
+}
+
+func TestLookedUpSIDInPolicy(t *testing.T) {
+	// Windows formats SIDs in uppercase, but the resolver should not have
+	// to for the SID to be usable in a policy.
+	setLookupAccountSID(t, func(account string) (string, error) {
+		return "s-1-5-80-1234-5678", nil
+	})
+
+	sid, err := LookupAccountSID(`NT SERVICE\envoy`)
+	if err != nil || sid != "S-1-5-80-1234-5678" {
+		t.Fatalf("LookupAccountSID = %q, %v, want S-1-5-80-1234-5678", sid, err)
+	}
+	effective, err := EffectivePolicy(Policy{ProxyPort: "15001", UserSID: sid})
+	if err != nil || effective.UserSID != sid {
+		t.Errorf("EffectivePolicy = %+v, %v, want user SID %s", effective, err, sid)
+	}
+}
@@ -0,0 +1,13 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+//go:build !windows
+// +build !windows
+
+package hcnproxyctrl
+
+import "errors"
+
+func lookupAccountName(account string) (string, error) {
+	return "", errors.New("account lookup is only supported on Windows")
+}
@@ -0,0 +1,15 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package hcnproxyctrl
+
+import "syscall"
+
+// lookupAccountName calls LookupAccountName on the local system.
+func lookupAccountName(account string) (string, error) {
+	sid, _, _, err := syscall.LookupSID("", account)
+	if err != nil {
+		return "", err
+	}
+	return sid.String()
+}
@@ -0,0 +1,332 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package hcnproxyctrl
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+)
+
+// TupleRange describes a set of connections, as seen from the endpoint, to be
+// evaluated against proxy policies. It is like a Tuple, except that addresses
+// can be IPv4 CIDRs and ports can be port ranges. Empty addresses and ports
+// stand for all IPv4 addresses and all ports respectively.
+type TupleRange struct {
+	Protocol string
+
+	LocalAddresses  string
+	LocalPorts      string
+	RemoteAddresses string
+	RemotePorts     string
+
+	UserSID string
+}
+
+// Interception is a subset of a TupleRange, whose connections are all
+// intercepted by the same policy, or by none.
+type Interception struct {
+	// Policy is the policy intercepting the connections, if Intercepted.
+	Policy      Policy
+	Intercepted bool
+
+	// Comma-separated IPv4 addresses and CIDRs
+	LocalAddresses  string
+	RemoteAddresses string
+	// Port or port range
+	LocalPorts  string
+	RemotePorts string
+}
+
+// interval is an inclusive range of IPv4 addresses or ports.
+type interval struct {
+	low, high int64
+}
+
+// Dimensions of the connection space
+const (
+	localAddressDim = iota
+	localPortDim
+	remoteAddressDim
+	remotePortDim
+	numDims
+)
+
+// cell is a box of the connection space, ie. the cross product of an interval
+// in each dimension, whose connections are all intercepted by the same policy
+// (index into the policies, -1 for none).
+type cell struct {
+	intervals [numDims]interval
+	policy    int
+}
+
+// Simulate splits the connections of the range into subsets that are each
+// intercepted by a single policy, or by none, according to MatchingPolicy.
+// Adjacent subsets intercepted by the same policy are merged where possible.
+func Simulate(policies []Policy, tupleRange TupleRange) ([]Interception, error) {
+	var bounds [numDims]interval
+	var err error
+	if bounds[localAddressDim], err = parseAddressInterval(tupleRange.LocalAddresses); err != nil {
+		return nil, err
+	}
+	if bounds[localPortDim], err = parsePortInterval(tupleRange.LocalPorts); err != nil {
+		return nil, err
+	}
+	if bounds[remoteAddressDim], err = parseAddressInterval(tupleRange.RemoteAddresses); err != nil {
+		return nil, err
+	}
+	if bounds[remotePortDim], err = parsePortInterval(tupleRange.RemotePorts); err != nil {
+		return nil, err
+	}
+
+	sorted := append([]Policy(nil), policies...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Priority > sorted[j].Priority })
+
+	// Split each dimension at the bounds of the policy filters, so that each
+	// policy either matches all the connections of a cell or none.
+	var splits [numDims][]interval
+	for dim := range splits {
+		var filters []interval
+		for _, policy := range sorted {
+			filters = append(filters, policyIntervals(policy, dim)...)
+		}
+		splits[dim] = splitInterval(bounds[dim], filters)
+	}
+
+	var cells []cell
+	var indices [numDims]int
+	for {
+		var c cell
+		for dim := range indices {
+			c.intervals[dim] = splits[dim][indices[dim]]
+		}
+		c.policy = matchCell(sorted, tupleRange, c)
+		cells = append(cells, c)
+
+		// Move on to the next cell, the last dimension varying the fastest
+		dim := numDims - 1
+		for ; dim >= 0; dim-- {
+			indices[dim]++
+			if indices[dim] < len(splits[dim]) {
+				break
+			}
+			indices[dim] = 0
+		}
+		if dim < 0 {
+			break
+		}
+	}
+
+	for dim := numDims - 1; dim >= 0; dim-- {
+		cells = mergeCells(cells, dim)
+	}
+
+	interceptions := make([]Interception, len(cells))
+	for i, c := range cells {
+		interceptions[i] = Interception{
+			LocalAddresses:  formatAddressInterval(c.intervals[localAddressDim]),
+			LocalPorts:      portInterval{int(c.intervals[localPortDim].low), int(c.intervals[localPortDim].high)}.String(),
+			RemoteAddresses: formatAddressInterval(c.intervals[remoteAddressDim]),
+			RemotePorts:     portInterval{int(c.intervals[remotePortDim].low), int(c.intervals[remotePortDim].high)}.String(),
+		}
+		if c.policy >= 0 {
+			interceptions[i].Policy = sorted[c.policy]
+			interceptions[i].Intercepted = true
+		}
+	}
+	return interceptions, nil
+}
+
+// matchCell returns the index of the first policy intercepting the
+// connections of the cell, or -1 if there is none. As policies match all the
+// connections of a cell or none, its lowest connection is checked.
+func matchCell(sorted []Policy, tupleRange TupleRange, c cell) int {
+	tuple := Tuple{
+		Protocol:      tupleRange.Protocol,
+		LocalAddress:  formatIPv4(c.intervals[localAddressDim].low),
+		LocalPort:     fmt.Sprint(c.intervals[localPortDim].low),
+		RemoteAddress: formatIPv4(c.intervals[remoteAddressDim].low),
+		RemotePort:    fmt.Sprint(c.intervals[remotePortDim].low),
+		UserSID:       tupleRange.UserSID,
+	}
+	for i, policy := range sorted {
+		if policy.Matches(tuple) {
+			return i
+		}
+	}
+	return -1
+}
+
+// mergeCells merges the cells that are adjacent along a dimension, are
+// identical along the others, and are intercepted by the same policy.
+func mergeCells(cells []cell, dim int) []cell {
+	sort.SliceStable(cells, func(i, j int) bool {
+		for d := 0; d < numDims; d++ {
+			if d == dim {
+				continue
+			}
+			if cells[i].intervals[d] != cells[j].intervals[d] {
+				return cells[i].intervals[d].low < cells[j].intervals[d].low
+			}
+		}
+		return cells[i].intervals[dim].low < cells[j].intervals[dim].low
+	})
+
+	var merged []cell
+	for _, c := range cells {
+		if n := len(merged); n > 0 {
+			last := &merged[n-1]
+			if last.policy == c.policy && last.intervals[dim].high+1 == c.intervals[dim].low && sameOtherIntervals(*last, c, dim) {
+				last.intervals[dim].high = c.intervals[dim].high
+				continue
+			}
+		}
+		merged = append(merged, c)
+	}
+	return merged
+}
+
+func sameOtherIntervals(a, b cell, dim int) bool {
+	for d := 0; d < numDims; d++ {
+		if d != dim && a.intervals[d] != b.intervals[d] {
+			return false
+		}
+	}
+	return true
+}
+
+// policyIntervals returns the intervals of the policy filter for a dimension.
+// Invalid and IPv6 entries are skipped, as they cannot match IPv4 connections.
+func policyIntervals(policy Policy, dim int) []interval {
+	switch dim {
+	case localPortDim, remotePortDim:
+		ports := policy.LocalPorts
+		if dim == remotePortDim {
+			ports = policy.RemotePorts
+		}
+		if len(ports) == 0 {
+			return nil
+		}
+		r, err := parsePortRange(ports)
+		if err != nil {
+			return nil
+		}
+		return []interval{{int64(r.low), int64(r.high)}}
+	}
+
+	addresses := policy.LocalAddresses
+	if dim == remoteAddressDim {
+		addresses = policy.RemoteAddresses
+	}
+	if len(addresses) == 0 {
+		return nil
+	}
+	var intervals []interval
+	for _, address := range strings.Split(addresses, ",") {
+		network, err := parseIPv4Network(strings.TrimSpace(address))
+		if err != nil || network == nil {
+			continue
+		}
+		intervals = append(intervals, networkInterval(network))
+	}
+	return intervals
+}
+
+// splitInterval splits bounds at the edges of the filters.
+func splitInterval(bounds interval, filters []interval) []interval {
+	edges := map[int64]bool{bounds.low: true}
+	for _, filter := range filters {
+		for _, edge := range []int64{filter.low, filter.high + 1} {
+			if edge > bounds.low && edge <= bounds.high {
+				edges[edge] = true
+			}
+		}
+	}
+
+	var starts []int64
+	for edge := range edges {
+		starts = append(starts, edge)
+	}
+	sort.Slice(starts, func(i, j int) bool { return starts[i] < starts[j] })
+
+	intervals := make([]interval, len(starts))
+	for i, start := range starts {
+		high := bounds.high
+		if i+1 < len(starts) {
+			high = starts[i+1] - 1
+		}
+		intervals[i] = interval{start, high}
+	}
+	return intervals
+}
+
+// parseAddressInterval parses an IPv4 address or CIDR, an empty string
+// standing for all IPv4 addresses.
+func parseAddressInterval(address string) (interval, error) {
+	if len(address) == 0 {
+		address = "0.0.0.0/0"
+	}
+	network, err := parseIPv4Network(address)
+	if err != nil {
+		return interval{}, err
+	}
+	if network == nil {
+		return interval{}, fmt.Errorf("address %q is not IPv4, only IPv4 addresses are supported in ranges", address)
+	}
+	return networkInterval(network), nil
+}
+
+// parsePortInterval parses a port or port range, an empty string standing for
+// all ports.
+func parsePortInterval(ports string) (interval, error) {
+	if len(ports) == 0 {
+		ports = "1-65535"
+	}
+	r, err := parsePortRange(ports)
+	if err != nil {
+		return interval{}, err
+	}
+	if r.low > r.high {
+		return interval{}, fmt.Errorf("invalid port range %q", ports)
+	}
+	return interval{int64(r.low), int64(r.high)}, nil
+}
+
+// networkInterval returns the interval of the addresses of an IPv4 network.
+func networkInterval(network *net.IPNet) interval {
+	ones, _ := network.Mask.Size()
+	low := int64(binary.BigEndian.Uint32(network.IP.To4()))
+	return interval{low, low + 1<<uint(32-ones) - 1}
+}
+
+// formatAddressInterval formats an interval of IPv4 addresses as the
+// comma-separated list of the fewest CIDRs covering it, single addresses
+// being formatted without a prefix length.
+func formatAddressInterval(r interval) string {
+	var cidrs []string
+	for low := r.low; low <= r.high; {
+		// Find the largest aligned block starting at low within the interval
+		size := int64(1)
+		ones := 32
+		for ones > 0 && low%(size*2) == 0 && low+size*2-1 <= r.high {
+			size *= 2
+			ones--
+		}
+		if ones == 32 {
+			cidrs = append(cidrs, formatIPv4(low))
+		} else {
+			cidrs = append(cidrs, fmt.Sprintf("%s/%d", formatIPv4(low), ones))
+		}
+		low += size
+	}
+	return strings.Join(cidrs, ",")
+}
+
+func formatIPv4(address int64) string {
+	ip := make(net.IP, 4)
+	binary.BigEndian.PutUint32(ip, uint32(address))
+	return ip.String()
+}
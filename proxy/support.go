@@ -0,0 +1,43 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package hcnproxyctrl
+
+import (
+	"errors"
+
+	"github.com/Microsoft/hcsshim/hcn"
+)
+
+// ErrProxyNotSupported is returned when adding a proxy policy to an endpoint
+// that does not support them.
+var ErrProxyNotSupported = errors.New("the endpoint does not support proxy policies")
+
+// proxyNetworkTypes are the types of HNS networks whose endpoints support
+// proxy policies.
+var proxyNetworkTypes = map[hcn.NetworkType]bool{
+	hcn.NAT:      true,
+	hcn.L2Bridge: true,
+	hcn.L2Tunnel: true,
+	hcn.Overlay:  true,
+}
+
+// SupportsProxyPolicy returns true iff proxy policies can be added to the
+// endpoint, which requires both HNS and the type of the endpoint's network
+// to support them.
+func SupportsProxyPolicy(hnsEndpointID string) (bool, error) {
+	if err := l4WfpProxyPolicySupported(); err != nil {
+		return false, nil
+	}
+
+	endpoint, err := getEndpointByID(hnsEndpointID)
+	if err != nil {
+		return false, err
+	}
+	network, err := getNetworkByID(endpoint.HostComputeNetwork)
+	if err != nil {
+		return false, err
+	}
+
+	return proxyNetworkTypes[network.Type], nil
+}
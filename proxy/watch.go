@@ -0,0 +1,155 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package hcnproxyctrl
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/Microsoft/hcsshim/hcn"
+)
+
+// EndpointFilter selects the endpoints WatchEndpoints reports. Empty fields
+// match any endpoint.
+type EndpointFilter struct {
+	// NetworkID is the ID of the HNS network the endpoint must belong to.
+	NetworkID string
+	// NamePattern is a pattern the name of the endpoint must match, with the
+	// syntax of path.Match (eg. "cni-*").
+	NamePattern string
+}
+
+// Matches returns true iff the endpoint is selected by the filter.
+func (filter EndpointFilter) Matches(endpoint hcn.HostComputeEndpoint) bool {
+	if len(filter.NetworkID) > 0 && !strings.EqualFold(filter.NetworkID, endpoint.HostComputeNetwork) {
+		return false
+	}
+	if len(filter.NamePattern) > 0 {
+		if matched, err := path.Match(filter.NamePattern, endpoint.Name); err != nil || !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// EndpointSource lists the HNS endpoints. hcn.ListEndpoints is the one to use
+// outside of tests.
+type EndpointSource func() ([]hcn.HostComputeEndpoint, error)
+
+// WatchOptions tunes the behavior of WatchEndpoints.
+type WatchOptions struct {
+	// Interval is how often the endpoints are listed.
+	Interval time.Duration
+	// IncludeExisting reports the endpoints that exist when the watch
+	// starts; otherwise, only the endpoints created afterwards are.
+	IncludeExisting bool
+}
+
+// WatchEndpoints lists the endpoints at every interval, and calls onNew with
+// each new endpoint selected by the filter, until ctx is done. HNS does not
+// notify of endpoint creations, hence the polling.
+// If onNew fails, it is called again with the endpoint at the next interval.
+// Deleted endpoints are forgotten, so that an endpoint re-created with the
+// same ID is reported again. Failures, including the ones of onNew, are
+// reported through logf and do not stop the watch. An error is returned if
+// the endpoints could not be listed initially; WatchEndpoints returns nil
+// once ctx is done.
+func WatchEndpoints(ctx context.Context, source EndpointSource, filter EndpointFilter, options WatchOptions,
+	onNew func(endpoint hcn.HostComputeEndpoint) error, logf func(format string, args ...interface{})) error {
+	seen := make(map[string]bool)
+	if !options.IncludeExisting {
+		endpoints, err := source()
+		if err != nil {
+			return err
+		}
+		for _, endpoint := range endpoints {
+			seen[endpoint.Id] = true
+		}
+	}
+
+	ticker := time.NewTicker(options.Interval)
+	defer ticker.Stop()
+	for {
+		if err := pollEndpoints(source, filter, seen, onNew); err != nil {
+			logf("%v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// pollEndpoints calls onNew with each endpoint selected by the filter that is
+// not in seen, and updates seen with the endpoints that are now handled or
+// that no longer exist.
+func pollEndpoints(source EndpointSource, filter EndpointFilter, seen map[string]bool,
+	onNew func(endpoint hcn.HostComputeEndpoint) error) error {
+	endpoints, err := source()
+	if err != nil {
+		return fmt.Errorf("could not list the endpoints: %v", err)
+	}
+
+	current := make(map[string]bool, len(endpoints))
+	var failures []string
+	for _, endpoint := range endpoints {
+		current[endpoint.Id] = true
+		if seen[endpoint.Id] {
+			continue
+		}
+		if !filter.Matches(endpoint) {
+			// Endpoints cannot move to another network or be renamed
+			seen[endpoint.Id] = true
+			continue
+		}
+		if err := onNew(endpoint); err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", endpoint.Id, err))
+			continue
+		}
+		seen[endpoint.Id] = true
+	}
+
+	for id := range seen {
+		if !current[id] {
+			delete(seen, id)
+		}
+	}
+
+	if len(failures) > 0 {
+		return fmt.Errorf("could not handle new endpoints (will retry): %s", strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+// ResolveNetworkID returns the ID of the HNS network designated by either its
+// ID or its name, IDs taking precedence over names.
+func ResolveNetworkID(networkIDOrName string) (string, error) {
+	networks, err := listNetworks()
+	if err != nil {
+		return "", err
+	}
+
+	var matches []string
+	for _, network := range networks {
+		if strings.EqualFold(network.Id, networkIDOrName) {
+			return network.Id, nil
+		}
+		if network.Name == networkIDOrName {
+			matches = append(matches, network.Id)
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("could not find a network with ID or name %q", networkIDOrName)
+	case 1:
+		return matches[0], nil
+	}
+	return "", fmt.Errorf("several networks are named %q: %s", networkIDOrName, strings.Join(matches, ", "))
+}